@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/jobs"
 	"github.com/jkaninda/goma-admin/internal/routes"
 	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
@@ -16,15 +17,30 @@ func main() {
 	app := okapi.New()
 	cli := okapicli.New(app, "Goma").
 		String("config", "c", "config.yaml", "Path to configuration file").
-		Int("port", "p", 8080, "HTTP server port")
+		Int("port", "p", 8080, "HTTP server port").
+		Bool("reencrypt-secrets", "", false, "Re-encrypt the stored secrets with the current master key, then exit")
 	conf, err := config.New(app, cli)
 	if err != nil {
 		logger.Fatal("Failed to initialize config", "error", err)
 
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cli.GetBool("reencrypt-secrets") {
+		if err := jobs.ReencryptSecrets(ctx, conf.Database.DB, conf.Secrets.Cipher); err != nil {
+			logger.Fatal("Failed to re-encrypt secrets", "error", err)
+		}
+		return
+	}
+	// Secrets stored before encryption was introduced are encrypted before serving requests
+	if err := jobs.EncryptPlaintextSecrets(ctx, conf.Database.DB, conf.Secrets.Cipher); err != nil {
+		logger.Fatal("Failed to encrypt secrets", "error", err)
+	}
+
 	// Create the route instance
-	route := routes.NewRouter(context.Background(), app, conf)
+	route := routes.NewRouter(ctx, app, conf)
 	// Register routes
 	route.RegisterRoutes()
 	// Start the server
@@ -33,6 +49,10 @@ func main() {
 		Signals:         []os.Signal{okapicli.SIGINT, okapicli.SIGTERM}, // Optional: customize shutdown signals
 		OnStart: func() {
 			logger.Info("Preparing resources before startup")
+			jobs.StartSessionCleanup(ctx, conf.Database.DB, conf.Auth.SessionCleanupInterval)
+			jobs.StartLDAPSync(ctx, conf.Database.DB, &conf.LDAP)
+			jobs.StartReportCleanup(ctx, conf.Database.DB, conf.Provider.ReportRetention)
+			jobs.StartNonceCleanup(ctx, conf.Database.DB)
 
 		},
 		OnStarted: func() {
@@ -40,6 +60,7 @@ func main() {
 		},
 		OnShutdown: func() {
 			logger.Info("Cleaning up before shutdown")
+			cancel()
 		},
 	}); err != nil {
 		logger.Fatal("Failed to start server", "error", err)
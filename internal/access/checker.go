@@ -0,0 +1,81 @@
+package access
+
+import (
+	"context"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"gorm.io/gorm"
+)
+
+// unrestrictedRole is the minimum role allowed to modify every instance without a grant
+const unrestrictedRole = models.RoleAdmin
+
+// Checker evaluates environment-scoped permission grants for instances
+// and the routes and middlewares deployed to them
+type Checker struct {
+	grantRepo      *repository.PermissionRepository
+	instanceRepo   *repository.InstanceRepository
+	middlewareRepo *repository.MiddlewareRepository
+}
+
+func NewChecker(db *gorm.DB) *Checker {
+	return &Checker{
+		grantRepo:      repository.NewPermissionRepository(db),
+		instanceRepo:   repository.NewInstanceRepository(db),
+		middlewareRepo: repository.NewMiddlewareRepository(db),
+	}
+}
+
+// CanModifyInstance checks if the role may change the instance or the routes attached to it
+func (ch *Checker) CanModifyInstance(ctx context.Context, role models.UserRole, instance *models.Instance) (bool, error) {
+	if role.CanAccess(unrestrictedRole) {
+		return true, nil
+	}
+	grants, err := ch.grantRepo.ListByRole(ctx, string(role))
+	if err != nil {
+		return false, err
+	}
+	for i := range grants {
+		if grants[i].Matches(instance) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CanModifyRoute checks if the role may modify every instance the route is attached to
+func (ch *Checker) CanModifyRoute(ctx context.Context, role models.UserRole, routeID uint) (bool, error) {
+	if role.CanAccess(unrestrictedRole) {
+		return true, nil
+	}
+	instances, err := ch.instanceRepo.GetInstancesByRoute(ctx, routeID)
+	if err != nil {
+		return false, err
+	}
+	for i := range instances {
+		allowed, err := ch.CanModifyInstance(ctx, role, &instances[i])
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// CanModifyMiddleware checks if the role may modify every route using the middleware
+func (ch *Checker) CanModifyMiddleware(ctx context.Context, role models.UserRole, middlewareName string) (bool, error) {
+	if role.CanAccess(unrestrictedRole) {
+		return true, nil
+	}
+	routes, err := ch.middlewareRepo.GetRoutesByMiddleware(ctx, middlewareName)
+	if err != nil {
+		return false, err
+	}
+	for _, route := range routes {
+		allowed, err := ch.CanModifyRoute(ctx, role, route.ID)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
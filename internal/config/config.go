@@ -1,12 +1,19 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"strings"
+	"time"
 
 	goutils "github.com/jkaninda/go-utils"
 	"github.com/jkaninda/goma-admin/internal/db/migration"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
 	"github.com/jkaninda/goma-admin/internal/db/seed"
+	"github.com/jkaninda/goma-admin/internal/secrets"
 	util "github.com/jkaninda/goma-admin/utils"
 	"github.com/jkaninda/okapi"
 	"github.com/jkaninda/okapi/okapicli"
@@ -34,23 +41,99 @@ func New(app *okapi.Okapi, cli *okapicli.CLI) (*Config, error) {
 			dbURL:      goutils.Env("GOMA_DB_URL", ""),
 		},
 		Redis: RedisConfig{
-			URL: goutils.Env("GOMA_REDIS_URL", "redis://localhost:6379/0"),
+			Enabled: goutils.EnvBool("GOMA_REDIS_ENABLED", false),
+			URL:     goutils.Env("GOMA_REDIS_URL", "redis://localhost:6379/0"),
 		},
 		Server: ServerConfig{
 			enableDocs:  goutils.EnvBool("GOMA_ENABLE_DOCS", true),
 			Port:        goutils.EnvInt("GOMA_PORT", port),
 			Environment: goutils.Env("GOMA_ENVIRONMENT", "development"),
+			AppURL:      strings.TrimSuffix(goutils.Env("GOMA_APP_URL", "http://localhost:3000"), "/"),
 		},
 		Cors: CorsConfig{
 			AllowedOrigins: strings.Split(goutils.Env("GOMA_CORS_ALLOWED_ORIGINS", "http://localhost:5173"), ","),
 		},
 		JWT: JWTConfig{
-			Secret:   goutils.Env("GOMA_JWT_SECRET", "default-secret-key"),
-			Issuer:   goutils.Env("GOMA_JWT_ISSUER", "goma-admin"),
-			Audience: goutils.Env("GOMA_JWT_AUDIENCE", "goma-admin"),
+			Secret:          goutils.Env("GOMA_JWT_SECRET", "default-secret-key"),
+			Issuer:          goutils.Env("GOMA_JWT_ISSUER", "goma-admin"),
+			Audience:        goutils.Env("GOMA_JWT_AUDIENCE", "goma-admin"),
+			AccessTokenTTL:  envDuration("GOMA_JWT_ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTokenTTL: envDuration("GOMA_JWT_REFRESH_TOKEN_TTL", 168*time.Hour),
 		},
 		Auth: AuthConfig{
-			AdminPassword: goutils.Env("GOMA_ADMIN_PASSWORD", "admin"),
+			AdminPassword:          goutils.Env("GOMA_ADMIN_PASSWORD", "admin"),
+			LocalLoginEnabled:      goutils.EnvBool("GOMA_AUTH_LOCAL_LOGIN_ENABLED", true),
+			MaxFailedLogins:        goutils.EnvInt("GOMA_AUTH_MAX_FAILED_LOGINS", 5),
+			LockoutDuration:        envDuration("GOMA_AUTH_LOCKOUT_DURATION", 15*time.Minute),
+			MaxLockoutDuration:     envDuration("GOMA_AUTH_MAX_LOCKOUT_DURATION", 24*time.Hour),
+			SessionCleanupInterval: envDuration("GOMA_AUTH_SESSION_CLEANUP_INTERVAL", time.Hour),
+			PasswordResetTTL:       envDuration("GOMA_AUTH_PASSWORD_RESET_TTL", time.Hour),
+			PasswordPolicy: PasswordPolicy{
+				MinLength:        goutils.EnvInt("GOMA_AUTH_PASSWORD_MIN_LENGTH", 8),
+				RequireUppercase: goutils.EnvBool("GOMA_AUTH_PASSWORD_REQUIRE_UPPERCASE", false),
+				RequireLowercase: goutils.EnvBool("GOMA_AUTH_PASSWORD_REQUIRE_LOWERCASE", false),
+				RequireDigit:     goutils.EnvBool("GOMA_AUTH_PASSWORD_REQUIRE_DIGIT", false),
+				RequireSymbol:    goutils.EnvBool("GOMA_AUTH_PASSWORD_REQUIRE_SYMBOL", false),
+			},
+		},
+		OIDC: OIDCConfig{
+			Enabled:      goutils.EnvBool("GOMA_OIDC_ENABLED", false),
+			DisplayName:  goutils.Env("GOMA_OIDC_DISPLAY_NAME", "Single Sign-On"),
+			IssuerURL:    goutils.Env("GOMA_OIDC_ISSUER_URL", ""),
+			ClientID:     goutils.Env("GOMA_OIDC_CLIENT_ID", ""),
+			ClientSecret: goutils.Env("GOMA_OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  goutils.Env("GOMA_OIDC_REDIRECT_URL", fmt.Sprintf("http://localhost:%d/api/v1/auth/oidc/callback", port)),
+			Scopes:       envList("GOMA_OIDC_SCOPES", "openid,profile,email"),
+			GroupsClaim:  goutils.Env("GOMA_OIDC_GROUPS_CLAIM", "groups"),
+			RoleMapping:  envMap("GOMA_OIDC_ROLE_MAPPING"),
+			DefaultRole:  goutils.Env("GOMA_OIDC_DEFAULT_ROLE", "viewer"),
+			AllowSignup:  goutils.EnvBool("GOMA_OIDC_ALLOW_SIGNUP", true),
+			LinkByEmail:  goutils.EnvBool("GOMA_OIDC_LINK_BY_EMAIL", false),
+		},
+		LDAP: LDAPConfig{
+			Enabled:            goutils.EnvBool("GOMA_LDAP_ENABLED", false),
+			URL:                goutils.Env("GOMA_LDAP_URL", ""),
+			StartTLS:           goutils.EnvBool("GOMA_LDAP_START_TLS", false),
+			AllowInsecure:      goutils.EnvBool("GOMA_LDAP_ALLOW_INSECURE", false),
+			InsecureSkipVerify: goutils.EnvBool("GOMA_LDAP_INSECURE_SKIP_VERIFY", false),
+			CACertFile:         goutils.Env("GOMA_LDAP_CA_CERT_FILE", ""),
+			BindDN:             goutils.Env("GOMA_LDAP_BIND_DN", ""),
+			BindPassword:       goutils.Env("GOMA_LDAP_BIND_PASSWORD", ""),
+			BaseDN:             goutils.Env("GOMA_LDAP_BASE_DN", ""),
+			UserFilter:         goutils.Env("GOMA_LDAP_USER_FILTER", "(|(uid={login})(mail={login}))"),
+			UsernameAttribute:  goutils.Env("GOMA_LDAP_USERNAME_ATTRIBUTE", "uid"),
+			EmailAttribute:     goutils.Env("GOMA_LDAP_EMAIL_ATTRIBUTE", "mail"),
+			NameAttribute:      goutils.Env("GOMA_LDAP_NAME_ATTRIBUTE", "cn"),
+			GroupAttribute:     goutils.Env("GOMA_LDAP_GROUP_ATTRIBUTE", "memberOf"),
+			GroupBaseDN:        goutils.Env("GOMA_LDAP_GROUP_BASE_DN", ""),
+			GroupFilter:        goutils.Env("GOMA_LDAP_GROUP_FILTER", "(|(member={dn})(uniqueMember={dn}))"),
+			RoleMapping:        envMap("GOMA_LDAP_ROLE_MAPPING"),
+			DefaultRole:        goutils.Env("GOMA_LDAP_DEFAULT_ROLE", "viewer"),
+			AllowSignup:        goutils.EnvBool("GOMA_LDAP_ALLOW_SIGNUP", true),
+			SyncInterval:       envDuration("GOMA_LDAP_SYNC_INTERVAL", time.Hour),
+			Timeout:            envDuration("GOMA_LDAP_TIMEOUT", 10*time.Second),
+		},
+		Provider: ProviderConfig{
+			CredentialGracePeriod: envDuration("GOMA_PROVIDER_CREDENTIAL_GRACE_PERIOD", 24*time.Hour),
+			SignatureMaxSkew:      envDuration("GOMA_PROVIDER_SIGNATURE_MAX_SKEW", 5*time.Minute),
+			ClientCertHeader:      goutils.Env("GOMA_PROVIDER_CLIENT_CERT_HEADER", ""),
+			MaxWait:               envDuration("GOMA_PROVIDER_MAX_WAIT", time.Minute),
+			ReportRetention:       envDuration("GOMA_PROVIDER_REPORT_RETENTION", 7*24*time.Hour),
+			EnrollmentEnabled:     goutils.EnvBool("GOMA_PROVIDER_ENROLLMENT_ENABLED", false),
+		},
+		Secrets: SecretsConfig{
+			KMS:     goutils.Env("GOMA_SECRETS_KMS", "local"),
+			KeyFile: goutils.Env("GOMA_SECRETS_KEY_FILE", "secrets.key"),
+			InitKey: goutils.EnvBool("GOMA_SECRETS_INIT_KEY", false),
+		},
+		Mail: MailConfig{
+			Driver:       goutils.Env("GOMA_MAIL_DRIVER", "log"),
+			From:         goutils.Env("GOMA_MAIL_FROM", "Goma Admin <noreply@localhost>"),
+			Directory:    goutils.Env("GOMA_MAIL_DIRECTORY", "mail"),
+			SMTPHost:     goutils.Env("GOMA_SMTP_HOST", ""),
+			SMTPPort:     goutils.EnvInt("GOMA_SMTP_PORT", 587),
+			SMTPUsername: goutils.Env("GOMA_SMTP_USERNAME", ""),
+			SMTPPassword: goutils.Env("GOMA_SMTP_PASSWORD", ""),
 		},
 		Log: LogConfig{
 			Level: goutils.Env("GOMA_LOG_LEVEL", "info"),
@@ -69,6 +152,74 @@ func (c *Config) validate() error {
 	if c.JWT.Secret == "" {
 		return fmt.Errorf("GOMA_JWT_SECRET is required")
 	}
+	if c.JWT.AccessTokenTTL <= 0 || c.JWT.RefreshTokenTTL <= 0 {
+		return fmt.Errorf("GOMA_JWT_ACCESS_TOKEN_TTL and GOMA_JWT_REFRESH_TOKEN_TTL must be positive durations")
+	}
+	if c.Auth.MaxFailedLogins <= 0 {
+		return fmt.Errorf("GOMA_AUTH_MAX_FAILED_LOGINS must be greater than zero")
+	}
+	if c.Auth.PasswordPolicy.MinLength < 1 {
+		return fmt.Errorf("GOMA_AUTH_PASSWORD_MIN_LENGTH must be greater than zero")
+	}
+	if c.OIDC.Enabled {
+		if c.OIDC.IssuerURL == "" || c.OIDC.ClientID == "" {
+			return fmt.Errorf("GOMA_OIDC_ISSUER_URL and GOMA_OIDC_CLIENT_ID are required when OIDC is enabled")
+		}
+		for group, role := range c.OIDC.RoleMapping {
+			if !models.UserRole(role).IsValid() {
+				return fmt.Errorf("invalid role %q mapped to group %q in GOMA_OIDC_ROLE_MAPPING", role, group)
+			}
+		}
+		if c.OIDC.DefaultRole != "" && !models.UserRole(c.OIDC.DefaultRole).IsValid() {
+			return fmt.Errorf("invalid GOMA_OIDC_DEFAULT_ROLE: %s", c.OIDC.DefaultRole)
+		}
+	}
+	if c.LDAP.Enabled {
+		if !strings.HasPrefix(c.LDAP.URL, "ldap://") && !strings.HasPrefix(c.LDAP.URL, "ldaps://") {
+			return fmt.Errorf("GOMA_LDAP_URL must be an ldap:// or ldaps:// URL when LDAP is enabled")
+		}
+		if c.LDAP.StartTLS && strings.HasPrefix(c.LDAP.URL, "ldaps://") {
+			return fmt.Errorf("GOMA_LDAP_START_TLS cannot be used with an ldaps:// URL")
+		}
+		if strings.HasPrefix(c.LDAP.URL, "ldap://") && !c.LDAP.StartTLS && !c.LDAP.AllowInsecure {
+			return fmt.Errorf("GOMA_LDAP_URL must be an ldaps:// URL or use GOMA_LDAP_START_TLS, unless GOMA_LDAP_ALLOW_INSECURE is set")
+		}
+		if c.LDAP.SyncInterval <= 0 || c.LDAP.Timeout <= 0 {
+			return fmt.Errorf("GOMA_LDAP_SYNC_INTERVAL and GOMA_LDAP_TIMEOUT must be positive durations")
+		}
+		if c.LDAP.BaseDN == "" {
+			return fmt.Errorf("GOMA_LDAP_BASE_DN is required when LDAP is enabled")
+		}
+		if !strings.Contains(c.LDAP.UserFilter, "{login}") {
+			return fmt.Errorf("GOMA_LDAP_USER_FILTER must contain the {login} placeholder")
+		}
+		for group, role := range c.LDAP.RoleMapping {
+			if !models.UserRole(role).IsValid() {
+				return fmt.Errorf("invalid role %q mapped to group %q in GOMA_LDAP_ROLE_MAPPING", role, group)
+			}
+		}
+		if c.LDAP.DefaultRole != "" && !models.UserRole(c.LDAP.DefaultRole).IsValid() {
+			return fmt.Errorf("invalid GOMA_LDAP_DEFAULT_ROLE: %s", c.LDAP.DefaultRole)
+		}
+	}
+	if c.Redis.Enabled && !strings.HasPrefix(c.Redis.URL, "redis://") && !strings.HasPrefix(c.Redis.URL, "rediss://") {
+		return fmt.Errorf("GOMA_REDIS_URL must be a redis:// or rediss:// URL when Redis is enabled")
+	}
+	if !c.Auth.LocalLoginEnabled && !c.OIDC.Enabled && !c.LDAP.Enabled {
+		return fmt.Errorf("GOMA_AUTH_LOCAL_LOGIN_ENABLED can only be disabled when another login method is enabled")
+	}
+	if c.Secrets.KMS != "local" {
+		return fmt.Errorf("unsupported GOMA_SECRETS_KMS: %s", c.Secrets.KMS)
+	}
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			return fmt.Errorf("GOMA_SMTP_HOST is required when GOMA_MAIL_DRIVER is smtp")
+		}
+	case "file", "log":
+	default:
+		return fmt.Errorf("unsupported GOMA_MAIL_DRIVER: %s", c.Mail.Driver)
+	}
 	return nil
 }
 
@@ -98,7 +249,75 @@ func (c *Config) initialize(app *okapi.Okapi) error {
 	if err := migration.AutoMigrate(c.Database.DB); err != nil {
 		return fmt.Errorf("failed to run migrations, error:%w", err)
 	}
+	// Migrations run first, so that the stored secrets can be checked before creating a missing key file
+	kms, err := c.newLocalKMS()
+	if err != nil {
+		return err
+	}
+	c.Secrets.Cipher = secrets.NewCipher(kms)
+	models.UseCipher(c.Secrets.Cipher)
 	// Run migradion
-	seed.CreateDefaultAdmin(c.Database.DB)
+	if err := seed.CreateDefaultAdmin(c.Database.DB); err != nil {
+		return fmt.Errorf("failed to seed admin user, error:%w", err)
+	}
+	if err := seed.CreateDefaultPermissionGrants(c.Database.DB); err != nil {
+		return fmt.Errorf("failed to seed permission grants, error:%w", err)
+	}
 	return nil
 }
+
+// newLocalKMS reads the secrets key file. A missing file is only created when GOMA_SECRETS_INIT_KEY is set
+// and no value of the database is encrypted yet, as a new key could not decrypt them.
+func (c *Config) newLocalKMS() (*secrets.LocalKMS, error) {
+	kms, err := secrets.NewLocalKMS(c.Secrets.KeyFile)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return kms, err
+	}
+	encrypted, err := repository.NewSecretRepository(c.Database.DB).HasEncryptedValues(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the stored secrets: %w", err)
+	}
+	if encrypted {
+		return nil, fmt.Errorf("secrets key file %s not found while the database holds encrypted secrets, restore it", c.Secrets.KeyFile)
+	}
+	if !c.Secrets.InitKey {
+		return nil, fmt.Errorf("secrets key file %s not found, restore it or set GOMA_SECRETS_INIT_KEY=true to create a new one", c.Secrets.KeyFile)
+	}
+	if err := secrets.CreateKeyFile(c.Secrets.KeyFile); err != nil {
+		return nil, fmt.Errorf("failed to create secrets key file: %w", err)
+	}
+	return secrets.NewLocalKMS(c.Secrets.KeyFile)
+}
+
+// envDuration reads a duration from the environment, falling back to the default
+// when the variable is unset or cannot be parsed
+func envDuration(envName string, defaultValue time.Duration) time.Duration {
+	value, err := goutils.ParseDuration(goutils.Env(envName, ""))
+	if err != nil || value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// envList reads a comma separated list from the environment
+func envList(envName, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(goutils.Env(envName, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envMap reads comma separated key=value pairs from the environment
+func envMap(envName string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range envList(envName, "") {
+		key, value, ok := strings.Cut(pair, "=")
+		if ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
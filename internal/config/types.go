@@ -1,6 +1,9 @@
 package config
 
 import (
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/secrets"
 	"gorm.io/gorm"
 )
 
@@ -11,6 +14,11 @@ type Config struct {
 	Cors     CorsConfig
 	JWT      JWTConfig
 	Auth     AuthConfig
+	OIDC     OIDCConfig
+	LDAP     LDAPConfig
+	Provider ProviderConfig
+	Secrets  SecretsConfig
+	Mail     MailConfig
 	Log      LogConfig
 }
 
@@ -26,16 +34,145 @@ type DatabaseConfig struct {
 }
 type AuthConfig struct {
 	AdminPassword string
+	// LocalLoginEnabled allows signing in with an email and password stored in the database
+	LocalLoginEnabled bool
+	// MaxFailedLogins is the number of consecutive failed attempts before the account is locked
+	MaxFailedLogins int
+	// LockoutDuration is the duration of the first lockout, doubled on each subsequent lockout
+	LockoutDuration time.Duration
+	// MaxLockoutDuration caps the progressive lockout duration
+	MaxLockoutDuration time.Duration
+	// SessionCleanupInterval is how often expired sessions are purged
+	SessionCleanupInterval time.Duration
+	// PasswordResetTTL is how long a password reset link remains valid
+	PasswordResetTTL time.Duration
+	PasswordPolicy   PasswordPolicy
 }
 
-type RedisConfig struct {
+// PasswordPolicy defines the requirements for user chosen passwords
+type PasswordPolicy struct {
+	MinLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+}
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider
+type OIDCConfig struct {
+	Enabled bool
+	// DisplayName is shown on the login button
+	DisplayName  string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback endpoint registered with the identity provider
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim is the ID token claim listing the groups of the user
+	GroupsClaim string
+	// RoleMapping maps identity provider groups to roles, the highest matching role wins
+	RoleMapping map[string]string
+	// DefaultRole is given to users without a mapped group, users are rejected when empty
+	DefaultRole string
+	// AllowSignup creates users on their first login
+	AllowSignup bool
+	// LinkByEmail links the first login of a user to the existing account with the same verified email,
+	// except for admin and MFA-enrolled accounts
+	LinkByEmail bool
+}
+
+// LDAPConfig configures password login against an LDAP or Active Directory server
+type LDAPConfig struct {
+	Enabled bool
+	// URL of the server, ldap:// or ldaps://
 	URL string
+	// StartTLS upgrades an ldap:// connection to TLS
+	StartTLS bool
+	// AllowInsecure allows ldap:// without StartTLS, which sends the passwords in cleartext
+	AllowInsecure      bool
+	InsecureSkipVerify bool
+	// CACertFile is a PEM file of the certificate authorities trusted to sign the server certificate
+	CACertFile string
+	// BindDN and BindPassword are the service account used to search users, anonymous when empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the user signing in, {login} is replaced by the escaped login
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	NameAttribute     string
+	// GroupAttribute lists the groups of a user on its entry, such as memberOf
+	GroupAttribute string
+	// GroupBaseDN enables searching groups with GroupFilter, {dn} is replaced by the escaped user DN
+	GroupBaseDN string
+	GroupFilter string
+	// RoleMapping maps group names or DNs to roles, the highest matching role wins
+	RoleMapping map[string]string
+	// DefaultRole is given to users without a mapped group, users are rejected when empty
+	DefaultRole string
+	// AllowSignup creates users on their first login
+	AllowSignup bool
+	// SyncInterval is how often the active flag of directory users is synchronized, roles are updated on login
+	SyncInterval time.Duration
+	Timeout      time.Duration
+}
+
+// ProviderConfig configures how gateway instances authenticate on the provider endpoints
+type ProviderConfig struct {
+	// CredentialGracePeriod is how long the previous credentials of an instance keep working after a rotation
+	CredentialGracePeriod time.Duration
+	// SignatureMaxSkew is the maximum age of the timestamp of a signed request
+	SignatureMaxSkew time.Duration
+	// ClientCertHeader is the header a TLS terminating proxy forwards the URL encoded client certificate in,
+	// certificates are read from the TLS connection when empty
+	ClientCertHeader string
+	// MaxWait caps how long a long-polling request waits for a configuration change
+	MaxWait time.Duration
+	// ReportRetention is how long the reports sent by instances to the webhook are kept
+	ReportRetention time.Duration
+	// EnrollmentEnabled lets unknown instances register themselves with an enrollment token, pending approval
+	EnrollmentEnabled bool
+}
+
+// SecretsConfig configures the encryption of the secrets stored in the database
+type SecretsConfig struct {
+	// Cipher encrypts the secrets stored in the database, set once the configuration is initialized
+	Cipher *secrets.Cipher
+	// KMS is the key management service holding the master key, only local is supported
+	KMS string
+	// KeyFile is the file holding the master keys of the local KMS
+	KeyFile string
+	// InitKey creates KeyFile with a new master key when missing, unless the database holds encrypted secrets
+	InitKey bool
+}
+
+// MailConfig configures how outgoing emails are delivered
+type MailConfig struct {
+	// Driver is one of smtp, file or log
+	Driver string
+	From   string
+	// Directory is where the file driver writes messages
+	Directory    string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+type RedisConfig struct {
+	// Enabled relays configuration changes between admin replicas through Redis pub/sub
+	Enabled bool
+	URL     string
 }
 
 type ServerConfig struct {
 	Port        int
 	Environment string
-	enableDocs  bool
+	// AppURL is the public URL of the dashboard, used to build links sent by email
+	AppURL     string
+	enableDocs bool
 }
 
 type CorsConfig struct {
@@ -43,9 +180,11 @@ type CorsConfig struct {
 }
 
 type JWTConfig struct {
-	Secret   string
-	Issuer   string
-	Audience string
+	Secret          string
+	Issuer          string
+	Audience        string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
 }
 
 type LogConfig struct {
@@ -2,6 +2,7 @@ package migration
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/jkaninda/goma-admin/internal/db/models"
 	"github.com/jkaninda/logger"
@@ -11,10 +12,18 @@ import (
 // AutoMigrate runs all database migrations
 func AutoMigrate(db *gorm.DB) error {
 	logger.Info("Running database migrations...")
+	if err := repairRouteMiddlewareIndex(db); err != nil {
+		return err
+	}
+	if err := repairAuditLogConstraint(db); err != nil {
+		return err
+	}
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.UserSession{},
 		&models.AuditLog{},
+		&models.PasswordResetToken{},
+		&models.APIToken{},
 		&models.Instance{},
 		&models.Route{},
 		&models.Backend{},
@@ -25,6 +34,12 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.Middleware{},
 		&models.RouteMiddleware{},
 		&models.InstanceRoute{},
+		&models.InstanceCredential{},
+		&models.InstanceNonce{},
+		&models.InstanceReport{},
+		&models.EnrollmentToken{},
+		&models.PermissionGrant{},
+		&models.RolePolicy{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to auto-migrate: %w", err)
@@ -39,21 +54,50 @@ func AutoMigrate(db *gorm.DB) error {
 	return nil
 }
 
-func addCustomIndexes(db *gorm.DB) error {
-	if err := db.Exec(`
-        CREATE INDEX IF NOT EXISTS idx_route_middleware_order 
-        ON route_middlewares(route_id, execution_order)
-    `).Error; err != nil {
-		return err
+// repairRouteMiddlewareIndex drops the unique index of route middlewares when it was created on the middleware name
+// alone, which prevented a middleware from being used by more than one route. AutoMigrate then recreates it on
+// (route_id, middleware_name).
+func repairRouteMiddlewareIndex(db *gorm.DB) error {
+	var definition string
+	err := db.Raw(`
+        SELECT indexdef FROM pg_indexes
+        WHERE tablename = 'route_middlewares' AND indexname = 'idx_route_middleware_unique'
+    `).Scan(&definition).Error
+	if err != nil {
+		return fmt.Errorf("failed to inspect route middleware index: %w", err)
+	}
+	if definition == "" || strings.Contains(definition, "(route_id, middleware_name)") {
+		return nil
+	}
+	logger.Info("Repairing route middleware unique index", "definition", definition)
+	if err := db.Exec(`DROP INDEX IF EXISTS idx_route_middleware_unique`).Error; err != nil {
+		return fmt.Errorf("failed to drop route middleware index: %w", err)
 	}
+	return nil
+}
 
-	if err := db.Exec(`
-        CREATE UNIQUE INDEX IF NOT EXISTS idx_route_middleware_unique 
-        ON route_middlewares(route_id, middleware_name)
-    `).Error; err != nil {
-		return err
+// repairAuditLogConstraint drops the foreign key of audit logs on their user when it deletes them along with the
+// user, which erased the audit trail of deleted users. AutoMigrate then recreates it with ON DELETE SET NULL.
+func repairAuditLogConstraint(db *gorm.DB) error {
+	var deleteRule string
+	err := db.Raw(`
+        SELECT delete_rule FROM information_schema.referential_constraints
+        WHERE constraint_schema = current_schema() AND constraint_name = 'fk_users_audit_logs'
+    `).Scan(&deleteRule).Error
+	if err != nil {
+		return fmt.Errorf("failed to inspect audit log constraint: %w", err)
 	}
+	if deleteRule != "CASCADE" {
+		return nil
+	}
+	logger.Info("Repairing audit log user constraint", "deleteRule", deleteRule)
+	if err := db.Exec(`ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS fk_users_audit_logs`).Error; err != nil {
+		return fmt.Errorf("failed to drop audit log constraint: %w", err)
+	}
+	return nil
+}
 
+func addCustomIndexes(db *gorm.DB) error {
 	if err := db.Exec(`
         CREATE INDEX IF NOT EXISTS idx_instance_routes_lookup
         ON instance_routes(instance_id, route_id)
@@ -75,6 +119,12 @@ func Rollback(db *gorm.DB) error {
 	logger.Info("Rolling back database migrations...")
 
 	err := db.Migrator().DropTable(
+		&models.RolePolicy{},
+		&models.PermissionGrant{},
+		&models.EnrollmentToken{},
+		&models.InstanceReport{},
+		&models.InstanceCredential{},
+		&models.InstanceNonce{},
 		&models.InstanceRoute{},
 		&models.RouteMiddleware{},
 		&models.Middleware{},
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix starts every API token, so that they can be told apart from JWTs and found by secret scanners
+const APITokenPrefix = "goma_"
+
+// APIToken is a long-lived token acting on behalf of a user or service account,
+// restricted to a set of scopes such as "routes:write". Only the hash of the token is stored.
+type APIToken struct {
+	ID         uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID   `gorm:"type:uuid;not null;index" json:"userId"`
+	Name       string      `gorm:"not null;size:255" json:"name"`
+	Prefix     string      `gorm:"not null;size:20;index" json:"prefix"` // first characters of the token, to identify it
+	TokenHash  string      `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	Scopes     StringArray `gorm:"type:text[]" json:"scopes"`
+	ExpiresAt  *time.Time  `gorm:"index" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time  `json:"lastUsedAt,omitempty"`
+	LastUsedIP string      `gorm:"size:45" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time  `json:"revokedAt,omitempty"`
+	CreatedBy  *uuid.UUID  `gorm:"type:uuid" json:"createdBy,omitempty"`
+	CreatedAt  time.Time   `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt  time.Time   `gorm:"column:updated_at" json:"updatedAt"`
+
+	// Association
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for the APIToken model
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// BeforeCreate hook for APIToken
+func (t *APIToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsValid checks if the token is neither revoked nor expired
+func (t *APIToken) IsValid() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/jkaninda/goma-admin/internal/secrets"
+	"gorm.io/gorm/schema"
+)
+
+// EncryptedSerializerName is the GORM serializer encrypting string fields at rest, used with serializer:encrypted
+const EncryptedSerializerName = "encrypted"
+
+var fieldCipher atomic.Pointer[secrets.Cipher]
+
+func init() {
+	schema.RegisterSerializer(EncryptedSerializerName, EncryptedSerializer{})
+}
+
+// UseCipher sets the cipher of the fields using EncryptedSerializer, it must be called before the database is used
+func UseCipher(cipher *secrets.Cipher) {
+	fieldCipher.Store(cipher)
+}
+
+// EncryptedSerializer encrypts string and *string fields when they are written, and decrypts them when they are read,
+// so that models always hold plaintext. Values stored before encryption was introduced are read as is.
+type EncryptedSerializer struct{}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	if dbValue == nil {
+		return nil
+	}
+	var value string
+	switch v := dbValue.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("encrypted field %s: unsupported database value %T", field.Name, dbValue)
+	}
+	if secrets.IsEncrypted(value) {
+		cipher, err := currentCipher()
+		if err != nil {
+			return err
+		}
+		plaintext, err := cipher.Decrypt(ctx, value)
+		if err != nil {
+			return fmt.Errorf("encrypted field %s: %w", field.Name, err)
+		}
+		value = string(plaintext)
+	}
+	if field.FieldType.Kind() == reflect.Pointer {
+		return field.Set(ctx, dst, &value)
+	}
+	return field.Set(ctx, dst, value)
+}
+
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, _ reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var value string
+	switch v := fieldValue.(type) {
+	case string:
+		value = v
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		value = *v
+	default:
+		return nil, fmt.Errorf("encrypted field %s: unsupported type %T", field.Name, fieldValue)
+	}
+	cipher, err := currentCipher()
+	if err != nil {
+		return nil, err
+	}
+	return cipher.Encrypt(ctx, []byte(value))
+}
+
+func currentCipher() (*secrets.Cipher, error) {
+	cipher := fieldCipher.Load()
+	if cipher == nil {
+		return nil, errors.New("encrypted fields are used before models.UseCipher is called")
+	}
+	return cipher, nil
+}
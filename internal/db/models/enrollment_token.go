@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EnrollmentTokenPrefix starts every enrollment token, so that they can be told apart from other credentials
+const EnrollmentTokenPrefix = "gome_"
+
+// EnrollmentToken lets unknown gateway instances of an environment register themselves.
+// Registered instances are pending until an admin approves them. Only the hash of the token is stored.
+type EnrollmentToken struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	Environment string     `gorm:"not null;size:100;index" json:"environment"`
+	Description string     `gorm:"type:text" json:"description,omitempty"`
+	Prefix      string     `gorm:"not null;size:20" json:"prefix"` // first characters of the token, to identify it
+	TokenHash   string     `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP  string     `gorm:"size:45" json:"lastUsedIp,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+	CreatedBy   *uuid.UUID `gorm:"type:uuid" json:"createdBy,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the EnrollmentToken model
+func (EnrollmentToken) TableName() string {
+	return "enrollment_tokens"
+}
+
+// BeforeCreate hook for EnrollmentToken
+func (t *EnrollmentToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsValid checks if the token is neither revoked nor expired
+func (t *EnrollmentToken) IsValid() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
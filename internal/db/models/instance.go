@@ -22,9 +22,15 @@ type Instance struct {
 	LastSeen        *time.Time  `gorm:"index" json:"lastSeen,omitempty" yaml:"lastSeen,omitempty"`
 	Status          string      `gorm:"size:50;default:'unknown';index" json:"status" yaml:"status"` // active, inactive, unhealthy, unknown
 	Enabled         bool        `gorm:"default:true;index" json:"enabled" yaml:"enabled"`
-	Metadata        JSONB       `gorm:"type:jsonb" json:"metadata,omitempty" yaml:"metadata,omitempty"`
-	CreatedAt       time.Time   `gorm:"column:created_at" json:"createdAt" yaml:"createdAt"`
-	UpdatedAt       time.Time   `gorm:"column:updated_at" json:"updatedAt" yaml:"updatedAt"`
+	// Pending instances registered themselves with an enrollment token, they are not served any route until approved
+	Pending  bool  `gorm:"default:false;index" json:"pending" yaml:"pending"`
+	Metadata JSONB `gorm:"type:jsonb" json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// ClientCertFingerprint pins the SHA-256 fingerprint of the client certificate the instance must present
+	ClientCertFingerprint string `gorm:"size:64" json:"clientCertFingerprint,omitempty" yaml:"clientCertFingerprint,omitempty"`
+	// AppliedConfigVersion is the configuration version the instance last reported running
+	AppliedConfigVersion string    `gorm:"size:64" json:"appliedConfigVersion,omitempty" yaml:"appliedConfigVersion,omitempty"`
+	CreatedAt            time.Time `gorm:"column:created_at" json:"createdAt" yaml:"createdAt"`
+	UpdatedAt            time.Time `gorm:"column:updated_at" json:"updatedAt" yaml:"updatedAt"`
 
 	// Associations
 	InstanceRoutes []InstanceRoute `gorm:"foreignKey:InstanceID;constraint:OnDelete:CASCADE" json:"-" yaml:"-"`
@@ -111,6 +117,15 @@ const (
 	InstanceStatusUnknown   InstanceStatus = "unknown"
 )
 
+// IsValid checks if the status is one of the known statuses
+func (s InstanceStatus) IsValid() bool {
+	switch s {
+	case InstanceStatusActive, InstanceStatusInactive, InstanceStatusUnhealthy, InstanceStatusUnknown:
+		return true
+	}
+	return false
+}
+
 // InstanceEnvironment represents common environment types
 type InstanceEnvironment string
 
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InstanceCredentialPrefix starts every instance credential, so that they can be told apart from API tokens
+const InstanceCredentialPrefix = "gomi_"
+
+// InstanceCredential authenticates a gateway instance on the provider and webhook endpoints.
+// The secret is either sent as a bearer token or used to sign requests. The secret itself is never stored: its hash
+// authenticates bearer tokens, and the signing key derived from it, encrypted at rest, verifies signed requests.
+type InstanceCredential struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	InstanceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"instanceId"`
+	Prefix     string     `gorm:"not null;size:20" json:"prefix"` // first characters of the secret, to identify it
+	SecretHash string     `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	SigningKey string     `gorm:"type:text;serializer:encrypted" json:"-"` // empty for credentials older than signing keys
+	ExpiresAt  *time.Time `gorm:"index" json:"expiresAt,omitempty"`        // set when rotated out, after the grace period
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `gorm:"size:45" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedBy  *uuid.UUID `gorm:"type:uuid" json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at" json:"updatedAt"`
+
+	// Association
+	Instance Instance `gorm:"foreignKey:InstanceID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for the InstanceCredential model
+func (InstanceCredential) TableName() string {
+	return "instance_credentials"
+}
+
+// BeforeCreate hook for InstanceCredential
+func (ic *InstanceCredential) BeforeCreate(tx *gorm.DB) error {
+	if ic.ID == uuid.Nil {
+		ic.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsValid checks if the credential is neither revoked nor past its grace period
+func (ic *InstanceCredential) IsValid() bool {
+	if ic.RevokedAt != nil {
+		return false
+	}
+	return ic.ExpiresAt == nil || time.Now().Before(*ic.ExpiresAt)
+}
+
+// InstanceNonce is a nonce of a signed request, kept until the request timestamp leaves the allowed skew
+// so that a captured request cannot be replayed
+type InstanceNonce struct {
+	CredentialID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Nonce        string    `gorm:"primaryKey;size:64"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+
+	// Association
+	Credential InstanceCredential `gorm:"foreignKey:CredentialID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName specifies the table name for the InstanceNonce model
+func (InstanceNonce) TableName() string {
+	return "instance_nonces"
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BackendHealth is the health of a route backend as seen by a gateway instance
+type BackendHealth struct {
+	Route    string `json:"route"`
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InstanceReport is a health and configuration report sent by a gateway instance to the provider webhook.
+// Reports are kept as a history, to see when an instance drifted from the configuration published to it.
+type InstanceReport struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	InstanceID uuid.UUID `gorm:"type:uuid;not null;index:idx_instance_reports_lookup,priority:1" json:"instanceId"`
+	Status     string    `gorm:"size:50" json:"status"`
+	Version    string    `gorm:"size:50" json:"version,omitempty"` // gateway version
+	// AppliedConfigVersion is the configuration version the instance runs
+	AppliedConfigVersion string `gorm:"size:64" json:"appliedConfigVersion,omitempty"`
+	// PublishedConfigVersion is the configuration version served to the instance when the report was received
+	PublishedConfigVersion string           `gorm:"size:64" json:"publishedConfigVersion"`
+	InSync                 bool             `gorm:"index" json:"inSync"`
+	Backends               []BackendHealth  `gorm:"type:jsonb;serializer:json" json:"backends,omitempty"`
+	ErrorCounts            map[string]int64 `gorm:"type:jsonb;serializer:json" json:"errorCounts,omitempty"`
+	CreatedAt              time.Time        `gorm:"column:created_at;index:idx_instance_reports_lookup,priority:2" json:"createdAt"`
+
+	// Association
+	Instance Instance `gorm:"foreignKey:InstanceID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for the InstanceReport model
+func (InstanceReport) TableName() string {
+	return "instance_reports"
+}
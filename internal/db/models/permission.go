@@ -0,0 +1,55 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// EnvironmentAny matches instances of every environment in a permission grant
+const EnvironmentAny = "*"
+
+// PermissionGrant allows a role to modify instances of an environment, and the routes
+// attached to them. Admins and superadmins can modify every instance without a grant.
+type PermissionGrant struct {
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	Role        string      `gorm:"not null;size:50;index" json:"role"`
+	Environment string      `gorm:"not null;size:100;index" json:"environment"` // InstanceEnvironment or "*"
+	Tags        StringArray `gorm:"type:text[]" json:"tags,omitempty"`          // Optional: restrict to instances carrying one of these tags
+	Description string      `gorm:"type:text" json:"description,omitempty"`
+	CreatedAt   time.Time   `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt   time.Time   `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the PermissionGrant model
+func (PermissionGrant) TableName() string {
+	return "permission_grants"
+}
+
+// Matches checks if the grant covers the given instance
+func (g *PermissionGrant) Matches(instance *Instance) bool {
+	if g.Environment != EnvironmentAny && g.Environment != instance.Environment {
+		return false
+	}
+	if len(g.Tags) == 0 {
+		return true
+	}
+	for _, tag := range g.Tags {
+		if slices.Contains(instance.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePolicy holds the security requirements enforced for the users of a role
+type RolePolicy struct {
+	Role       string    `gorm:"primaryKey;size:50" json:"role"`
+	RequireMFA bool      `gorm:"column:require_mfa;default:false" json:"requireMfa"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt  time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+// TableName specifies the table name for the RolePolicy model
+func (RolePolicy) TableName() string {
+	return "role_policies"
+}
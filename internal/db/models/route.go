@@ -98,11 +98,12 @@ type Maintenance struct {
 	UpdatedAt  time.Time `gorm:"column:updated_at" json:"-" yaml:"-"`
 }
 
+// TLSCertificate is a certificate served by a route, its private key being encrypted at rest
 type TLSCertificate struct {
 	ID        uint      `gorm:"primaryKey" json:"-" yaml:"-"`
 	RouteID   uint      `gorm:"not null;index" json:"-" yaml:"-"`
 	Cert      string    `gorm:"type:text;not null" json:"cert" yaml:"cert"`
-	Key       string    `gorm:"type:text;not null" json:"key" yaml:"key"`
+	Key       string    `gorm:"type:text;not null;serializer:encrypted" json:"key" yaml:"key"`
 	CreatedAt time.Time `gorm:"column:created_at" json:"-" yaml:"-"`
 	UpdatedAt time.Time `gorm:"column:updated_at" json:"-" yaml:"-"`
 }
@@ -128,18 +129,22 @@ type Security struct {
 	UpdatedAt               time.Time    `gorm:"column:updated_at" json:"-" yaml:"-"`
 }
 
+// SecurityTLS configures the TLS connections to the backends of a route, the client certificate and key being
+// encrypted at rest
 type SecurityTLS struct {
 	InsecureSkipVerify bool    `gorm:"column:insecure_skip_verify;default:false" json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
 	RootCAs            *string `gorm:"column:root_cas;type:text" json:"rootCAs,omitempty" yaml:"rootCAs,omitempty"`
-	ClientCert         *string `gorm:"column:client_cert;type:text" json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
-	ClientKey          *string `gorm:"column:client_key;type:text" json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+	ClientCert         *string `gorm:"column:client_cert;type:text;serializer:encrypted" json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+	ClientKey          *string `gorm:"column:client_key;type:text;serializer:encrypted" json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
 }
 
+// RouteMiddleware attaches a middleware to a route. A middleware is attached at most once to each route,
+// and may be shared by any number of routes.
 type RouteMiddleware struct {
 	ID             uint      `gorm:"primaryKey" json:"-" yaml:"-"`
-	RouteID        uint      `gorm:"not null;index:idx_route_middleware_order" json:"-" yaml:"-"`
-	MiddlewareName string    `gorm:"not null;size:255;uniqueIndex:idx_route_middleware_unique" json:"-" yaml:"-"`
-	ExecutionOrder int       `gorm:"default:0;index:idx_route_middleware_order" json:"-" yaml:"-"`
+	RouteID        uint      `gorm:"not null;index:idx_route_middleware_order,priority:1;uniqueIndex:idx_route_middleware_unique,priority:1" json:"-" yaml:"-"`
+	MiddlewareName string    `gorm:"not null;size:255;uniqueIndex:idx_route_middleware_unique,priority:2" json:"-" yaml:"-"`
+	ExecutionOrder int       `gorm:"default:0;index:idx_route_middleware_order,priority:2" json:"-" yaml:"-"`
 	CreatedAt      time.Time `gorm:"column:created_at" json:"-" yaml:"-"`
 
 	// Associations
@@ -9,29 +9,44 @@ import (
 )
 
 type User struct {
-	ID            uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id" yaml:"id"`
-	Email         string         `gorm:"uniqueIndex;not null;size:255" json:"email" yaml:"email"`
-	Password      string         `gorm:"not null" json:"-" yaml:"-"`
-	Name          string         `gorm:"size:255" json:"name" yaml:"name"`
-	Username      string         `gorm:"uniqueIndex;size:100" json:"username,omitempty" yaml:"username,omitempty"`
-	Avatar        string         `gorm:"size:500" json:"avatar,omitempty" yaml:"avatar,omitempty"`
-	Role          string         `gorm:"size:50;default:'user';index" json:"role" yaml:"role"` // admin, user, viewer, etc.
-	EmailVerified bool           `gorm:"default:false;index" json:"emailVerified" yaml:"emailVerified"`
-	Active        bool           `gorm:"default:true;index" json:"active" yaml:"active"`
-	LastLoginAt   *time.Time     `json:"lastLoginAt,omitempty" yaml:"lastLoginAt,omitempty"`
-	LastLoginIP   string         `gorm:"size:45" json:"lastLoginIp,omitempty" yaml:"lastLoginIp,omitempty"`
-	FailedLogins  int            `gorm:"default:0" json:"-" yaml:"-"`
-	LockedUntil   *time.Time     `json:"lockedUntil,omitempty" yaml:"lockedUntil,omitempty"`
-	Metadata      JSONB          `gorm:"type:jsonb" json:"metadata,omitempty" yaml:"metadata,omitempty"`
-	CreatedAt     time.Time      `gorm:"column:created_at" json:"createdAt" yaml:"createdAt"`
-	UpdatedAt     time.Time      `gorm:"column:updated_at" json:"updatedAt" yaml:"updatedAt"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-" yaml:"-"`
+	ID                 uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id" yaml:"id"`
+	Email              string         `gorm:"uniqueIndex;not null;size:255" json:"email" yaml:"email"`
+	Password           string         `gorm:"not null" json:"-" yaml:"-"`
+	Name               string         `gorm:"size:255" json:"name" yaml:"name"`
+	Username           string         `gorm:"uniqueIndex;size:100" json:"username,omitempty" yaml:"username,omitempty"`
+	Avatar             string         `gorm:"size:500" json:"avatar,omitempty" yaml:"avatar,omitempty"`
+	Role               string         `gorm:"size:50;default:'user';index" json:"role" yaml:"role"` // admin, user, viewer, etc.
+	EmailVerified      bool           `gorm:"default:false;index" json:"emailVerified" yaml:"emailVerified"`
+	Active             bool           `gorm:"default:true;index" json:"active" yaml:"active"`
+	LastLoginAt        *time.Time     `json:"lastLoginAt,omitempty" yaml:"lastLoginAt,omitempty"`
+	LastLoginIP        string         `gorm:"size:45" json:"lastLoginIp,omitempty" yaml:"lastLoginIp,omitempty"`
+	FailedLogins       int            `gorm:"default:0" json:"-" yaml:"-"`
+	LockedUntil        *time.Time     `json:"lockedUntil,omitempty" yaml:"lockedUntil,omitempty"`
+	MustChangePassword bool           `gorm:"default:false" json:"mustChangePassword" yaml:"mustChangePassword"` // only allowed to change password until done
+	MFAEnabled         bool           `gorm:"column:mfa_enabled;default:false" json:"mfaEnabled" yaml:"mfaEnabled"`
+	MFASecret          string         `gorm:"column:mfa_secret;type:text;serializer:encrypted" json:"-" yaml:"-"`    // TOTP secret, pending until MFAEnabled, encrypted at rest
+	MFARecoveryCodes   StringArray    `gorm:"column:mfa_recovery_codes;type:text[]" json:"-" yaml:"-"`               // hashed single-use recovery codes
+	MFALastUsedStep    int64          `gorm:"column:mfa_last_used_step;default:0" json:"-" yaml:"-"`                 // last accepted TOTP time step, prevents replays
+	AuthProvider       string         `gorm:"size:20;default:'local';index" json:"authProvider" yaml:"authProvider"` // local, oidc, ldap
+	ExternalID         string         `gorm:"size:255;index" json:"-" yaml:"-"`                                      // subject at the external identity provider
+	ServiceAccount     bool           `gorm:"default:false;index" json:"serviceAccount" yaml:"serviceAccount"`       // non-human principal, authenticates with API tokens only
+	Metadata           JSONB          `gorm:"type:jsonb" json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	CreatedAt          time.Time      `gorm:"column:created_at" json:"createdAt" yaml:"createdAt"`
+	UpdatedAt          time.Time      `gorm:"column:updated_at" json:"updatedAt" yaml:"updatedAt"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-" yaml:"-"`
 
 	// Associations
 	Sessions  []UserSession `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-" yaml:"-"`
-	AuditLogs []AuditLog    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-" yaml:"-"`
+	AuditLogs []AuditLog    `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-" yaml:"-"` // kept when the user is deleted
 }
 
+// Authentication providers a user can sign in with
+const (
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+	AuthProviderLDAP  = "ldap"
+)
+
 // UserSession represents a user's login session
 type UserSession struct {
 	ID           uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
@@ -49,18 +64,33 @@ type UserSession struct {
 	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
+// PasswordResetToken is a single-use token allowing a user to choose a new password.
+// Only the hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"userId"`
+	TokenHash string     `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	IPAddress string     `gorm:"size:45" json:"ipAddress"`
+	ExpiresAt time.Time  `gorm:"index" json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `gorm:"column:created_at" json:"createdAt"`
+
+	// Association
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
 // AuditLog represents audit trail for user actions
 type AuditLog struct {
-	ID         uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
-	UserID     uuid.UUID `gorm:"type:uuid;index" json:"userId"`
-	Action     string    `gorm:"not null;size:100;index" json:"action"`    // login, logout, create_route, etc.
-	Resource   string    `gorm:"size:100;index" json:"resource,omitempty"` // route, instance, middleware
-	ResourceID string    `gorm:"size:255" json:"resourceId,omitempty"`
-	IPAddress  string    `gorm:"size:45" json:"ipAddress"`
-	UserAgent  string    `gorm:"size:500" json:"userAgent,omitempty"`
-	Status     string    `gorm:"size:50" json:"status"` // success, failure
-	Details    JSONB     `gorm:"type:jsonb" json:"details,omitempty"`
-	CreatedAt  time.Time `gorm:"column:created_at;index" json:"createdAt"`
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"userId,omitempty"`  // nil for unknown principals, e.g. failed logins
+	Action     string     `gorm:"not null;size:100;index" json:"action"`    // login, logout, create_route, etc.
+	Resource   string     `gorm:"size:100;index" json:"resource,omitempty"` // route, instance, middleware
+	ResourceID string     `gorm:"size:255" json:"resourceId,omitempty"`
+	IPAddress  string     `gorm:"size:45" json:"ipAddress"`
+	UserAgent  string     `gorm:"size:500" json:"userAgent,omitempty"`
+	Status     string     `gorm:"size:50" json:"status"` // success, failure
+	Details    JSONB      `gorm:"type:jsonb" json:"details,omitempty"`
+	CreatedAt  time.Time  `gorm:"column:created_at;index" json:"createdAt"`
 
 	// Association
 	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-"`
@@ -80,6 +110,11 @@ func (AuditLog) TableName() string {
 	return "audit_logs"
 }
 
+// TableName specifies the table name for the PasswordResetToken model
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
 // BeforeCreate hook to generate UUID and set defaults
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
@@ -90,6 +125,10 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 		u.Role = string(RoleUser)
 	}
 
+	if u.AuthProvider == "" {
+		u.AuthProvider = AuthProviderLocal
+	}
+
 	return nil
 }
 
@@ -101,6 +140,14 @@ func (s *UserSession) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate hook for PasswordResetToken
+func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
 // BeforeCreate hook for AuditLog
 func (a *AuditLog) BeforeCreate(tx *gorm.DB) error {
 	if a.ID == uuid.Nil {
@@ -163,6 +210,20 @@ func (u *User) UpdateLastLogin(ip string) {
 	u.ResetFailedLogins()
 }
 
+// DisableMFA removes the TOTP secret and recovery codes of the user
+func (u *User) DisableMFA() {
+	u.MFAEnabled = false
+	u.MFASecret = ""
+	u.MFARecoveryCodes = nil
+	u.MFALastUsedStep = 0
+}
+
+// HasExternalMFA checks if the user signs in with an identity provider expected to enforce its own MFA.
+// Directory passwords are verified by the dashboard itself, so LDAP users enroll MFA like local users.
+func (u *User) HasExternalMFA() bool {
+	return u.AuthProvider == AuthProviderOIDC
+}
+
 // HasRole checks if user has a specific role
 func (u *User) HasRole(role UserRole) bool {
 	return u.Role == string(role)
@@ -187,6 +248,14 @@ func (s *UserSession) IsValid() bool {
 	return time.Now().Before(s.ExpiresAt)
 }
 
+// IsValid checks if a password reset token is unused and not expired
+func (t *PasswordResetToken) IsValid() bool {
+	if t.UsedAt != nil {
+		return false
+	}
+	return time.Now().Before(t.ExpiresAt)
+}
+
 // Revoke revokes the session
 func (s *UserSession) Revoke() {
 	now := time.Now()
@@ -211,6 +280,12 @@ var roleHierarchy = map[UserRole]int{
 	RoleViewer:     1,
 }
 
+// IsValid checks if the role is part of the role hierarchy
+func (r UserRole) IsValid() bool {
+	_, ok := roleHierarchy[r]
+	return ok
+}
+
 // CanAccess checks if the user's role can access the required role level
 func (r UserRole) CanAccess(required UserRole) bool {
 	userLevel, userExists := roleHierarchy[r]
@@ -227,19 +302,48 @@ func (r UserRole) CanAccess(required UserRole) bool {
 type AuditAction string
 
 const (
-	AuditActionLogin            AuditAction = "login"
-	AuditActionLogout           AuditAction = "logout"
-	AuditActionLoginFailed      AuditAction = "login_failed"
-	AuditActionPasswordChange   AuditAction = "password_change"
-	AuditActionCreateRoute      AuditAction = "create_route"
-	AuditActionUpdateRoute      AuditAction = "update_route"
-	AuditActionDeleteRoute      AuditAction = "delete_route"
-	AuditActionCreateInstance   AuditAction = "create_instance"
-	AuditActionUpdateInstance   AuditAction = "update_instance"
-	AuditActionDeleteInstance   AuditAction = "delete_instance"
-	AuditActionCreateMiddleware AuditAction = "create_middleware"
-	AuditActionUpdateMiddleware AuditAction = "update_middleware"
-	AuditActionDeleteMiddleware AuditAction = "delete_middleware"
+	AuditActionLogin                    AuditAction = "login"
+	AuditActionLogout                   AuditAction = "logout"
+	AuditActionLoginFailed              AuditAction = "login_failed"
+	AuditActionPasswordChange           AuditAction = "password_change"
+	AuditActionPasswordForgot           AuditAction = "password_forgot"
+	AuditActionUpdateProfile            AuditAction = "update_profile"
+	AuditActionMFAEnroll                AuditAction = "mfa_enroll"
+	AuditActionMFARemove                AuditAction = "mfa_remove"
+	AuditActionMFARecoveryCodes         AuditAction = "mfa_recovery_codes"
+	AuditActionUpdateRolePolicy         AuditAction = "update_role_policy"
+	AuditActionCreateAPIToken           AuditAction = "create_api_token"
+	AuditActionRevokeAPIToken           AuditAction = "revoke_api_token"
+	AuditActionCreateServiceAccount     AuditAction = "create_service_account"
+	AuditActionCreateRoute              AuditAction = "create_route"
+	AuditActionUpdateRoute              AuditAction = "update_route"
+	AuditActionDeleteRoute              AuditAction = "delete_route"
+	AuditActionCreateInstance           AuditAction = "create_instance"
+	AuditActionUpdateInstance           AuditAction = "update_instance"
+	AuditActionDeleteInstance           AuditAction = "delete_instance"
+	AuditActionCreateMiddleware         AuditAction = "create_middleware"
+	AuditActionUpdateMiddleware         AuditAction = "update_middleware"
+	AuditActionDeleteMiddleware         AuditAction = "delete_middleware"
+	AuditActionUnlockUser               AuditAction = "unlock_user"
+	AuditActionRevokeSession            AuditAction = "revoke_session"
+	AuditActionAttachRoute              AuditAction = "attach_route"
+	AuditActionDetachRoute              AuditAction = "detach_route"
+	AuditActionDeployInstance           AuditAction = "deploy_instance"
+	AuditActionGrantPermission          AuditAction = "grant_permission"
+	AuditActionRevokePermission         AuditAction = "revoke_permission"
+	AuditActionCreateUser               AuditAction = "create_user"
+	AuditActionUpdateUser               AuditAction = "update_user"
+	AuditActionDeactivateUser           AuditAction = "deactivate_user"
+	AuditActionDeleteUser               AuditAction = "delete_user"
+	AuditActionResetPassword            AuditAction = "reset_password"
+	AuditActionRotateInstanceCredential AuditAction = "rotate_instance_credential"
+	AuditActionRevokeInstanceCredential AuditAction = "revoke_instance_credential"
+	AuditActionPinClientCertificate     AuditAction = "pin_client_certificate"
+	AuditActionUnpinClientCertificate   AuditAction = "unpin_client_certificate"
+	AuditActionRegisterInstance         AuditAction = "register_instance"
+	AuditActionApproveInstance          AuditAction = "approve_instance"
+	AuditActionCreateEnrollmentToken    AuditAction = "create_enrollment_token"
+	AuditActionRevokeEnrollmentToken    AuditAction = "revoke_enrollment_token"
 )
 
 // AuditStatus represents audit log status
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"gorm.io/gorm"
+)
+
+type APITokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAPITokenRepository(db *gorm.DB) *APITokenRepository {
+	return &APITokenRepository{db: db}
+}
+
+// Create creates a new API token
+func (r *APITokenRepository) Create(ctx context.Context, token *models.APIToken) error {
+	if err := r.db.WithContext(ctx).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+	return nil
+}
+
+// GetByID retrieves an API token by ID
+func (r *APITokenRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.APIToken, error) {
+	var token models.APIToken
+
+	err := r.db.WithContext(ctx).First(&token, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("API token not found: %s", id)
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetByHash retrieves an API token, along with its owner, by the hash of the token
+func (r *APITokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("token_hash = ?", tokenHash).
+		First(&token).Error
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("API token not found")
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// List retrieves all API tokens
+func (r *APITokenRepository) List(ctx context.Context) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+
+	err := r.db.WithContext(ctx).
+		Order("created_at DESC").
+		Find(&tokens).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// ListByUser retrieves the API tokens of a user
+func (r *APITokenRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke revokes an API token
+func (r *APITokenRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Model(&models.APIToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API token not found or already revoked: %s", id)
+	}
+
+	return nil
+}
+
+// UpdateLastUsed records when and from where an API token was last used
+func (r *APITokenRepository) UpdateLastUsed(ctx context.Context, id uuid.UUID, ip string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.APIToken{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"last_used_ip": ip,
+		}).Error
+}
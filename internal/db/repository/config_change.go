@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ConfigResource is the kind of object a configuration change is about
+type ConfigResource string
+
+const (
+	ConfigResourceRoute      ConfigResource = "route"
+	ConfigResourceMiddleware ConfigResource = "middleware"
+	ConfigResourceInstance   ConfigResource = "instance"
+)
+
+// ConfigChange describes a committed change to the configuration served to gateway instances
+type ConfigChange struct {
+	Resource ConfigResource `json:"resource"`
+	// InstanceID is set when only the configuration of that instance is affected
+	InstanceID *uuid.UUID `json:"instanceId,omitempty"`
+}
+
+var (
+	configListenersMu sync.RWMutex
+	configListeners   []func(ConfigChange)
+)
+
+// OnConfigChange registers a function called after every committed change to routes, middlewares
+// or the routes of an instance. Listeners are called synchronously and must not block.
+func OnConfigChange(listener func(ConfigChange)) {
+	configListenersMu.Lock()
+	defer configListenersMu.Unlock()
+	configListeners = append(configListeners, listener)
+}
+
+// publishConfigChange notifies the listeners of a change, once it has been committed
+func publishConfigChange(change ConfigChange) {
+	configListenersMu.RLock()
+	defer configListenersMu.RUnlock()
+	for _, listener := range configListeners {
+		listener(change)
+	}
+}
+
+// instanceConfigChange is the change of the configuration of a single instance
+func instanceConfigChange(instanceID uuid.UUID) ConfigChange {
+	return ConfigChange{Resource: ConfigResourceInstance, InstanceID: &instanceID}
+}
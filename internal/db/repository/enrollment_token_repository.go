@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"gorm.io/gorm"
+)
+
+type EnrollmentTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewEnrollmentTokenRepository(db *gorm.DB) *EnrollmentTokenRepository {
+	return &EnrollmentTokenRepository{db: db}
+}
+
+// Create creates an enrollment token
+func (r *EnrollmentTokenRepository) Create(ctx context.Context, token *models.EnrollmentToken) error {
+	if err := r.db.WithContext(ctx).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to create enrollment token: %w", err)
+	}
+	return nil
+}
+
+// GetByHash retrieves an enrollment token by the hash of its value
+func (r *EnrollmentTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error) {
+	var token models.EnrollmentToken
+
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("enrollment token not found")
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetByID retrieves an enrollment token by ID
+func (r *EnrollmentTokenRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.EnrollmentToken, error) {
+	var token models.EnrollmentToken
+
+	err := r.db.WithContext(ctx).First(&token, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("enrollment token not found: %s", id)
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// List retrieves all enrollment tokens, newest first
+func (r *EnrollmentTokenRepository) List(ctx context.Context) ([]models.EnrollmentToken, error) {
+	var tokens []models.EnrollmentToken
+
+	err := r.db.WithContext(ctx).
+		Order("created_at DESC").
+		Find(&tokens).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke revokes an enrollment token
+func (r *EnrollmentTokenRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Model(&models.EnrollmentToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("enrollment token not found or already revoked: %s", id)
+	}
+
+	return nil
+}
+
+// UpdateLastUsed records when and from where an enrollment token was last used
+func (r *EnrollmentTokenRepository) UpdateLastUsed(ctx context.Context, id uuid.UUID, ip string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.EnrollmentToken{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"last_used_ip": ip,
+		}).Error
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrNotFound is matched with errors.Is by the errors of lookups and writes finding no record
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is matched with errors.Is by the errors of writes violating a unique constraint
+	ErrDuplicate = errors.New("record already exists")
+	// ErrConflict is matched with errors.Is by the errors of writes based on records that changed meanwhile
+	ErrConflict = errors.New("record changed")
+)
+
+// uniqueViolation is the PostgreSQL error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// repositoryError keeps the message of the repository while being matched as its kind
+type repositoryError struct {
+	message string
+	kind    error
+	err     error
+}
+
+func (e *repositoryError) Error() string {
+	return e.message
+}
+
+func (e *repositoryError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *repositoryError) Unwrap() error {
+	return e.err
+}
+
+func notFoundError(format string, args ...any) error {
+	return &repositoryError{message: fmt.Sprintf(format, args...), kind: ErrNotFound}
+}
+
+func duplicateError(message string, err error) error {
+	return &repositoryError{message: message, kind: ErrDuplicate, err: err}
+}
+
+func conflictError(format string, args ...any) error {
+	return &repositoryError{message: fmt.Sprintf(format, args...), kind: ErrConflict}
+}
+
+// isUniqueViolation checks if err, or an error it wraps, is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
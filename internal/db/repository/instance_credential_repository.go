@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InstanceCredentialRepository struct {
+	db *gorm.DB
+}
+
+func NewInstanceCredentialRepository(db *gorm.DB) *InstanceCredentialRepository {
+	return &InstanceCredentialRepository{db: db}
+}
+
+// Rotate creates a credential and sets the expiry of the instance's other valid credentials,
+// unless they already expire earlier
+func (r *InstanceCredentialRepository) Rotate(ctx context.Context, credential *models.InstanceCredential, expireOthersAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.InstanceCredential{}).
+			Where("instance_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", credential.InstanceID, expireOthersAt).
+			Update("expires_at", expireOthersAt).Error; err != nil {
+			return fmt.Errorf("failed to expire instance credentials: %w", err)
+		}
+		if err := tx.Create(credential).Error; err != nil {
+			return fmt.Errorf("failed to create instance credential: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetByID retrieves an instance credential by ID
+func (r *InstanceCredentialRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.InstanceCredential, error) {
+	var credential models.InstanceCredential
+
+	err := r.db.WithContext(ctx).First(&credential, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("instance credential not found: %s", id)
+		}
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// GetByHash retrieves an instance credential by the hash of its secret
+func (r *InstanceCredentialRepository) GetByHash(ctx context.Context, secretHash string) (*models.InstanceCredential, error) {
+	var credential models.InstanceCredential
+
+	err := r.db.WithContext(ctx).Where("secret_hash = ?", secretHash).First(&credential).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("instance credential not found")
+		}
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// ListByInstance retrieves the credentials of an instance, newest first
+func (r *InstanceCredentialRepository) ListByInstance(ctx context.Context, instanceID uuid.UUID) ([]models.InstanceCredential, error) {
+	var credentials []models.InstanceCredential
+
+	err := r.db.WithContext(ctx).
+		Where("instance_id = ?", instanceID).
+		Order("created_at DESC").
+		Find(&credentials).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// Revoke immediately revokes a credential of an instance
+func (r *InstanceCredentialRepository) Revoke(ctx context.Context, instanceID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Model(&models.InstanceCredential{}).
+		Where("id = ? AND instance_id = ? AND revoked_at IS NULL", id, instanceID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("instance credential not found or already revoked: %s", id)
+	}
+
+	return nil
+}
+
+// UpdateLastUsed records when and from where a credential was last used
+func (r *InstanceCredentialRepository) UpdateLastUsed(ctx context.Context, id uuid.UUID, ip string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.InstanceCredential{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"last_used_ip": ip,
+		}).Error
+}
+
+// UseNonce records the nonce of a signed request until it expires, and reports whether it was not used before
+func (r *InstanceCredentialRepository) UseNonce(ctx context.Context, credentialID uuid.UUID, nonce string, expiresAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.InstanceNonce{CredentialID: credentialID, Nonce: nonce, ExpiresAt: expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteExpiredNonces deletes the nonces whose requests can no longer be replayed
+func (r *InstanceCredentialRepository) DeleteExpiredNonces(ctx context.Context) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.InstanceNonce{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"gorm.io/gorm"
+)
+
+type InstanceReportRepository struct {
+	db        *gorm.DB
+	instances *InstanceRepository
+}
+
+func NewInstanceReportRepository(db *gorm.DB) *InstanceReportRepository {
+	return &InstanceReportRepository{db: db, instances: NewInstanceRepository(db)}
+}
+
+// Create records a report and updates the status, last seen time, gateway version and applied configuration
+// version of the instance with InstanceRepository.UpdateStatusTx, so that the instance never disagrees with
+// its latest report
+func (r *InstanceReportRepository) Create(ctx context.Context, report *models.InstanceReport) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.instances.UpdateStatusTx(tx, report.InstanceID, report.Status, map[string]interface{}{
+			"version":                report.Version,
+			"applied_config_version": report.AppliedConfigVersion,
+		}); err != nil {
+			return fmt.Errorf("failed to update instance status: %w", err)
+		}
+		if err := tx.Create(report).Error; err != nil {
+			return fmt.Errorf("failed to create instance report: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListByInstance retrieves the reports of an instance with pagination, newest first
+func (r *InstanceReportRepository) ListByInstance(ctx context.Context, instanceID uuid.UUID, page, pageSize int) ([]models.InstanceReport, int64, error) {
+	var reports []models.InstanceReport
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.InstanceReport{}).Where("instance_id = ?", instanceID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	err := query.
+		Order("created_at DESC").
+		Limit(pageSize).
+		Offset(offset).
+		Find(&reports).Error
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return reports, total, nil
+}
+
+// GetLatest retrieves the last report of an instance
+func (r *InstanceReportRepository) GetLatest(ctx context.Context, instanceID uuid.UUID) (*models.InstanceReport, error) {
+	var report models.InstanceReport
+
+	err := r.db.WithContext(ctx).
+		Where("instance_id = ?", instanceID).
+		Order("created_at DESC").
+		First(&report).Error
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("no report received from instance: %s", instanceID)
+		}
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// DeleteOlderThan deletes the reports received before the given time
+func (r *InstanceReportRepository) DeleteOlderThan(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&models.InstanceReport{})
+
+	return result.RowsAffected, result.Error
+}
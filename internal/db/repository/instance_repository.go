@@ -35,7 +35,7 @@ func (r *InstanceRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("instance not found: %s", id)
+			return nil, notFoundError("instance not found: %s", id)
 		}
 		return nil, err
 	}
@@ -113,6 +113,22 @@ func (r *InstanceRepository) ListByStatus(ctx context.Context, status string) ([
 	return instances, nil
 }
 
+// ListPending retrieves the instances that registered themselves and await approval, oldest first
+func (r *InstanceRepository) ListPending(ctx context.Context) ([]models.Instance, error) {
+	var instances []models.Instance
+
+	err := r.db.WithContext(ctx).
+		Where("pending = ?", true).
+		Order("created_at ASC").
+		Find(&instances).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
 // ListActive retrieves all active instances
 func (r *InstanceRepository) ListActive(ctx context.Context) ([]models.Instance, error) {
 	var instances []models.Instance
@@ -132,7 +148,7 @@ func (r *InstanceRepository) ListActive(ctx context.Context) ([]models.Instance,
 
 // Update updates an instance
 func (r *InstanceRepository) Update(ctx context.Context, instance *models.Instance) error {
-	return r.db.WithContext(ctx).
+	err := r.db.WithContext(ctx).
 		Model(instance).
 		Updates(map[string]interface{}{
 			"name":             instance.Name,
@@ -149,18 +165,77 @@ func (r *InstanceRepository) Update(ctx context.Context, instance *models.Instan
 			"metadata":         instance.Metadata,
 			"last_seen":        instance.LastSeen,
 		}).Error
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instance.ID))
+	return nil
+}
+
+// Register creates a pending instance along with its first credential
+func (r *InstanceRepository) Register(ctx context.Context, instance *models.Instance, credential *models.InstanceCredential) error {
+	instance.Pending = true
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(instance).Error; err != nil {
+			if isUniqueViolation(err) {
+				return duplicateError("instance already exists: "+instance.Name, err)
+			}
+			return fmt.Errorf("failed to create instance: %w", err)
+		}
+		credential.InstanceID = instance.ID
+		if err := tx.Create(credential).Error; err != nil {
+			return fmt.Errorf("failed to create instance credential: %w", err)
+		}
+		return nil
+	})
+}
+
+// Approve lets a pending instance be served its configuration
+func (r *InstanceRepository) Approve(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Model(&models.Instance{}).
+		Where("id = ? AND pending = ?", id, true).
+		Update("pending", false)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("instance not found or not pending: %s", id)
+	}
+
+	publishConfigChange(instanceConfigChange(id))
+	return nil
 }
 
 // UpdateStatus updates instance status and last seen time
 func (r *InstanceRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status string) error {
-	now := time.Now()
+	return r.UpdateStatusTx(r.db.WithContext(ctx), id, status, nil)
+}
+
+// UpdateStatusTx is UpdateStatus run with tx, such as a transaction, also setting the columns reported
+// along with the status
+func (r *InstanceRepository) UpdateStatusTx(tx *gorm.DB, id uuid.UUID, status string, columns map[string]interface{}) error {
+	updates := map[string]interface{}{
+		"status":    status,
+		"last_seen": time.Now(),
+	}
+	for column, value := range columns {
+		updates[column] = value
+	}
+	return tx.
+		Model(&models.Instance{}).
+		Where("id = ?", id).
+		Updates(updates).Error
+}
+
+// SetClientCertFingerprint pins the client certificate of an instance, or removes the pin when empty
+func (r *InstanceRepository) SetClientCertFingerprint(ctx context.Context, id uuid.UUID, fingerprint string) error {
 	return r.db.WithContext(ctx).
 		Model(&models.Instance{}).
 		Where("id = ?", id).
-		Updates(map[string]interface{}{
-			"status":    status,
-			"last_seen": now,
-		}).Error
+		Update("client_cert_fingerprint", fingerprint).Error
 }
 
 // UpdateLastSeen updates the last seen timestamp
@@ -184,6 +259,7 @@ func (r *InstanceRepository) Delete(ctx context.Context, id uuid.UUID) error {
 		return fmt.Errorf("instance not found: %s", id)
 	}
 
+	publishConfigChange(instanceConfigChange(id))
 	return nil
 }
 
@@ -221,7 +297,7 @@ func (r *InstanceRepository) AttachRoute(ctx context.Context, instanceID uuid.UU
 	now := time.Now()
 	instanceRoute.DeployedAt = &now
 
-	return r.db.WithContext(ctx).
+	err := r.db.WithContext(ctx).
 		Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "instance_id"}, {Name: "route_id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
@@ -230,6 +306,11 @@ func (r *InstanceRepository) AttachRoute(ctx context.Context, instanceID uuid.UU
 			}),
 		}).
 		Create(instanceRoute).Error
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instanceID))
+	return nil
 }
 
 // DetachRoute removes a route from an instance
@@ -246,12 +327,13 @@ func (r *InstanceRepository) DetachRoute(ctx context.Context, instanceID uuid.UU
 		return fmt.Errorf("route not attached to instance")
 	}
 
+	publishConfigChange(instanceConfigChange(instanceID))
 	return nil
 }
 
 // AttachRoutes attaches multiple routes to an instance
 func (r *InstanceRepository) AttachRoutes(ctx context.Context, instanceID uuid.UUID, routeIDs []uint) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
 		for _, routeID := range routeIDs {
 			instanceRoute := &models.InstanceRoute{
@@ -270,18 +352,28 @@ func (r *InstanceRepository) AttachRoutes(ctx context.Context, instanceID uuid.U
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instanceID))
+	return nil
 }
 
 // DetachRoutes removes multiple routes from an instance
 func (r *InstanceRepository) DetachRoutes(ctx context.Context, instanceID uuid.UUID, routeIDs []uint) error {
-	return r.db.WithContext(ctx).
+	err := r.db.WithContext(ctx).
 		Where("instance_id = ? AND route_id IN ?", instanceID, routeIDs).
 		Delete(&models.InstanceRoute{}).Error
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instanceID))
+	return nil
 }
 
-// SyncRoutes replaces all routes for an instance
-func (r *InstanceRepository) SyncRoutes(ctx context.Context, instanceID uuid.UUID, routeIDs []uint) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+// SyncRoutes replaces all routes for an instance, recording the optional deployment information
+func (r *InstanceRepository) SyncRoutes(ctx context.Context, instanceID uuid.UUID, routeIDs []uint, options *models.InstanceRoute) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// Delete existing routes
 		if err := tx.Where("instance_id = ?", instanceID).Delete(&models.InstanceRoute{}).Error; err != nil {
 			return err
@@ -301,10 +393,19 @@ func (r *InstanceRepository) SyncRoutes(ctx context.Context, instanceID uuid.UUI
 				Enabled:    true,
 				DeployedAt: &now,
 			}
+			if options != nil {
+				instanceRoutes[i].DeployedBy = options.DeployedBy
+				instanceRoutes[i].ConfigVersion = options.ConfigVersion
+			}
 		}
 
 		return tx.Create(&instanceRoutes).Error
 	})
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instanceID))
+	return nil
 }
 
 // GetRoutesByInstance retrieves all routes for a specific instance
@@ -367,9 +468,24 @@ func (r *InstanceRepository) GetInstanceRoute(ctx context.Context, instanceID uu
 	return &instanceRoute, nil
 }
 
+// GetInstanceRoutes retrieves the route attachments of an instance, along with their overrides
+func (r *InstanceRepository) GetInstanceRoutes(ctx context.Context, instanceID uuid.UUID) ([]models.InstanceRoute, error) {
+	var instanceRoutes []models.InstanceRoute
+
+	err := r.db.WithContext(ctx).
+		Where("instance_id = ?", instanceID).
+		Find(&instanceRoutes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return instanceRoutes, nil
+}
+
 // UpdateInstanceRoute updates instance-specific route configuration
 func (r *InstanceRepository) UpdateInstanceRoute(ctx context.Context, instanceRoute *models.InstanceRoute) error {
-	return r.db.WithContext(ctx).
+	err := r.db.WithContext(ctx).
 		Model(instanceRoute).
 		Where("instance_id = ? AND route_id = ?", instanceRoute.InstanceID, instanceRoute.RouteID).
 		Updates(map[string]interface{}{
@@ -379,6 +495,11 @@ func (r *InstanceRepository) UpdateInstanceRoute(ctx context.Context, instanceRo
 			"config_version": instanceRoute.ConfigVersion,
 			"metadata":       instanceRoute.Metadata,
 		}).Error
+	if err != nil {
+		return err
+	}
+	publishConfigChange(instanceConfigChange(instanceRoute.InstanceID))
+	return nil
 }
 
 // GetHealthyInstances retrieves all healthy instances
@@ -6,6 +6,7 @@ import (
 
 	"github.com/jkaninda/goma-admin/internal/db/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MiddlewareRepository struct {
@@ -19,21 +20,33 @@ func NewMiddlewareRepository(db *gorm.DB) *MiddlewareRepository {
 // Create creates a new middleware
 func (r *MiddlewareRepository) Create(ctx context.Context, middleware *models.Middleware) error {
 	if err := r.db.WithContext(ctx).Create(middleware).Error; err != nil {
+		if isUniqueViolation(err) {
+			return duplicateError("middleware already exists: "+middleware.Name, err)
+		}
 		return fmt.Errorf("failed to create middleware: %w", err)
 	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
 	return nil
 }
 
 // CreateBatch creates multiple middlewares in a single transaction
 func (r *MiddlewareRepository) CreateBatch(ctx context.Context, middlewares []models.Middleware) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		for i := range middlewares {
 			if err := tx.Create(&middlewares[i]).Error; err != nil {
+				if isUniqueViolation(err) {
+					return duplicateError("middleware already exists: "+middlewares[i].Name, err)
+				}
 				return fmt.Errorf("failed to create middleware %s: %w", middlewares[i].Name, err)
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
+	return nil
 }
 
 // GetByID retrieves a middleware by ID
@@ -43,7 +56,7 @@ func (r *MiddlewareRepository) GetByID(ctx context.Context, id uint) (*models.Mi
 	err := r.db.WithContext(ctx).First(&middleware, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("middleware not found: %d", id)
+			return nil, notFoundError("middleware not found: %d", id)
 		}
 		return nil, err
 	}
@@ -58,7 +71,7 @@ func (r *MiddlewareRepository) GetByName(ctx context.Context, name string) (*mod
 	err := r.db.WithContext(ctx).Where("name = ?", name).First(&middleware).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("middleware not found: %s", name)
+			return nil, notFoundError("middleware not found: %s", name)
 		}
 		return nil, err
 	}
@@ -163,6 +176,43 @@ func (r *MiddlewareRepository) ListWithPagination(ctx context.Context, page, pag
 	return middlewares, total, nil
 }
 
+// ListFiltered retrieves middlewares with pagination, optionally matching a search query
+// against name or type and restricted to a type
+func (r *MiddlewareRepository) ListFiltered(ctx context.Context, search, middlewareType string, page, pageSize int) ([]models.Middleware, int64, error) {
+	var middlewares []models.Middleware
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.Middleware{})
+	if search != "" {
+		searchPattern := "%" + search + "%"
+		query = query.Where("name ILIKE ? OR type ILIKE ?", searchPattern, searchPattern)
+	}
+	if middlewareType != "" {
+		query = query.Where("type = ?", middlewareType)
+	}
+
+	// Count total records
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// Calculate offset
+	offset := (page - 1) * pageSize
+
+	// Fetch paginated records
+	err := query.
+		Order("name ASC").
+		Limit(pageSize).
+		Offset(offset).
+		Find(&middlewares).Error
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return middlewares, total, nil
+}
+
 // Search searches middlewares by name or type (case-insensitive)
 func (r *MiddlewareRepository) Search(ctx context.Context, query string) ([]models.Middleware, error) {
 	var middlewares []models.Middleware
@@ -181,22 +231,60 @@ func (r *MiddlewareRepository) Search(ctx context.Context, query string) ([]mode
 	return middlewares, nil
 }
 
-// Update updates a middleware
+// Update updates a middleware by ID. Renaming it rewrites the references of the routes using it
+// in the same transaction.
 func (r *MiddlewareRepository) Update(ctx context.Context, middleware *models.Middleware) error {
-	result := r.db.WithContext(ctx).Model(middleware).Updates(map[string]interface{}{
-		"type":  middleware.Type,
-		"paths": middleware.Paths,
-		"rule":  middleware.Rule,
-	})
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Middleware
+		if err := tx.Select("id", "name").First(&current, middleware.ID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return notFoundError("middleware not found: %d", middleware.ID)
+			}
+			return err
+		}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to update middleware: %w", result.Error)
-	}
+		// References can neither keep the previous name nor point to the new one before it exists,
+		// they are set aside while the middleware is renamed
+		var references []models.RouteMiddleware
+		if current.Name != middleware.Name {
+			if err := tx.Where("middleware_name = ?", current.Name).Find(&references).Error; err != nil {
+				return err
+			}
+			if len(references) > 0 {
+				if err := tx.Delete(&references).Error; err != nil {
+					return fmt.Errorf("failed to rename middleware references: %w", err)
+				}
+			}
+		}
 
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("middleware not found: %s", middleware.Name)
+		if err := tx.Model(middleware).Updates(map[string]interface{}{
+			"name":  middleware.Name,
+			"type":  middleware.Type,
+			"paths": middleware.Paths,
+			"rule":  middleware.Rule,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update middleware: %w", err)
+		}
+
+		if len(references) > 0 {
+			for i := range references {
+				references[i].ID = 0
+				references[i].MiddlewareName = middleware.Name
+			}
+			if err := tx.Create(&references).Error; err != nil {
+				return fmt.Errorf("failed to rename middleware references: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return duplicateError("middleware already exists: "+middleware.Name, err)
+		}
+		return err
 	}
 
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
 	return nil
 }
 
@@ -215,22 +303,48 @@ func (r *MiddlewareRepository) UpdateByName(ctx context.Context, name string, up
 		return fmt.Errorf("middleware not found: %s", name)
 	}
 
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
 	return nil
 }
 
-// Delete deletes a middleware by ID
-func (r *MiddlewareRepository) Delete(ctx context.Context, id uint) error {
-	result := r.db.WithContext(ctx).Delete(&models.Middleware{}, id)
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete middleware: %w", result.Error)
-	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("middleware not found: %d", id)
+// Delete deletes a middleware and returns the routes that used it. A middleware used by routes is only deleted,
+// and removed from them, with force; otherwise a conflict error is returned along with the routes.
+// The usage is checked in the transaction of the deletion, with the middleware locked so that it cannot be
+// attached to a route meanwhile.
+func (r *MiddlewareRepository) Delete(ctx context.Context, id uint, force bool) ([]models.Route, error) {
+	var routes []models.Route
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var middleware models.Middleware
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "name").First(&middleware, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return notFoundError("middleware not found: %d", id)
+			}
+			return err
+		}
+		if err := tx.Select("routes.id", "routes.name", "routes.path").
+			Joins("INNER JOIN route_middlewares ON route_middlewares.route_id = routes.id").
+			Where("route_middlewares.middleware_name = ?", middleware.Name).
+			Order("routes.priority DESC, routes.name ASC").
+			Find(&routes).Error; err != nil {
+			return fmt.Errorf("failed to list middleware routes: %w", err)
+		}
+		if len(routes) > 0 && !force {
+			return conflictError("middleware is used by %d routes: %s", len(routes), middleware.Name)
+		}
+		if err := tx.Where("middleware_name = ?", middleware.Name).Delete(&models.RouteMiddleware{}).Error; err != nil {
+			return fmt.Errorf("failed to remove middleware from routes: %w", err)
+		}
+		if err := tx.Delete(&middleware).Error; err != nil {
+			return fmt.Errorf("failed to delete middleware: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return routes, err
 	}
 
-	return nil
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
+	return routes, nil
 }
 
 // DeleteByName deletes a middleware by name
@@ -245,6 +359,7 @@ func (r *MiddlewareRepository) DeleteByName(ctx context.Context, name string) er
 		return fmt.Errorf("middleware not found: %s", name)
 	}
 
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
 	return nil
 }
 
@@ -254,7 +369,7 @@ func (r *MiddlewareRepository) DeleteBatch(ctx context.Context, names []string)
 		return nil
 	}
 
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		result := tx.Where("name IN ?", names).Delete(&models.Middleware{})
 
 		if result.Error != nil {
@@ -263,6 +378,11 @@ func (r *MiddlewareRepository) DeleteBatch(ctx context.Context, names []string)
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceMiddleware})
+	return nil
 }
 
 // Exists checks if a middleware exists by name
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"gorm.io/gorm"
+)
+
+type PermissionRepository struct {
+	db *gorm.DB
+}
+
+func NewPermissionRepository(db *gorm.DB) *PermissionRepository {
+	return &PermissionRepository{db: db}
+}
+
+// Create creates a new permission grant
+func (r *PermissionRepository) Create(ctx context.Context, grant *models.PermissionGrant) error {
+	if err := r.db.WithContext(ctx).Create(grant).Error; err != nil {
+		return fmt.Errorf("failed to create permission grant: %w", err)
+	}
+	return nil
+}
+
+// List retrieves all permission grants
+func (r *PermissionRepository) List(ctx context.Context) ([]models.PermissionGrant, error) {
+	var grants []models.PermissionGrant
+
+	err := r.db.WithContext(ctx).
+		Order("role ASC, environment ASC").
+		Find(&grants).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// ListByRole retrieves the permission grants of a role
+func (r *PermissionRepository) ListByRole(ctx context.Context, role string) ([]models.PermissionGrant, error) {
+	var grants []models.PermissionGrant
+
+	err := r.db.WithContext(ctx).
+		Where("role = ?", role).
+		Find(&grants).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// Delete deletes a permission grant
+func (r *PermissionRepository) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&models.PermissionGrant{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("permission grant not found: %d", id)
+	}
+	return nil
+}
+
+// Count returns the total number of permission grants
+func (r *PermissionRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.PermissionGrant{}).Count(&count).Error
+	return count, err
+}
+
+// ListRolePolicies retrieves the security policies of all roles that have one
+func (r *PermissionRepository) ListRolePolicies(ctx context.Context) ([]models.RolePolicy, error) {
+	var policies []models.RolePolicy
+
+	err := r.db.WithContext(ctx).
+		Order("role ASC").
+		Find(&policies).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// SaveRolePolicy creates or updates the security policy of a role
+func (r *PermissionRepository) SaveRolePolicy(ctx context.Context, policy *models.RolePolicy) error {
+	return r.db.WithContext(ctx).Save(policy).Error
+}
+
+// RequiresMFA checks if the users of a role must use multi-factor authentication
+func (r *PermissionRepository) RequiresMFA(ctx context.Context, role string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.RolePolicy{}).
+		Where("role = ? AND require_mfa = ?", role, true).
+		Count(&count).Error
+
+	return count > 0, err
+}
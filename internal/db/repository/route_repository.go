@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jkaninda/goma-admin/internal/db/models"
 	"gorm.io/gorm"
@@ -19,13 +21,21 @@ func NewRouteRepository(db *gorm.DB) *RouteRepository {
 
 // Create creates a new route with all its associations
 func (r *RouteRepository) Create(ctx context.Context, route *models.Route) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(route).Error; err != nil {
 			return fmt.Errorf("failed to create route: %w", err)
 		}
 
 		return nil
 	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return duplicateError("route already exists: "+route.Name, err)
+		}
+		return err
+	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceRoute})
+	return nil
 }
 
 // GetByID retrieves a route by ID with all associations
@@ -45,7 +55,7 @@ func (r *RouteRepository) GetByID(ctx context.Context, id uint) (*models.Route,
 
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("route not found: %d", id)
+			return nil, notFoundError("route not found: %d", id)
 		}
 		return nil, err
 	}
@@ -71,7 +81,7 @@ func (r *RouteRepository) GetByName(ctx context.Context, name string) (*models.R
 
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("route not found: %s", name)
+			return nil, notFoundError("route not found: %s", name)
 		}
 		return nil, err
 	}
@@ -128,9 +138,10 @@ func (r *RouteRepository) ListEnabled(ctx context.Context) ([]models.Route, erro
 
 // Update updates a route and its associations
 func (r *RouteRepository) Update(ctx context.Context, route *models.Route) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// Update the route basic fields
 		if err := tx.Model(route).Updates(map[string]interface{}{
+			"name":            route.Name,
 			"path":            route.Path,
 			"rewrite":         route.Rewrite,
 			"priority":        route.Priority,
@@ -240,6 +251,67 @@ func (r *RouteRepository) Update(ctx context.Context, route *models.Route) error
 
 		return nil
 	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return duplicateError("route already exists: "+route.Name, err)
+		}
+		return err
+	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceRoute})
+	return nil
+}
+
+// ReorderMiddlewares sets the execution order of the middlewares of a route, without recreating them.
+// names must list every middleware of the route exactly once, in the new execution order.
+func (r *RouteRepository) ReorderMiddlewares(ctx context.Context, routeID uint, names []string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var route models.Route
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&route, routeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return notFoundError("route not found: %d", routeID)
+			}
+			return err
+		}
+		var current []string
+		if err := tx.Model(&models.RouteMiddleware{}).Where("route_id = ?", routeID).Pluck("middleware_name", &current).Error; err != nil {
+			return err
+		}
+		if !sameNames(current, names) {
+			return conflictError("middlewares of route %d changed", routeID)
+		}
+		for i, name := range names {
+			err := tx.Model(&models.RouteMiddleware{}).
+				Where("route_id = ? AND middleware_name = ?", routeID, name).
+				Update("execution_order", i).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&route).UpdateColumn("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceRoute})
+	return nil
+}
+
+// sameNames checks if two lists hold the same names, each once
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, name := range a {
+		set[name] = true
+	}
+	for _, name := range b {
+		if !set[name] {
+			return false
+		}
+		delete(set, name)
+	}
+	return true
 }
 
 // Delete deletes a route and all its associations (cascade)
@@ -249,8 +321,9 @@ func (r *RouteRepository) Delete(ctx context.Context, id uint) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("route not found: %d", id)
+		return notFoundError("route not found: %d", id)
 	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceRoute})
 	return nil
 }
 
@@ -261,8 +334,9 @@ func (r *RouteRepository) DeleteByName(ctx context.Context, name string) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("route not found: %s", name)
+		return notFoundError("route not found: %s", name)
 	}
+	publishConfigChange(ConfigChange{Resource: ConfigResourceRoute})
 	return nil
 }
 
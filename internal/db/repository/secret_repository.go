@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/secrets"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EncryptedColumn is a column of values encrypted with models.EncryptedSerializer
+type EncryptedColumn struct {
+	Table  string
+	Column string
+}
+
+// EncryptedColumns are the columns using models.EncryptedSerializer
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "tls_certificates", Column: "key"},
+	{Table: "securities", Column: "tls_client_cert"},
+	{Table: "securities", Column: "tls_client_key"},
+	{Table: "users", Column: "mfa_secret"},
+	{Table: "instance_credentials", Column: "signing_key"},
+}
+
+// StoredValue is a value of an encrypted column as stored, without being decrypted.
+// ID is the primary key of the row, an integer or a UUID depending on the table.
+type StoredValue struct {
+	ID    any
+	Value string
+}
+
+// SecretRepository reads and writes the stored values of encrypted columns, to re-encrypt them
+type SecretRepository struct {
+	db *gorm.DB
+}
+
+func NewSecretRepository(db *gorm.DB) *SecretRepository {
+	return &SecretRepository{db: db}
+}
+
+// HasEncryptedValues checks if any value of the encrypted columns or secret field of the middleware rules is
+// stored encrypted, which can then only be read with the master key it was encrypted with
+func (r *SecretRepository) HasEncryptedValues(ctx context.Context) (bool, error) {
+	for _, column := range EncryptedColumns {
+		var count int64
+		err := r.db.WithContext(ctx).
+			Table(column.Table).
+			Where("? LIKE ?", clause.Column{Name: column.Column}, secrets.Prefix+"%").
+			Limit(1).
+			Count(&count).Error
+		if err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Middleware{}).
+		Where("rule::text LIKE ?", `%"`+secrets.Prefix+"%").
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ListStoredValues returns the values of an encrypted column that are not null, as stored
+func (r *SecretRepository) ListStoredValues(ctx context.Context, column EncryptedColumn) ([]StoredValue, error) {
+	var values []StoredValue
+	err := r.db.WithContext(ctx).
+		Table(column.Table).
+		Select("id, ? AS value", clause.Column{Name: column.Column}).
+		Where("? IS NOT NULL", clause.Column{Name: column.Column}).
+		Order("id").
+		Scan(&values).Error
+	return values, err
+}
+
+// ReplaceStoredValue replaces a value of an encrypted column unless it changed since it was read,
+// replaced being false when it did
+func (r *SecretRepository) ReplaceStoredValue(ctx context.Context, column EncryptedColumn, id any, previous, value string) (replaced bool, err error) {
+	result := r.db.WithContext(ctx).
+		Table(column.Table).
+		Where("id = ? AND ? = ?", id, clause.Column{Name: column.Column}, previous).
+		UpdateColumn(column.Column, value)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReplaceMiddlewareRule replaces the rule of a middleware unless it changed since it was read,
+// replaced being false when it did. Rules are written as is, their secret fields being already encrypted.
+func (r *SecretRepository) ReplaceMiddlewareRule(ctx context.Context, id uint, previous, rule models.JSONB) (replaced bool, err error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.Middleware{}).
+		Where("id = ? AND rule = ?", id, previous).
+		UpdateColumn("rule", rule)
+	return result.RowsAffected > 0, result.Error
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jkaninda/goma-admin/internal/db/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository struct {
@@ -30,7 +31,7 @@ func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Use
 	err := r.db.WithContext(ctx).First(&user, "id = ?", id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found: %s", id)
+			return nil, notFoundError("user not found: %s", id)
 		}
 		return nil, err
 	}
@@ -45,7 +46,24 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.
 	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found: %s", email)
+			return nil, notFoundError("user not found: %s", email)
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetByExternalID retrieves a user by the subject of an external identity provider
+func (r *UserRepository) GetByExternalID(ctx context.Context, provider, externalID string) (*models.User, error) {
+	var user models.User
+
+	err := r.db.WithContext(ctx).
+		Where("auth_provider = ? AND external_id = ?", provider, externalID).
+		First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, notFoundError("user not found: %s", externalID)
 		}
 		return nil, err
 	}
@@ -60,7 +78,7 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*m
 	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found: %s", username)
+			return nil, notFoundError("user not found: %s", username)
 		}
 		return nil, err
 	}
@@ -111,6 +129,38 @@ func (r *UserRepository) ListActive(ctx context.Context) ([]models.User, error)
 	return users, nil
 }
 
+// ListServiceAccounts retrieves all service accounts
+func (r *UserRepository) ListServiceAccounts(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+
+	err := r.db.WithContext(ctx).
+		Where("service_account = ?", true).
+		Order("created_at DESC").
+		Find(&users).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// ListByAuthProvider retrieves the users signing in with an authentication provider, active or not
+func (r *UserRepository) ListByAuthProvider(ctx context.Context, provider string) ([]models.User, error) {
+	var users []models.User
+
+	err := r.db.WithContext(ctx).
+		Where("auth_provider = ? AND service_account = ?", provider, false).
+		Order("created_at ASC").
+		Find(&users).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 // ListByRole retrieves users by role
 func (r *UserRepository) ListByRole(ctx context.Context, role string) ([]models.User, error) {
 	var users []models.User
@@ -142,23 +192,42 @@ func (r *UserRepository) ListAdminUsers(db *gorm.DB) ([]models.User, error) {
 	return append(admins, superAdmins...), nil
 }
 
-// Search searches users by name, email, or username
-func (r *UserRepository) Search(ctx context.Context, query string) ([]models.User, error) {
+// Search retrieves users with pagination, optionally matching a query against name, email or username
+// and restricted to a role
+func (r *UserRepository) Search(ctx context.Context, search, role string, page, pageSize int) ([]models.User, int64, error) {
 	var users []models.User
+	var total int64
 
-	searchPattern := "%" + query + "%"
+	query := r.db.WithContext(ctx).Model(&models.User{})
+	if search != "" {
+		searchPattern := "%" + search + "%"
+		query = query.Where("name ILIKE ? OR email ILIKE ? OR username ILIKE ?",
+			searchPattern, searchPattern, searchPattern)
+	}
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
 
-	err := r.db.WithContext(ctx).
-		Where("name ILIKE ? OR email ILIKE ? OR username ILIKE ?",
-			searchPattern, searchPattern, searchPattern).
+	// Count total records
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// Calculate offset
+	offset := (page - 1) * pageSize
+
+	// Fetch paginated records
+	err := query.
 		Order("created_at DESC").
+		Limit(pageSize).
+		Offset(offset).
 		Find(&users).Error
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return users, nil
+	return users, total, nil
 }
 
 // Update updates a user
@@ -174,15 +243,28 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 			"email_verified": user.EmailVerified,
 			"active":         user.Active,
 			"metadata":       user.Metadata,
+			"auth_provider":  user.AuthProvider,
+			"external_id":    user.ExternalID,
+		}).Error
+}
+
+// UpdatePassword updates user's password and whether it must be changed at next login
+func (r *UserRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, hashedPassword string, mustChange bool) error {
+	return r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"password":             hashedPassword,
+			"must_change_password": mustChange,
 		}).Error
 }
 
-// UpdatePassword updates user's password
-func (r *UserRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, hashedPassword string) error {
+// RequirePasswordChange requires the user to change their password at next login
+func (r *UserRepository) RequirePasswordChange(ctx context.Context, userID uuid.UUID) error {
 	return r.db.WithContext(ctx).
 		Model(&models.User{}).
 		Where("id = ?", userID).
-		Update("password", hashedPassword).Error
+		Update("must_change_password", true).Error
 }
 
 // UpdateLastLogin updates last login information
@@ -198,12 +280,22 @@ func (r *UserRepository) UpdateLastLogin(ctx context.Context, userID uuid.UUID,
 		}).Error
 }
 
-// IncrementFailedLogins increments failed login attempts
-func (r *UserRepository) IncrementFailedLogins(ctx context.Context, userID uuid.UUID) error {
-	return r.db.WithContext(ctx).
-		Model(&models.User{}).
+// IncrementFailedLogins increments failed login attempts and returns the new count,
+// so that concurrent attempts each see their own increment
+func (r *UserRepository) IncrementFailedLogins(ctx context.Context, userID uuid.UUID) (int, error) {
+	var user models.User
+	result := r.db.WithContext(ctx).
+		Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}}}).
 		Where("id = ?", userID).
-		Update("failed_logins", gorm.Expr("failed_logins + ?", 1)).Error
+		Update("failed_logins", gorm.Expr("failed_logins + ?", 1))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, fmt.Errorf("user not found: %s", userID)
+	}
+	return user.FailedLogins, nil
 }
 
 // LockAccount locks a user account
@@ -249,19 +341,25 @@ func (r *UserRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-// HardDelete permanently deletes a user
+// HardDelete permanently deletes a user. The audit logs of the user are kept, detached from it,
+// with the email of the user recorded in their details so that they remain attributable.
 func (r *UserRepository) HardDelete(ctx context.Context, id uuid.UUID) error {
-	result := r.db.WithContext(ctx).Unscoped().Delete(&models.User{}, "id = ?", id)
-
-	if result.Error != nil {
-		return result.Error
-	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found: %s", id)
-	}
-
-	return nil
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Unscoped().Select("id", "email").First(&user, "id = ?", id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return fmt.Errorf("user not found: %s", id)
+			}
+			return err
+		}
+		if err := tx.Model(&models.AuditLog{}).
+			Where("user_id = ?", id).
+			UpdateColumn("details", gorm.Expr("COALESCE(details, '{}'::jsonb) || jsonb_build_object('userEmail', ?::text)", user.Email)).
+			Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.User{}, "id = ?", id).Error
+	})
 }
 
 // Exists checks if a user exists by email
@@ -319,6 +417,66 @@ func (r *UserRepository) GetSessionByToken(ctx context.Context, token string) (*
 	return &session, nil
 }
 
+// GetSessionByID retrieves a session by ID
+func (r *UserRepository) GetSessionByID(ctx context.Context, id uuid.UUID) (*models.UserSession, error) {
+	var session models.UserSession
+
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		First(&session, "id = ?", id).Error
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("session not found")
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetSessionByRefreshToken retrieves a session by its hashed refresh token
+func (r *UserRepository) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*models.UserSession, error) {
+	var session models.UserSession
+
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("refresh_token = ?", refreshToken).
+		First(&session).Error
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("session not found")
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RotateSessionTokens replaces the session tokens and extends its expiry, provided the session still holds the
+// refresh token hash previousRefreshToken and is neither revoked nor expired. It returns an ErrConflict error
+// otherwise, so that a refresh token is only ever exchanged once.
+func (r *UserRepository) RotateSessionTokens(ctx context.Context, session *models.UserSession, previousRefreshToken string) error {
+	result := r.db.WithContext(ctx).
+		Model(&models.UserSession{}).
+		Where("id = ? AND refresh_token = ? AND revoked_at IS NULL AND expires_at > ?", session.ID, previousRefreshToken, time.Now()).
+		Updates(map[string]interface{}{
+			"token":         session.Token,
+			"refresh_token": session.RefreshToken,
+			"ip_address":    session.IPAddress,
+			"user_agent":    session.UserAgent,
+			"expires_at":    session.ExpiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return conflictError("session %s was refreshed, revoked or expired meanwhile", session.ID)
+	}
+	return nil
+}
+
 // GetUserSessions retrieves all sessions for a user
 func (r *UserRepository) GetUserSessions(ctx context.Context, userID uuid.UUID) ([]models.UserSession, error) {
 	var sessions []models.UserSession
@@ -353,6 +511,52 @@ func (r *UserRepository) RevokeAllUserSessions(ctx context.Context, userID uuid.
 		Update("revoked_at", now).Error
 }
 
+// UpdateMFA updates the multi-factor authentication settings of a user.
+// The user is written as a struct rather than a map, so that the TOTP secret is encrypted by its serializer.
+func (r *UserRepository) UpdateMFA(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).
+		Model(user).
+		Select("mfa_enabled", "mfa_secret", "mfa_recovery_codes", "mfa_last_used_step").
+		Updates(user).Error
+}
+
+// UseMFAStep records a TOTP time step as used unless it is not after the last one used, so that a code
+// can only be accepted once even by concurrent requests. used is false when the step was already used.
+func (r *UserRepository) UseMFAStep(ctx context.Context, userID uuid.UUID, step int64) (used bool, err error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ? AND mfa_last_used_step < ?", userID, step).
+		Update("mfa_last_used_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseRecoveryCode removes a hashed recovery code from the user unless it was already removed,
+// so that a code can only be consumed once even by concurrent requests. used is false when it was.
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hashedCode string) (used bool, err error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ? AND ? = ANY(mfa_recovery_codes)", userID, hashedCode).
+		Update("mfa_recovery_codes", gorm.Expr("array_remove(mfa_recovery_codes, ?)", hashedCode))
+	return result.RowsAffected > 0, result.Error
+}
+
+// UpdateRecoveryCodes replaces the hashed recovery codes of a user
+func (r *UserRepository) UpdateRecoveryCodes(ctx context.Context, userID uuid.UUID, hashedCodes models.StringArray) error {
+	return r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("mfa_recovery_codes", hashedCodes).Error
+}
+
+// RevokeOtherUserSessions revokes all sessions for a user except the given one
+func (r *UserRepository) RevokeOtherUserSessions(ctx context.Context, userID, keepSessionID uuid.UUID) error {
+	now := time.Now()
+	return r.db.WithContext(ctx).
+		Model(&models.UserSession{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Update("revoked_at", now).Error
+}
+
 // DeleteExpiredSessions deletes expired sessions
 func (r *UserRepository) DeleteExpiredSessions(ctx context.Context) error {
 	return r.db.WithContext(ctx).
@@ -360,6 +564,69 @@ func (r *UserRepository) DeleteExpiredSessions(ctx context.Context) error {
 		Delete(&models.UserSession{}).Error
 }
 
+// ===== Password Reset Operations =====
+
+// CreatePasswordResetToken invalidates the pending reset tokens of the user and stores a new one
+func (r *UserRepository) CreatePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// GetPasswordResetToken retrieves a password reset token by its hash
+func (r *UserRepository) GetPasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("token_hash = ?", tokenHash).
+		First(&token).Error
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("password reset token not found")
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ResetPasswordWithToken consumes a password reset token and sets the new password of its user in one transaction,
+// so that a failed update leaves the token usable. It fails with ErrConflict if the token was already used,
+// so that a token can only be consumed once.
+func (r *UserRepository) ResetPasswordWithToken(ctx context.Context, token *models.PasswordResetToken, hashedPassword string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return conflictError("password reset token already used: %s", token.ID)
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ?", token.UserID).
+			Updates(map[string]interface{}{
+				"password":             hashedPassword,
+				"must_change_password": false,
+			}).Error
+	})
+}
+
+// DeleteExpiredPasswordResetTokens deletes expired password reset tokens
+func (r *UserRepository) DeleteExpiredPasswordResetTokens(ctx context.Context) error {
+	return r.db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.PasswordResetToken{}).Error
+}
+
 // ===== Audit Log Operations =====
 
 // CreateAuditLog creates a new audit log entry
@@ -3,7 +3,6 @@ package seed
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/google/uuid"
 	goutils "github.com/jkaninda/go-utils"
@@ -14,6 +13,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// defaultAdminPassword is used when GOMA_ADMIN_PASSWORD is not set.
+// An admin seeded with it must change it on first login.
+const defaultAdminPassword = "Admin@1234"
+
 type AdminConfig struct {
 	Email    string
 	Password string
@@ -25,7 +28,7 @@ type AdminConfig struct {
 func DefaultAdminConfig() *AdminConfig {
 	return &AdminConfig{
 		Email:    goutils.Env("GOMA_ADMIN_EMAIL", "admin@example.com"),
-		Password: goutils.Env("GOMA_ADMIN_PASSWORD", "Admin@1234"),
+		Password: goutils.Env("GOMA_ADMIN_PASSWORD", defaultAdminPassword),
 		Name:     "Administrator",
 		Username: "admin",
 		Role:     models.RoleSuperAdmin,
@@ -47,7 +50,7 @@ func CreateDefaultAdminWithConfig(db *gorm.DB, config *AdminConfig) error {
 	}
 	if !empty {
 		logger.Info("Skipping admin seed: users table is not empty")
-		return nil
+		return requireDefaultPasswordChange(ctx, db, repo)
 	}
 
 	// Check if admin already exists
@@ -67,13 +70,14 @@ func CreateDefaultAdminWithConfig(db *gorm.DB, config *AdminConfig) error {
 
 	// Create admin user
 	admin := &models.User{
-		ID:            uuid.New(),
-		Email:         config.Email,
-		Name:          config.Name,
-		Username:      config.Username,
-		Role:          string(config.Role),
-		EmailVerified: true,
-		Active:        true,
+		ID:                 uuid.New(),
+		Email:              config.Email,
+		Name:               config.Name,
+		Username:           config.Username,
+		Role:               string(config.Role),
+		EmailVerified:      true,
+		Active:             true,
+		MustChangePassword: config.Password == defaultAdminPassword,
 		Metadata: models.JSONB{
 			"created_by": "system",
 			"is_seed":    true,
@@ -92,7 +96,7 @@ func CreateDefaultAdminWithConfig(db *gorm.DB, config *AdminConfig) error {
 
 	// Create audit log
 	auditLog := &models.AuditLog{
-		UserID:     admin.ID,
+		UserID:     &admin.ID,
 		Action:     "admin_created",
 		Resource:   "user",
 		ResourceID: admin.ID.String(),
@@ -110,12 +114,33 @@ func CreateDefaultAdminWithConfig(db *gorm.DB, config *AdminConfig) error {
 
 	logger.Info("Default admin user created successfully", "Email", config.Email, "Username", config.Username)
 
-	if os.Getenv("ADMIN_PASSWORD") == "" {
-		logger.Warn("Using default password. Please change it immediately!")
+	if admin.MustChangePassword {
+		logger.Warn("Using default password, it must be changed on first login")
 	}
 
 	return nil
 }
+
+// requireDefaultPasswordChange forces the admins still using the default password, such as those seeded before
+// the change was required, to change it on their next login
+func requireDefaultPasswordChange(ctx context.Context, db *gorm.DB, repo *repository.UserRepository) error {
+	admins, err := repo.ListAdminUsers(db)
+	if err != nil {
+		return fmt.Errorf("failed to list admin users: %w", err)
+	}
+	for i := range admins {
+		admin := &admins[i]
+		if admin.MustChangePassword || admin.AuthProvider != models.AuthProviderLocal || !admin.CheckPassword(defaultAdminPassword) {
+			continue
+		}
+		if err := repo.RequirePasswordChange(ctx, admin.ID); err != nil {
+			return fmt.Errorf("failed to require a password change: %w", err)
+		}
+		logger.Warn("Admin is using the default password, it must be changed on next login", "Email", admin.Email)
+	}
+	return nil
+}
+
 func IsUsersTableEmpty(ctx context.Context, db *gorm.DB) (bool, error) {
 	var count int64
 	if err := db.WithContext(ctx).Model(&models.User{}).Count(&count).Error; err != nil {
@@ -0,0 +1,46 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// defaultUserEnvironments are the environments a user can self-serve on out of the box
+var defaultUserEnvironments = []models.InstanceEnvironment{
+	models.EnvironmentDevelopment,
+	models.EnvironmentStaging,
+	models.EnvironmentTesting,
+}
+
+// CreateDefaultPermissionGrants allows the user role to modify non-production
+// instances when no permission grant exists yet
+func CreateDefaultPermissionGrants(db *gorm.DB) error {
+	ctx := context.Background()
+	repo := repository.NewPermissionRepository(db)
+
+	count, err := repo.Count(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check permission grants: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, env := range defaultUserEnvironments {
+		grant := &models.PermissionGrant{
+			Role:        string(models.RoleUser),
+			Environment: string(env),
+			Description: "Default grant",
+		}
+		if err := repo.Create(ctx, grant); err != nil {
+			return err
+		}
+	}
+	logger.Info("Default permission grants created", "role", models.RoleUser, "environments", defaultUserEnvironments)
+	return nil
+}
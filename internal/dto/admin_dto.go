@@ -1 +1,61 @@
 package dto
+
+import (
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+)
+
+type CreateUserRequest struct {
+	Email    string `json:"email" required:"true"`
+	Name     string `json:"name"`
+	Username string `json:"username" required:"true"`
+	Password string `json:"password" required:"true"`
+	Role     string `json:"role"`
+}
+
+// UpdateUserRequest only changes the fields that are set
+type UpdateUserRequest struct {
+	Name   *string `json:"name"`
+	Role   *string `json:"role"`
+	Active *bool   `json:"active"`
+}
+
+// ResetPasswordRequest sets a new password, a temporary one is generated when empty
+type ResetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type ResetPasswordResponse struct {
+	TemporaryPassword string `json:"temporary_password,omitempty"`
+}
+
+type RolePolicyRequest struct {
+	RequireMFA bool `json:"require_mfa"`
+}
+
+type UserListResponse struct {
+	Users    []models.User `json:"users"`
+	Total    int64         `json:"total"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name" required:"true"`
+	Scopes []string `json:"scopes" required:"true"`
+	// ExpiresAt is optional, tokens without expiry remain valid until revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPITokenResponse carries the token in clear text, it is only returned once
+type CreateAPITokenResponse struct {
+	Token    string          `json:"token"`
+	APIToken models.APIToken `json:"api_token"`
+}
+
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name" required:"true"`
+	Role        string `json:"role" required:"true"`
+	Description string `json:"description"`
+}
@@ -1,24 +1,104 @@
 package dto
 
+import "time"
+
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" required:"true"`
+	Password string `json:"password" required:"true"`
 }
 
 type RefreshRequest struct {
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token" required:"true"`
 }
 
 type AuthResponse struct {
-	AccessToken string       `json:"access_token"`
-	ExpiresAt   int64        `json:"expires_at"`
-	TokenType   string       `json:"token_type"`
-	User        UserResponse `json:"user"`
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresAt    int64        `json:"expires_at"`
+	TokenType    string       `json:"token_type"`
+	User         UserResponse `json:"user"`
 }
 
 type UserResponse struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
-	Roles string `json:"role"`
+	ID                 string `json:"id"`
+	Email              string `json:"email"`
+	Name               string `json:"name"`
+	Roles              string `json:"role"`
+	MustChangePassword bool   `json:"must_change_password"`
+	MFAEnabled         bool   `json:"mfa_enabled"`
+	// MFAEnrollmentRequired is set when the user's role requires MFA and the user has not enrolled yet
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required"`
+}
+
+// LoginProvidersResponse lists the sign-in methods shown on the login page
+type LoginProvidersResponse struct {
+	Local bool                 `json:"local_login"`
+	LDAP  bool                 `json:"ldap_login"`
+	OIDC  OIDCProviderResponse `json:"oidc"`
+}
+
+type OIDCProviderResponse struct {
+	Enabled     bool   `json:"enabled"`
+	DisplayName string `json:"name"`
+}
+
+type SessionResponse struct {
+	ID        string    `json:"id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" required:"true"`
+}
+
+type ResetPasswordWithTokenRequest struct {
+	Token    string `json:"token" required:"true"`
+	Password string `json:"password" required:"true"`
+}
+
+// UpdateProfileRequest only changes the fields that are set
+type UpdateProfileRequest struct {
+	Name     *string `json:"name"`
+	Username *string `json:"username"`
+	Avatar   *string `json:"avatar"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" required:"true"`
+	NewPassword     string `json:"new_password" required:"true"`
+}
+
+// MFAChallengeResponse is returned by login instead of tokens when the user has MFA enabled
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// MFAVerifyRequest completes a login with a TOTP code or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" required:"true"`
+	Code     string `json:"code" required:"true"`
+}
+
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" required:"true"`
+}
+
+type MFADisableRequest struct {
+	Password string `json:"password" required:"true"`
+	Code     string `json:"code" required:"true"`
+}
+
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
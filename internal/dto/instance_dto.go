@@ -0,0 +1,121 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+)
+
+type InstanceRequest struct {
+	Name            string         `json:"name" required:"true"`
+	Environment     string         `json:"environment" required:"true"`
+	Description     string         `json:"description"`
+	Endpoint        string         `json:"endpoint" required:"true"`
+	MetricsEndpoint string         `json:"metrics_endpoint"`
+	HealthEndpoint  string         `json:"health_endpoint"`
+	Region          string         `json:"region"`
+	Tags            []string       `json:"tags"`
+	Enabled         *bool          `json:"enabled"`
+	Metadata        map[string]any `json:"metadata"`
+}
+
+type AttachRouteRequest struct {
+	RouteID  uint  `json:"route_id" required:"true"`
+	Enabled  *bool `json:"enabled"`
+	Priority *int  `json:"priority"`
+}
+
+type DeployRequest struct {
+	RouteIDs      []uint `json:"route_ids"`
+	ConfigVersion string `json:"config_version"`
+}
+
+type PermissionGrantRequest struct {
+	Role        string   `json:"role" required:"true"`
+	Environment string   `json:"environment" required:"true"`
+	Tags        []string `json:"tags"`
+	Description string   `json:"description"`
+}
+
+type RotateInstanceCredentialRequest struct {
+	// GracePeriod is how long the previous credentials keep working, such as 1h, the configured default when empty
+	GracePeriod string `json:"grace_period"`
+}
+
+// RotateInstanceCredentialResponse carries the credential secret in clear text, it is only returned once
+type RotateInstanceCredentialResponse struct {
+	Secret     string                    `json:"secret"`
+	Credential models.InstanceCredential `json:"credential"`
+}
+
+type CreateEnrollmentTokenRequest struct {
+	// Environment is the environment of the instances registering with the token
+	Environment string `json:"environment" required:"true"`
+	Description string `json:"description"`
+	// ExpiresAt is optional, tokens without expiry remain valid until revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateEnrollmentTokenResponse carries the token in clear text, it is only returned once
+type CreateEnrollmentTokenResponse struct {
+	Token           string                 `json:"token"`
+	EnrollmentToken models.EnrollmentToken `json:"enrollment_token"`
+}
+
+// RegisterInstanceRequest is sent by an unknown gateway instance registering itself with an enrollment token
+type RegisterInstanceRequest struct {
+	Endpoint        string         `json:"endpoint" required:"true"`
+	MetricsEndpoint string         `json:"metrics_endpoint"`
+	HealthEndpoint  string         `json:"health_endpoint"`
+	Region          string         `json:"region"`
+	Tags            []string       `json:"tags"`
+	Version         string         `json:"version"`
+	Metadata        map[string]any `json:"metadata"`
+}
+
+// RegisterInstanceResponse carries the credential secret of the registered instance in clear text, it is only returned once
+type RegisterInstanceResponse struct {
+	Secret     string                    `json:"secret"`
+	Instance   models.Instance           `json:"instance"`
+	Credential models.InstanceCredential `json:"credential"`
+}
+
+type ClientCertificateRequest struct {
+	// Certificate is the PEM encoded client certificate the instance presents
+	Certificate string `json:"certificate" required:"true"`
+}
+
+// WebhookRequest is the report a gateway instance sends to the provider webhook
+type WebhookRequest struct {
+	// Status is one of active, inactive, unhealthy or unknown
+	Status string `json:"status" required:"true"`
+	// Version is the version of the gateway
+	Version string `json:"version"`
+	// ConfigVersion is the X-Goma-Config-Version of the configuration the gateway applied
+	ConfigVersion string                 `json:"config_version"`
+	Backends      []models.BackendHealth `json:"backends"`
+	// ErrorCounts are error counters, such as per status code class or per route
+	ErrorCounts map[string]int64 `json:"error_counts"`
+}
+
+type WebhookResponse struct {
+	// ConfigVersion is the version of the configuration currently published to the instance
+	ConfigVersion string `json:"config_version"`
+	InSync        bool   `json:"in_sync"`
+}
+
+type InstanceReportListResponse struct {
+	Reports  []models.InstanceReport `json:"reports"`
+	Total    int64                   `json:"total"`
+	Page     int                     `json:"page"`
+	PageSize int                     `json:"page_size"`
+}
+
+// InstanceDriftResponse compares the configuration published to an instance with the one it runs
+type InstanceDriftResponse struct {
+	PublishedConfigVersion string                 `json:"published_config_version"`
+	AppliedConfigVersion   string                 `json:"applied_config_version"`
+	InSync                 bool                   `json:"in_sync"`
+	LastSeen               *time.Time             `json:"last_seen"`
+	LastReport             *models.InstanceReport `json:"last_report"`
+}
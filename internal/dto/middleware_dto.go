@@ -0,0 +1,44 @@
+package dto
+
+import (
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/okapi"
+)
+
+// MiddlewareRequest creates or replaces a middleware, using the names of the Goma Gateway configuration
+type MiddlewareRequest struct {
+	Name  string         `json:"name" required:"true"`
+	Type  string         `json:"type" required:"true"`
+	Paths []string       `json:"paths"`
+	Rule  map[string]any `json:"rule"`
+}
+
+type MiddlewareBatchRequest struct {
+	Middlewares []MiddlewareRequest `json:"middlewares" required:"true"`
+}
+
+// MiddlewareResponse is a middleware along with the ID it is managed by
+type MiddlewareResponse struct {
+	ID uint `json:"id"`
+	models.Middleware
+}
+
+type MiddlewareListResponse struct {
+	Middlewares []MiddlewareResponse `json:"middlewares"`
+	Total       int64                `json:"total"`
+	Page        int                  `json:"page"`
+	PageSize    int                  `json:"page_size"`
+}
+
+// RouteReference identifies a route referencing a middleware
+type RouteReference struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// MiddlewareInUseResponse refuses the deletion of a middleware still used by routes
+type MiddlewareInUseResponse struct {
+	okapi.ErrorResponse
+	Routes []RouteReference `json:"routes"`
+}
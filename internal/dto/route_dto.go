@@ -0,0 +1,65 @@
+package dto
+
+import "github.com/jkaninda/goma-admin/internal/db/models"
+
+// RouteRequest creates or replaces a route. Fields use the names of the Goma Gateway configuration,
+// so that a route returned by the API can be sent back as is.
+type RouteRequest struct {
+	Name           string              `json:"name" required:"true"`
+	Path           string              `json:"path" required:"true"`
+	Rewrite        *string             `json:"rewrite"`
+	Priority       int                 `json:"priority"`
+	Enabled        *bool               `json:"enabled"`
+	Methods        []string            `json:"methods"`
+	Hosts          []string            `json:"hosts"`
+	Target         *string             `json:"target"`
+	DisableMetrics bool                `json:"disableMetrics"`
+	Backends       []models.Backend    `json:"backends"`
+	Maintenance    *models.Maintenance `json:"maintenance"`
+	// TLS certificates without a key keep the key stored with the same certificate
+	TLS         *models.TLSWrapper  `json:"tls"`
+	HealthCheck *models.HealthCheck `json:"healthCheck"`
+	// Security.TLS without clientCert and clientKey keeps the stored ones, empty strings remove them
+	Security *models.Security `json:"security"`
+	// Middlewares are the names of the middlewares applied to the route, in execution order
+	Middlewares []string `json:"middlewares"`
+}
+
+// RouteMiddlewareOrderRequest sets the execution order of the middlewares of a route
+type RouteMiddlewareOrderRequest struct {
+	// Middlewares lists every middleware of the route once, in the new execution order
+	Middlewares []string `json:"middlewares" required:"true"`
+}
+
+// RouteResponse is a route as returned by the admin API. Private keys and client certificates are never
+// returned, only their fingerprints.
+type RouteResponse struct {
+	models.Route
+	TLS      *TLSResponse      `json:"tls,omitempty"`
+	Security *SecurityResponse `json:"security,omitempty"`
+}
+
+type TLSResponse struct {
+	Certificates []TLSCertificateResponse `json:"certificates,omitempty"`
+}
+
+type TLSCertificateResponse struct {
+	Cert string `json:"cert"`
+	// KeyFingerprint is the SHA-256 fingerprint of the public key of the private key
+	KeyFingerprint string `json:"keyFingerprint"`
+}
+
+type SecurityResponse struct {
+	ForwardHostHeaders      bool                 `json:"forwardHostHeaders"`
+	EnableExploitProtection bool                 `json:"enableExploitProtection"`
+	TLS                     *SecurityTLSResponse `json:"tls,omitempty"`
+}
+
+type SecurityTLSResponse struct {
+	InsecureSkipVerify bool    `json:"insecureSkipVerify,omitempty"`
+	RootCAs            *string `json:"rootCAs,omitempty"`
+	// ClientCertFingerprint is the SHA-256 fingerprint of the client certificate
+	ClientCertFingerprint string `json:"clientCertFingerprint,omitempty"`
+	// ClientKeyFingerprint is the SHA-256 fingerprint of the public key of the client private key
+	ClientKeyFingerprint string `json:"clientKeyFingerprint,omitempty"`
+}
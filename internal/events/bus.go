@@ -0,0 +1,154 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisChannel is the Redis pub/sub channel configuration changes are relayed on
+	redisChannel = "goma-admin:config-changes"
+	// publishTimeout bounds the relay of a change to Redis
+	publishTimeout = 5 * time.Second
+	// subscriberBuffer is the number of changes a subscriber can lag behind before changes are dropped for it
+	subscriberBuffer = 16
+)
+
+// message is a change relayed through Redis, along with the replica it was made on
+type message struct {
+	Origin string                  `json:"origin"`
+	Change repository.ConfigChange `json:"change"`
+}
+
+// Bus delivers the configuration changes committed by the repositories of every admin replica.
+// Changes made on this replica are delivered right away, and relayed to the other replicas through
+// Redis pub/sub when enabled.
+type Bus struct {
+	origin string
+	client *redis.Client
+
+	mu          sync.RWMutex
+	listeners   []func(repository.ConfigChange)
+	subscribers map[chan repository.ConfigChange]struct{}
+}
+
+// NewBus creates a bus fed by the configuration changes of the repositories
+func NewBus(conf *config.RedisConfig) (*Bus, error) {
+	bus := &Bus{
+		origin:      uuid.NewString(),
+		subscribers: make(map[chan repository.ConfigChange]struct{}),
+	}
+	if conf.Enabled {
+		options, err := redis.ParseURL(conf.URL)
+		if err != nil {
+			return nil, err
+		}
+		bus.client = redis.NewClient(options)
+	}
+	repository.OnConfigChange(bus.publish)
+	return bus, nil
+}
+
+// Start receives the changes of the other replicas until the context is cancelled
+func (b *Bus) Start(ctx context.Context) {
+	if b.client == nil {
+		return
+	}
+	pubsub := b.client.Subscribe(ctx, redisChannel)
+	go func() {
+		defer b.client.Close()
+		for received := range pubsub.ChannelWithSubscriptions() {
+			switch received := received.(type) {
+			case *redis.Subscription:
+				// Changes published while disconnected were missed, drop everything derived from the configuration
+				if received.Kind == "subscribe" {
+					b.dispatch(repository.ConfigChange{})
+				}
+			case *redis.Message:
+				var msg message
+				if err := json.Unmarshal([]byte(received.Payload), &msg); err != nil {
+					logger.Warn("Ignoring malformed configuration change", "error", err)
+					continue
+				}
+				if msg.Origin != b.origin {
+					b.dispatch(msg.Change)
+				}
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Close()
+	}()
+	logger.Info("Relaying configuration changes through Redis", "channel", redisChannel)
+}
+
+// Listen registers a function called synchronously with every change, before subscribers are notified.
+// Listeners must not block.
+func (b *Bus) Listen(listener func(repository.ConfigChange)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Subscribe returns a channel receiving the changes, and the function to call once done with it.
+// Changes are dropped while the channel is full, a subscriber woken up must therefore check the
+// current state rather than rely on the changes it receives.
+func (b *Bus) Subscribe() (<-chan repository.ConfigChange, func()) {
+	ch := make(chan repository.ConfigChange, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// publish delivers a change made on this replica, and relays it to the other replicas
+func (b *Bus) publish(change repository.ConfigChange) {
+	b.dispatch(change)
+	if b.client == nil {
+		return
+	}
+	payload, err := json.Marshal(message{Origin: b.origin, Change: change})
+	if err != nil {
+		logger.Error("Failed to encode configuration change", "error", err)
+		return
+	}
+	// Repositories must not wait for Redis, changes carry no state so their order does not matter
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+		if err := b.client.Publish(ctx, redisChannel, payload).Err(); err != nil {
+			logger.Error("Failed to relay configuration change", "error", err)
+		}
+	}()
+}
+
+func (b *Bus) dispatch(change repository.ConfigChange) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, listener := range b.listeners {
+		listener(change)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/ldap"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// ldapSyncMarker flags, in the user metadata, accounts deactivated by the sync.
+// Only those are reactivated when their directory entry is enabled again, not the ones deactivated by an admin.
+const ldapSyncMarker = "ldap_sync_deactivated"
+
+// StartLDAPSync periodically deactivates the directory users that were removed or disabled in the directory,
+// and reactivates those enabled again, until the context is cancelled
+func StartLDAPSync(ctx context.Context, db *gorm.DB, conf *config.LDAPConfig) {
+	if !conf.Enabled {
+		return
+	}
+	client, err := ldap.NewClient(conf)
+	if err != nil {
+		logger.Error("LDAP sync job not started", "error", err)
+		return
+	}
+	repo := repository.NewUserRepository(db)
+	ticker := time.NewTicker(conf.SyncInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := syncLDAPUsers(ctx, repo, client); err != nil {
+					logger.Error("Failed to synchronize LDAP users", "error", err)
+				}
+			}
+		}
+	}()
+	logger.Info("LDAP sync job started", "interval", conf.SyncInterval)
+}
+
+// syncLDAPUsers aligns the active flag of every directory user with its entry.
+// The run stops at the first directory error so that an outage never deactivates users.
+func syncLDAPUsers(ctx context.Context, repo *repository.UserRepository, client *ldap.Client) error {
+	users, err := repo.ListByAuthProvider(ctx, models.AuthProviderLDAP)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		if ctx.Err() != nil {
+			return nil
+		}
+		user := &users[i]
+		entry, err := client.Lookup(user.ExternalID)
+		if err != nil && !errors.Is(err, ldap.ErrUserNotFound) {
+			return err
+		}
+		enabled := err == nil && !entry.Disabled
+		switch {
+		case user.Active && !enabled:
+			user.Active = false
+			if user.Metadata == nil {
+				user.Metadata = models.JSONB{}
+			}
+			user.Metadata[ldapSyncMarker] = true
+			if err := repo.Update(ctx, user); err != nil {
+				return err
+			}
+			if err := repo.RevokeAllUserSessions(ctx, user.ID); err != nil {
+				logger.Error("Failed to revoke sessions of deactivated LDAP user", "user", user.ID, "error", err)
+			}
+			logger.Info("Deactivated user removed or disabled in the directory", "user", user.ID, "dn", user.ExternalID)
+		case !user.Active && enabled && user.Metadata[ldapSyncMarker] == true:
+			user.Active = true
+			delete(user.Metadata, ldapSyncMarker)
+			if err := repo.Update(ctx, user); err != nil {
+				return err
+			}
+			logger.Info("Reactivated user enabled again in the directory", "user", user.ID, "dn", user.ExternalID)
+		case user.Active && user.Metadata[ldapSyncMarker] != nil:
+			// Reactivated by an admin, a later deactivation by an admin must stick
+			delete(user.Metadata, ldapSyncMarker)
+			if err := repo.Update(ctx, user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// nonceCleanupInterval is how often the expired nonces of signed requests are purged
+const nonceCleanupInterval = 10 * time.Minute
+
+// StartNonceCleanup periodically deletes the nonces of signed instance requests that can no longer be replayed
+// until the context is cancelled
+func StartNonceCleanup(ctx context.Context, db *gorm.DB) {
+	repo := repository.NewInstanceCredentialRepository(db)
+	ticker := time.NewTicker(nonceCleanupInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := repo.DeleteExpiredNonces(ctx)
+				if err != nil {
+					logger.Error("Failed to delete expired request nonces", "error", err)
+				} else if deleted > 0 {
+					logger.Debug("Deleted expired request nonces", "count", deleted)
+				}
+			}
+		}
+	}()
+}
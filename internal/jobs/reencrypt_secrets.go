@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/rules"
+	"github.com/jkaninda/goma-admin/internal/secrets"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// ReencryptSecrets encrypts every secret stored in the database that is not encrypted with the current master key
+// with it, including the secrets stored before encryption was introduced. It is run after a rotation of the master
+// key, once every replica knows the new key, and can run while replicas serve requests: a value changed meanwhile
+// is left as written, which is with the current key.
+func ReencryptSecrets(ctx context.Context, db *gorm.DB, cipher *secrets.Cipher) error {
+	return encryptSecrets(ctx, db, cipher, true)
+}
+
+// EncryptPlaintextSecrets encrypts the secrets stored before encryption was introduced. It is run on startup;
+// values encrypted with a previous master key are left to ReencryptSecrets, as other replicas may not know the
+// current key yet.
+func EncryptPlaintextSecrets(ctx context.Context, db *gorm.DB, cipher *secrets.Cipher) error {
+	return encryptSecrets(ctx, db, cipher, false)
+}
+
+// encryptSecrets encrypts the plaintext secrets, and the secrets encrypted with another master key than the
+// current one when reencrypt is set
+func encryptSecrets(ctx context.Context, db *gorm.DB, cipher *secrets.Cipher, reencrypt bool) error {
+	secretRepo := repository.NewSecretRepository(db)
+	for _, column := range repository.EncryptedColumns {
+		values, err := secretRepo.ListStoredValues(ctx, column)
+		if err != nil {
+			return fmt.Errorf("failed to list %s.%s: %w", column.Table, column.Column, err)
+		}
+		count := 0
+		for _, stored := range values {
+			if secrets.IsEncrypted(stored.Value) && (!reencrypt || cipher.IsCurrent(stored.Value)) {
+				continue
+			}
+			value, err := cipher.Reencrypt(ctx, stored.Value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s.%s of row %v: %w", column.Table, column.Column, stored.ID, err)
+			}
+			replaced, err := secretRepo.ReplaceStoredValue(ctx, column, stored.ID, stored.Value, value)
+			if err != nil {
+				return fmt.Errorf("failed to update %s.%s of row %v: %w", column.Table, column.Column, stored.ID, err)
+			}
+			if replaced {
+				count++
+			}
+		}
+		if count > 0 || reencrypt {
+			logger.Info("Encrypted secrets", "table", column.Table, "column", column.Column, "count", count, "total", len(values))
+		}
+	}
+
+	middlewares, err := repository.NewMiddlewareRepository(db).List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list middlewares: %w", err)
+	}
+	count := 0
+	for _, middleware := range middlewares {
+		var rule models.JSONB
+		var changed bool
+		if reencrypt {
+			rule, changed, err = rules.ReencryptSecrets(ctx, cipher, middleware.Type, middleware.Rule)
+		} else {
+			rule, changed, err = rules.EncryptPlaintextSecrets(ctx, cipher, middleware.Type, middleware.Rule)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the rule of middleware %s: %w", middleware.Name, err)
+		}
+		if !changed {
+			continue
+		}
+		replaced, err := secretRepo.ReplaceMiddlewareRule(ctx, middleware.ID, middleware.Rule, rule)
+		if err != nil {
+			return fmt.Errorf("failed to update the rule of middleware %s: %w", middleware.Name, err)
+		}
+		if replaced {
+			count++
+		}
+	}
+	if count > 0 || reencrypt {
+		logger.Info("Encrypted middleware secrets", "count", count, "total", len(middlewares))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// reportCleanupInterval is how often expired instance reports are purged
+const reportCleanupInterval = time.Hour
+
+// StartReportCleanup periodically deletes the instance reports older than the retention
+// until the context is cancelled
+func StartReportCleanup(ctx context.Context, db *gorm.DB, retention time.Duration) {
+	repo := repository.NewInstanceReportRepository(db)
+	ticker := time.NewTicker(reportCleanupInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := repo.DeleteOlderThan(ctx, time.Now().Add(-retention))
+				if err != nil {
+					logger.Error("Failed to delete expired instance reports", "error", err)
+				} else if deleted > 0 {
+					logger.Info("Deleted expired instance reports", "count", deleted)
+				}
+			}
+		}
+	}()
+	logger.Info("Instance report cleanup job started", "retention", retention)
+}
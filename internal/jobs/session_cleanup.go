@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// StartSessionCleanup periodically deletes expired user sessions and password reset tokens
+// until the context is cancelled
+func StartSessionCleanup(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	repo := repository.NewUserRepository(db)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := repo.DeleteExpiredSessions(ctx); err != nil {
+					logger.Error("Failed to delete expired sessions", "error", err)
+				}
+				if err := repo.DeleteExpiredPasswordResetTokens(ctx); err != nil {
+					logger.Error("Failed to delete expired password reset tokens", "error", err)
+				}
+			}
+		}
+	}()
+	logger.Info("Session cleanup job started", "interval", interval)
+}
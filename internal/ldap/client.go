@@ -0,0 +1,257 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/jkaninda/goma-admin/internal/config"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the login is unknown or the password is wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is returned when no entry matches the login
+	ErrUserNotFound = errors.New("user not found in directory")
+)
+
+// adAccountDisabled is the ACCOUNTDISABLE flag of the Active Directory userAccountControl attribute
+const adAccountDisabled = 0x2
+
+// Entry is a user found in the directory
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Name     string
+	// Groups holds the DN and the common name of every group the user belongs to
+	Groups []string
+	// Disabled is set for Active Directory accounts flagged as disabled
+	Disabled bool
+}
+
+// Client authenticates users against an LDAP or Active Directory server.
+// A connection is opened for every operation.
+type Client struct {
+	conf      *config.LDAPConfig
+	tlsConfig *tls.Config
+}
+
+func NewClient(conf *config.LDAPConfig) (*Client, error) {
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conf: conf, tlsConfig: tlsConfig}, nil
+}
+
+// Authenticate finds the user matching the login with the service account, then binds as that user
+// to verify the password
+func (cl *Client) Authenticate(login, password string) (*Entry, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := cl.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := cl.findUser(conn, cl.conf.UserFilter, login)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to bind as %s: %w", entry.DN, err)
+	}
+	// Group lookups are made with the service account, users may not be allowed to read groups
+	if err := cl.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	if err := cl.loadGroups(conn, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Lookup returns the current entry of a user by DN, or ErrUserNotFound when it no longer exists
+func (cl *Client) Lookup(dn string) (*Entry, error) {
+	conn, err := cl.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.Search(goldap.NewSearchRequest(
+		dn, goldap.ScopeBaseObject, goldap.NeverDerefAliases, 1, cl.timeLimit(), false,
+		"(objectClass=*)", cl.userAttributes(), nil,
+	))
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to look up %s: %w", dn, err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+	entry := cl.toEntry(result.Entries[0])
+	if err := cl.loadGroups(conn, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// connect opens a connection, upgrades it with StartTLS when configured and binds with the service account
+func (cl *Client) connect() (*goldap.Conn, error) {
+	dialer := goldap.DialWithDialer(&net.Dialer{Timeout: cl.conf.Timeout})
+	conn, err := goldap.DialURL(cl.conf.URL, dialer, goldap.DialWithTLSConfig(cl.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(cl.conf.Timeout)
+	if cl.conf.StartTLS {
+		if err := conn.StartTLS(cl.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if err := cl.bindServiceAccount(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// bindServiceAccount binds with the configured service account, or anonymously when none is configured
+func (cl *Client) bindServiceAccount(conn *goldap.Conn) error {
+	var err error
+	if cl.conf.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(cl.conf.BindDN, cl.conf.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind with the service account: %w", err)
+	}
+	return nil
+}
+
+// findUser returns the single entry matching the filter, where {login} is replaced by the escaped login
+func (cl *Client) findUser(conn *goldap.Conn, filter, login string) (*Entry, error) {
+	result, err := conn.Search(goldap.NewSearchRequest(
+		cl.conf.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 2, cl.timeLimit(), false,
+		strings.ReplaceAll(filter, "{login}", goldap.EscapeFilter(login)), cl.userAttributes(), nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return cl.toEntry(result.Entries[0]), nil
+	default:
+		return nil, fmt.Errorf("login %q matches more than one directory entry", login)
+	}
+}
+
+// loadGroups adds the groups found with the group search, if configured, to the groups read from the user entry
+func (cl *Client) loadGroups(conn *goldap.Conn, entry *Entry) error {
+	if cl.conf.GroupBaseDN == "" {
+		return nil
+	}
+	result, err := conn.Search(goldap.NewSearchRequest(
+		cl.conf.GroupBaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, cl.timeLimit(), false,
+		strings.ReplaceAll(cl.conf.GroupFilter, "{dn}", goldap.EscapeFilter(entry.DN)), []string{"cn"}, nil,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to search groups: %w", err)
+	}
+	for _, group := range result.Entries {
+		entry.Groups = append(entry.Groups, groupNames(group.DN)...)
+	}
+	return nil
+}
+
+func (cl *Client) userAttributes() []string {
+	return []string{
+		cl.conf.UsernameAttribute, cl.conf.EmailAttribute, cl.conf.NameAttribute,
+		cl.conf.GroupAttribute, "userAccountControl",
+	}
+}
+
+func (cl *Client) toEntry(result *goldap.Entry) *Entry {
+	entry := &Entry{
+		DN:       result.DN,
+		Username: result.GetAttributeValue(cl.conf.UsernameAttribute),
+		Email:    result.GetAttributeValue(cl.conf.EmailAttribute),
+		Name:     result.GetAttributeValue(cl.conf.NameAttribute),
+	}
+	if cl.conf.GroupAttribute != "" {
+		for _, group := range result.GetAttributeValues(cl.conf.GroupAttribute) {
+			entry.Groups = append(entry.Groups, groupNames(group)...)
+		}
+	}
+	if flags, err := strconv.Atoi(result.GetAttributeValue("userAccountControl")); err == nil {
+		entry.Disabled = flags&adAccountDisabled != 0
+	}
+	return entry
+}
+
+func (cl *Client) timeLimit() int {
+	return int(cl.conf.Timeout.Seconds())
+}
+
+// groupNames returns a group DN along with its common name, so that role mappings can use either
+func groupNames(dn string) []string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return []string{dn}
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return []string{dn, attr.Value}
+		}
+	}
+	return []string{dn}
+}
+
+func newTLSConfig(conf *config.LDAPConfig) (*tls.Config, error) {
+	serverURL, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		// StartTLS upgrades a plain connection and cannot infer the server name from it
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if conf.CACertFile != "" {
+		pem, err := os.ReadFile(conf.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,380 @@
+package ldap
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/jkaninda/goma-admin/internal/config"
+)
+
+const (
+	testBaseDN       = "dc=goma,dc=local"
+	testBindDN       = "cn=admin,dc=goma,dc=local"
+	testBindPassword = "admin"
+)
+
+// LDAP protocol operations and filters used by the client
+const (
+	opBindRequest       = 0
+	opBindResponse      = 1
+	opUnbindRequest     = 2
+	opSearchRequest     = 3
+	opSearchResultEntry = 4
+	opSearchResultDone  = 5
+
+	filterAnd      = 0
+	filterOr       = 1
+	filterNot      = 2
+	filterEquality = 3
+	filterPresent  = 7
+)
+
+// fakeDirectory is an in-process LDAP server answering simple binds and searches
+// with equality, presence, and, or and not filters
+type fakeDirectory struct {
+	listener net.Listener
+	// passwords are keyed by DN
+	passwords map[string]string
+	entries   []*goldap.Entry
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{
+		listener: listener,
+		passwords: map[string]string{
+			testBindDN:                            testBindPassword,
+			"uid=jane,ou=people,dc=goma,dc=local": "jane-password",
+			"uid=bob,ou=people,dc=goma,dc=local":  "bob-password",
+		},
+		entries: []*goldap.Entry{
+			goldap.NewEntry("uid=jane,ou=people,dc=goma,dc=local", map[string][]string{
+				"uid":      {"jane"},
+				"mail":     {"jane@goma.local"},
+				"cn":       {"Jane Doe"},
+				"memberOf": {"cn=developers,ou=groups,dc=goma,dc=local"},
+			}),
+			goldap.NewEntry("uid=bob,ou=people,dc=goma,dc=local", map[string][]string{
+				"uid":                {"bob"},
+				"mail":               {"bob@goma.local"},
+				"cn":                 {"Bob"},
+				"userAccountControl": {"514"},
+			}),
+			// Two entries share this email, so that a login by email is ambiguous
+			goldap.NewEntry("uid=ops1,ou=people,dc=goma,dc=local", map[string][]string{
+				"uid":  {"ops1"},
+				"mail": {"ops@goma.local"},
+			}),
+			goldap.NewEntry("uid=ops2,ou=people,dc=goma,dc=local", map[string][]string{
+				"uid":  {"ops2"},
+				"mail": {"ops@goma.local"},
+			}),
+			goldap.NewEntry("cn=admins,ou=groups,dc=goma,dc=local", map[string][]string{
+				"cn":     {"admins"},
+				"member": {"uid=jane,ou=people,dc=goma,dc=local"},
+			}),
+		},
+	}
+	go d.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+	return d
+}
+
+func (d *fakeDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case opBindRequest:
+			code := goldap.LDAPResultInvalidCredentials
+			dn := op.Children[1].Value.(string)
+			password, ok := d.passwords[dn]
+			if dn == "" || ok && op.Children[2].Data.String() == password {
+				code = goldap.LDAPResultSuccess
+			}
+			d.write(conn, messageID, ldapResult(opBindResponse, uint16(code)))
+		case opSearchRequest:
+			base := op.Children[0].Value.(string)
+			scope := op.Children[1].Value.(int64)
+			found := false
+			for _, entry := range d.entries {
+				if !inScope(entry.DN, base, scope) || !matches(entry, op.Children[6]) {
+					continue
+				}
+				found = true
+				d.write(conn, messageID, searchResultEntry(entry))
+			}
+			code := goldap.LDAPResultSuccess
+			if scope == goldap.ScopeBaseObject && !found {
+				code = goldap.LDAPResultNoSuchObject
+			}
+			d.write(conn, messageID, ldapResult(opSearchResultDone, uint16(code)))
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) write(conn net.Conn, messageID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func searchResultEntry(entry *goldap.Entry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributes := ber.NewSequence("Attributes")
+	for _, attr := range entry.Attributes {
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.Name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range attr.Values {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+	return result
+}
+
+func inScope(dn, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	if scope == goldap.ScopeBaseObject {
+		return dn == base
+	}
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+func matches(entry *goldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !matches(entry, filter.Children[0])
+	case filterEquality:
+		name, value := filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+		for _, v := range entry.GetEqualFoldAttributeValues(name) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		name := filter.Data.String()
+		return strings.EqualFold(name, "objectClass") || len(entry.GetEqualFoldAttributeValues(name)) > 0
+	}
+	return false
+}
+
+func newTestClient(t *testing.T, d *fakeDirectory, configure func(*config.LDAPConfig)) *Client {
+	t.Helper()
+	conf := &config.LDAPConfig{
+		Enabled:           true,
+		URL:               d.url(),
+		AllowInsecure:     true,
+		BindDN:            testBindDN,
+		BindPassword:      testBindPassword,
+		BaseDN:            testBaseDN,
+		UserFilter:        "(|(uid={login})(mail={login}))",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		NameAttribute:     "cn",
+		GroupAttribute:    "memberOf",
+		GroupFilter:       "(|(member={dn})(uniqueMember={dn}))",
+		Timeout:           5 * time.Second,
+	}
+	if configure != nil {
+		configure(conf)
+	}
+	client, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newFakeDirectory(t)
+	tests := []struct {
+		name      string
+		configure func(*config.LDAPConfig)
+		login     string
+		password  string
+		wantErr   error
+		anyErr    bool
+		want      *Entry
+	}{
+		{
+			name:     "username",
+			login:    "jane",
+			password: "jane-password",
+			want: &Entry{
+				DN:       "uid=jane,ou=people,dc=goma,dc=local",
+				Username: "jane",
+				Email:    "jane@goma.local",
+				Name:     "Jane Doe",
+				Groups:   []string{"cn=developers,ou=groups,dc=goma,dc=local", "developers"},
+			},
+		},
+		{
+			name:      "email with group search",
+			configure: func(conf *config.LDAPConfig) { conf.GroupBaseDN = "ou=groups," + testBaseDN },
+			login:     "JANE@goma.local",
+			password:  "jane-password",
+			want: &Entry{
+				DN:       "uid=jane,ou=people,dc=goma,dc=local",
+				Username: "jane",
+				Email:    "jane@goma.local",
+				Name:     "Jane Doe",
+				Groups: []string{
+					"cn=developers,ou=groups,dc=goma,dc=local", "developers",
+					"cn=admins,ou=groups,dc=goma,dc=local", "admins",
+				},
+			},
+		},
+		{name: "wrong password", login: "jane", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "empty password", login: "jane", password: "", wantErr: ErrInvalidCredentials},
+		{name: "unknown login", login: "nobody", password: "password", wantErr: ErrInvalidCredentials},
+		{name: "filter injection is escaped", login: "*", password: "jane-password", wantErr: ErrInvalidCredentials},
+		{name: "ambiguous login", login: "ops@goma.local", password: "password", anyErr: true},
+		{
+			name:      "service account rejected",
+			configure: func(conf *config.LDAPConfig) { conf.BindPassword = "wrong" },
+			login:     "jane",
+			password:  "jane-password",
+			anyErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := newTestClient(t, d, tt.configure).Authenticate(tt.login, tt.password)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+			case tt.anyErr:
+				if err == nil || errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("expected a directory error, got %v", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				assertEntry(t, entry, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d := newFakeDirectory(t)
+	client := newTestClient(t, d, nil)
+	tests := []struct {
+		name         string
+		dn           string
+		wantErr      error
+		wantDisabled bool
+	}{
+		{name: "enabled user", dn: "uid=jane,ou=people,dc=goma,dc=local"},
+		{name: "disabled Active Directory account", dn: "uid=bob,ou=people,dc=goma,dc=local", wantDisabled: true},
+		{name: "removed user", dn: "uid=gone,ou=people,dc=goma,dc=local", wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := client.Lookup(tt.dn)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entry.DN != tt.dn || entry.Disabled != tt.wantDisabled {
+				t.Errorf("unexpected entry: %+v", entry)
+			}
+		})
+	}
+}
+
+func TestGroupNames(t *testing.T) {
+	tests := []struct {
+		dn   string
+		want []string
+	}{
+		{dn: "cn=developers,ou=groups,dc=goma,dc=local", want: []string{"cn=developers,ou=groups,dc=goma,dc=local", "developers"}},
+		{dn: "ou=groups,dc=goma,dc=local", want: []string{"ou=groups,dc=goma,dc=local"}},
+		{dn: "developers", want: []string{"developers"}},
+	}
+	for _, tt := range tests {
+		if got := groupNames(tt.dn); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("groupNames(%q) = %v, want %v", tt.dn, got, tt.want)
+		}
+	}
+}
+
+func assertEntry(t *testing.T, got, want *Entry) {
+	t.Helper()
+	if got.DN != want.DN || got.Username != want.Username || got.Email != want.Email || got.Name != want.Name ||
+		got.Disabled != want.Disabled {
+		t.Errorf("unexpected entry: %+v, want %+v", got, want)
+	}
+	if strings.Join(got.Groups, "|") != strings.Join(want.Groups, "|") {
+		t.Errorf("unexpected groups: %v, want %v", got.Groups, want.Groups)
+	}
+}
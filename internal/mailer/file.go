@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jkaninda/logger"
+)
+
+// FileMailer writes each email as an .eml file, for development
+type FileMailer struct {
+	from      string
+	directory string
+}
+
+func NewFileMailer(from, directory string) *FileMailer {
+	return &FileMailer{from: from, directory: directory}
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	if err := os.MkdirAll(m.directory, 0o750); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	path := filepath.Join(m.directory, name)
+	if err := os.WriteFile(path, format(m.from, msg), 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	logger.Info("Email written to file", "to", msg.To, "subject", msg.Subject, "path", path)
+	return nil
+}
+
+// LogMailer prints the recipient and subject of emails to the application log, for development.
+// Bodies are never logged as they hold password reset links; use FileMailer to read them.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	logger.Info("Email not delivered, the log mail driver only records it", "to", msg.To, "subject", msg.Subject, "bodyLength", len(msg.Body))
+	return nil
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+
+	"github.com/jkaninda/goma-admin/internal/config"
+)
+
+// SMTPMailer delivers emails through an SMTP server, upgrading to TLS when the server supports STARTTLS
+type SMTPMailer struct {
+	from     string
+	addr     string
+	host     string
+	username string
+	password string
+}
+
+func NewSMTPMailer(conf *config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{
+		from:     conf.From,
+		addr:     net.JoinHostPort(conf.SMTPHost, strconv.Itoa(conf.SMTPPort)),
+		host:     conf.SMTPHost,
+		username: conf.SMTPUsername,
+		password: conf.SMTPPassword,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	sender, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, auth, sender.Address, []string{msg.To}, format(m.from, msg))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
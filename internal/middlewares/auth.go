@@ -1,16 +1,47 @@
 package middlewares
 
 import (
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/access"
 	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 )
 
 const (
 	authClaimsKey = "auth_claims"
+	// UserIDKey is the context key holding the authenticated user ID
+	UserIDKey = "user_id"
+	// EmailKey is the context key holding the authenticated user email
+	EmailKey = "email"
+	// RoleKey is the context key holding the authenticated user role
+	RoleKey = "role"
+	// SessionIDKey is the context key holding the session the access token belongs to
+	SessionIDKey = "session_id"
+	// APITokenIDKey is the context key holding the API token used to authenticate, if any
+	APITokenIDKey = "api_token_id"
+	// ScopesKey is the context key holding the scopes of the API token used to authenticate, if any
+	ScopesKey = "api_token_scopes"
+	// lastUsedResolution limits how often the last used timestamp of an API token is written
+	lastUsedResolution = time.Minute
 )
 
 type Auth struct {
-	JWT *okapi.JWTAuth
+	JWT            *okapi.JWTAuth
+	userRepo       *repository.UserRepository
+	apiTokenRepo   *repository.APITokenRepository
+	permissionRepo *repository.PermissionRepository
+	instanceRepo   *repository.InstanceRepository
+	middlewareRepo *repository.MiddlewareRepository
+	enrollmentRepo *repository.EnrollmentTokenRepository
+	access         *access.Checker
 }
 
 func NewAuth(conf *config.Config) *Auth {
@@ -19,12 +50,150 @@ func NewAuth(conf *config.Config) *Auth {
 		TokenLookup:   "header:Authorization",
 		Audience:      conf.JWT.Audience,
 		Issuer:        conf.JWT.Issuer,
+		ContextKey:    authClaimsKey,
 		ForwardClaims: map[string]string{
-			"user_id": "sub",
-			"email":   "email",
-			"role":    "role",
+			UserIDKey:    "sub",
+			EmailKey:     "email",
+			RoleKey:      "role",
+			SessionIDKey: "sid",
 		},
-		ClaimsExpression: "Equals(`role`, `admin`)",
 	}
-	return &Auth{JWT: jwtAuth}
+	return &Auth{
+		JWT:            jwtAuth,
+		userRepo:       repository.NewUserRepository(conf.Database.DB),
+		apiTokenRepo:   repository.NewAPITokenRepository(conf.Database.DB),
+		permissionRepo: repository.NewPermissionRepository(conf.Database.DB),
+		instanceRepo:   repository.NewInstanceRepository(conf.Database.DB),
+		middlewareRepo: repository.NewMiddlewareRepository(conf.Database.DB),
+		enrollmentRepo: repository.NewEnrollmentTokenRepository(conf.Database.DB),
+		access:         access.NewChecker(conf.Database.DB),
+	}
+}
+
+// Middleware authenticates the request with an API token, or with a JWT access token
+// whose session is still active.
+// Users who must change their password, or enroll MFA as required by their role, are rejected until they do so.
+func (a *Auth) Middleware(next okapi.HandlerFunc) okapi.HandlerFunc {
+	withSession := a.JWT.Middleware(a.requireSession(next, false))
+	return func(c *okapi.Context) error {
+		if token, ok := bearerAPIToken(c); ok {
+			return a.requireAPIToken(c, token, next)
+		}
+		return withSession(c)
+	}
+}
+
+// AllowAccountSetup authenticates the request with a JWT access token like Middleware, but also lets
+// through users who must change their password or enroll MFA, for the endpoints they need to do so.
+// API tokens are not accepted as account setup is interactive.
+func (a *Auth) AllowAccountSetup(next okapi.HandlerFunc) okapi.HandlerFunc {
+	return a.JWT.Middleware(a.requireSession(next, true))
+}
+
+// SessionOnly authenticates the request with a JWT access token like Middleware, rejecting API tokens whatever
+// their scopes: account endpoints manage the credentials, sessions and MFA of the user and are interactive.
+func (a *Auth) SessionOnly(next okapi.HandlerFunc) okapi.HandlerFunc {
+	withSession := a.JWT.Middleware(a.requireSession(next, false))
+	return func(c *okapi.Context) error {
+		if _, ok := bearerAPIToken(c); ok {
+			return c.AbortForbidden("API tokens cannot be used to manage the account")
+		}
+		return withSession(c)
+	}
+}
+
+// requireSession rejects tokens whose session has been revoked or has expired,
+// or that were superseded by a refresh of the same session
+func (a *Auth) requireSession(next okapi.HandlerFunc, allowAccountSetup bool) okapi.HandlerFunc {
+	return func(c *okapi.Context) error {
+		sessionID, err := uuid.Parse(c.GetString(SessionIDKey))
+		if err != nil {
+			return c.AbortUnauthorized("Invalid or expired token")
+		}
+		session, err := a.userRepo.GetSessionByID(c.Context(), sessionID)
+		if err != nil || !session.IsValid() || !session.User.Active {
+			return c.AbortUnauthorized("Session has been revoked or has expired")
+		}
+		if tokenID := accessTokenID(c); tokenID == "" || tokenID != session.Token {
+			return c.AbortUnauthorized("Invalid or expired token")
+		}
+		if !allowAccountSetup {
+			if err := a.requireAccountSetup(c, &session.User); err != nil {
+				return err
+			}
+		}
+		// Authorize with the current role rather than the one captured in the token
+		c.Set(RoleKey, session.User.Role)
+		return next(c)
+	}
+}
+
+// requireAccountSetup rejects users who must change their password, or enroll MFA as required by their role
+func (a *Auth) requireAccountSetup(c *okapi.Context, user *models.User) error {
+	if user.MustChangePassword {
+		return c.AbortForbidden("Password change required")
+	}
+	// Users of external identity providers are expected to use the MFA of their provider,
+	// and service accounts cannot enroll as they never sign in interactively
+	if !user.MFAEnabled && !user.HasExternalMFA() && !user.ServiceAccount {
+		required, err := a.permissionRepo.RequiresMFA(c.Context(), user.Role)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to check MFA policy", err)
+		}
+		if required {
+			return c.AbortForbidden("MFA enrollment required")
+		}
+	}
+	return nil
+}
+
+// accessTokenID returns the jti claim of the validated access token, empty when the claims are missing
+// or of an unexpected type so that the session binding fails closed
+func accessTokenID(c *okapi.Context) string {
+	claims, ok := c.Get(authClaimsKey)
+	if !ok {
+		return ""
+	}
+	mapClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	tokenID, _ := mapClaims["jti"].(string)
+	return tokenID
+}
+
+// requireAPIToken authenticates the request with an API token, on behalf of the token owner.
+// The owner is subject to the same account checks as users signed in with a session.
+func (a *Auth) requireAPIToken(c *okapi.Context, token string, next okapi.HandlerFunc) error {
+	apiToken, err := a.apiTokenRepo.GetByHash(c.Context(), util.HashToken(token))
+	if err != nil || !apiToken.IsValid() || !apiToken.User.Active {
+		return c.AbortUnauthorized("Invalid, revoked or expired API token")
+	}
+	if apiToken.User.IsLocked() {
+		return c.AbortLocked("Account is temporarily locked due to too many failed login attempts")
+	}
+	if err := a.requireAccountSetup(c, &apiToken.User); err != nil {
+		return err
+	}
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) > lastUsedResolution {
+		if err := a.apiTokenRepo.UpdateLastUsed(c.Context(), apiToken.ID, c.RealIP()); err != nil {
+			logger.Warn("Failed to update API token last use", "token", apiToken.ID, "error", err)
+		}
+	}
+
+	c.Set(UserIDKey, apiToken.UserID.String())
+	c.Set(EmailKey, apiToken.User.Email)
+	c.Set(RoleKey, apiToken.User.Role)
+	c.Set(APITokenIDKey, apiToken.ID.String())
+	c.Set(ScopesKey, []string(apiToken.Scopes))
+	return next(c)
+}
+
+// bearerAPIToken returns the API token sent in the Authorization header, if any
+func bearerAPIToken(c *okapi.Context) (string, bool) {
+	token, ok := strings.CutPrefix(c.Header("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, models.APITokenPrefix) {
+		return "", false
+	}
+	return token, true
 }
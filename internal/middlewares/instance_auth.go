@@ -0,0 +1,215 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
+	"github.com/jkaninda/okapi"
+)
+
+const (
+	// InstanceKey is the context key holding the gateway instance authenticated on the provider endpoints
+	InstanceKey = "instance"
+	// CredentialHeader identifies the credential a signed request is signed with
+	CredentialHeader = "X-Goma-Credential"
+	// TimestampHeader holds the Unix time a signed request was signed at
+	TimestampHeader = "X-Goma-Timestamp"
+	// NonceHeader holds a random value unique to a signed request, so that it cannot be replayed
+	NonceHeader = "X-Goma-Nonce"
+	// SignatureHeader holds the hex encoded HMAC-SHA256 signature of a signed request
+	SignatureHeader = "X-Goma-Signature"
+
+	// maxSignedBodySize bounds the body read to verify a signature
+	maxSignedBodySize = 1 << 20
+	minNonceLength    = 16
+	maxNonceLength    = 64
+)
+
+var errInvalidInstanceCredential = errors.New("invalid instance credential")
+
+// InstanceAuth authenticates gateway instances on the provider endpoints.
+// Instances send their credential as a bearer token, or sign their requests with it,
+// and may be pinned to a client certificate.
+type InstanceAuth struct {
+	instanceRepo     *repository.InstanceRepository
+	credentialRepo   *repository.InstanceCredentialRepository
+	maxSkew          time.Duration
+	clientCertHeader string
+}
+
+func NewInstanceAuth(conf *config.Config) *InstanceAuth {
+	return &InstanceAuth{
+		instanceRepo:     repository.NewInstanceRepository(conf.Database.DB),
+		credentialRepo:   repository.NewInstanceCredentialRepository(conf.Database.DB),
+		maxSkew:          conf.Provider.SignatureMaxSkew,
+		clientCertHeader: conf.Provider.ClientCertHeader,
+	}
+}
+
+// Middleware rejects requests whose credential does not belong to the instance named by the :name path parameter,
+// and requests of instances pending approval
+func (a *InstanceAuth) Middleware(next okapi.HandlerFunc) okapi.HandlerFunc {
+	return func(c *okapi.Context) error {
+		// Unknown instances get the same answer as wrong credentials, not to reveal instance names
+		instance, err := a.instanceRepo.GetByName(c.Context(), c.Param("name"))
+		if err != nil {
+			return c.AbortUnauthorized("Invalid instance credentials")
+		}
+		credential, err := a.authenticate(c, instance)
+		if err != nil {
+			logger.Warn("Rejected provider request", "instance", instance.Name, "ip", c.RealIP(), "error", err)
+			return c.AbortUnauthorized("Invalid instance credentials")
+		}
+		if instance.ClientCertFingerprint != "" && !a.clientCertMatches(c, instance.ClientCertFingerprint) {
+			logger.Warn("Rejected provider request with an unexpected client certificate", "instance", instance.Name, "ip", c.RealIP())
+			return c.AbortUnauthorized("Client certificate does not match the instance")
+		}
+		// Registered instances are only trusted once approved, the webhook included
+		if instance.Pending {
+			return c.AbortForbidden("Instance is pending approval")
+		}
+		if credential.LastUsedAt == nil || time.Since(*credential.LastUsedAt) > lastUsedResolution {
+			if err := a.credentialRepo.UpdateLastUsed(c.Context(), credential.ID, c.RealIP()); err != nil {
+				logger.Warn("Failed to update instance credential last use", "credential", credential.ID, "error", err)
+			}
+		}
+
+		c.Set(InstanceKey, instance)
+		return next(c)
+	}
+}
+
+// authenticate returns the valid credential of the instance the request carries, as a bearer token or a signature
+func (a *InstanceAuth) authenticate(c *okapi.Context, instance *models.Instance) (*models.InstanceCredential, error) {
+	var (
+		credential *models.InstanceCredential
+		err        error
+	)
+	if token, ok := strings.CutPrefix(c.Header("Authorization"), "Bearer "); ok {
+		credential, err = a.credentialRepo.GetByHash(c.Context(), util.HashToken(token))
+	} else if c.Header(SignatureHeader) != "" {
+		credential, err = a.verifySignature(c)
+	} else {
+		return nil, errors.New("missing instance credential")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.InstanceID != instance.ID || !credential.IsValid() {
+		return nil, errInvalidInstanceCredential
+	}
+	return credential, nil
+}
+
+// verifySignature checks the HMAC-SHA256 signature of the request, computed with the signing key of the credential
+// over the method, path, raw query, timestamp, nonce and body SHA-256, separated by new lines.
+// Each nonce is accepted once while the timestamp is within the allowed skew.
+func (a *InstanceAuth) verifySignature(c *okapi.Context) (*models.InstanceCredential, error) {
+	id, err := uuid.Parse(c.Header(CredentialHeader))
+	if err != nil {
+		return nil, errInvalidInstanceCredential
+	}
+	timestamp := c.Header(TimestampHeader)
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid signature timestamp")
+	}
+	if skew := time.Since(time.Unix(signedAt, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, errors.New("signature timestamp outside the allowed skew")
+	}
+	nonce := c.Header(NonceHeader)
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return nil, errors.New("invalid signature nonce")
+	}
+	signature, err := hex.DecodeString(c.Header(SignatureHeader))
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+	credential, err := a.credentialRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if credential.SigningKey == "" {
+		return nil, errors.New("credential has no signing key, it must be rotated to sign requests")
+	}
+
+	request := c.Request()
+	var body []byte
+	if request.Body != nil {
+		if body, err = io.ReadAll(http.MaxBytesReader(c.ResponseWriter(), request.Body, maxSignedBodySize)); err != nil {
+			return nil, err
+		}
+		// Let the handler read the body again
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(credential.SigningKey))
+	mac.Write([]byte(strings.Join([]string{
+		request.Method, request.URL.Path, request.URL.RawQuery, timestamp, nonce, hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, errors.New("signature mismatch")
+	}
+	// Nonces are only recorded for authentic requests, and expire with the timestamp they were signed with
+	fresh, err := a.credentialRepo.UseNonce(c.Context(), credential.ID, nonce, time.Unix(signedAt, 0).Add(a.maxSkew))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, errors.New("replayed signed request")
+	}
+	return credential, nil
+}
+
+// clientCertMatches checks the client certificate presented on the TLS connection, or forwarded by the proxy,
+// against the pinned fingerprint
+func (a *InstanceAuth) clientCertMatches(c *okapi.Context, fingerprint string) bool {
+	var cert *x509.Certificate
+	if a.clientCertHeader != "" {
+		value, err := url.QueryUnescape(c.Header(a.clientCertHeader))
+		if err != nil {
+			return false
+		}
+		if cert, err = ParseCertificate(value); err != nil {
+			return false
+		}
+	} else if state := c.Request().TLS; state != nil && len(state.PeerCertificates) > 0 {
+		cert = state.PeerCertificates[0]
+	} else {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CertificateFingerprint(cert)), []byte(fingerprint)) == 1
+}
+
+// ParseCertificate parses the first certificate of a PEM block
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 fingerprint of a certificate
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,206 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/okapi"
+)
+
+// Resource identifies a group of endpoints protected by the same permissions
+type Resource string
+
+const (
+	ResourceRoutes      Resource = "routes"
+	ResourceMiddlewares Resource = "middlewares"
+	ResourceInstances   Resource = "instances"
+	ResourceUsers       Resource = "users"
+)
+
+// Action is the kind of access an endpoint requires on a resource
+type Action string
+
+const (
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+	// ActionApprove lets instances that registered themselves receive configurations
+	ActionApprove Action = "approve"
+)
+
+// Permission is a resource and action pair declared on a route definition
+type Permission struct {
+	Resource Resource
+	Action   Action
+}
+
+// String returns the permission as an API token scope, such as "routes:write"
+func (p Permission) String() string {
+	return string(p.Resource) + ":" + string(p.Action)
+}
+
+// RequiredRole returns the minimum role allowed to use the permission
+func (p Permission) RequiredRole() models.UserRole {
+	return permissionRoles[p]
+}
+
+// ParseScope parses an API token scope such as "routes:write"
+func ParseScope(scope string) (Permission, error) {
+	resource, action, _ := strings.Cut(scope, ":")
+	permission := Permission{Resource: Resource(resource), Action: Action(action)}
+	if _, ok := permissionRoles[permission]; !ok {
+		return Permission{}, fmt.Errorf("invalid scope: %s", scope)
+	}
+	return permission, nil
+}
+
+// scopesAllow checks if API token scopes cover a permission. Write scopes also grant read access, other
+// actions must be granted explicitly.
+func scopesAllow(scopes []string, permission Permission) bool {
+	for _, scope := range scopes {
+		granted, err := ParseScope(scope)
+		if err != nil || granted.Resource != permission.Resource {
+			continue
+		}
+		if granted.Action == permission.Action || (granted.Action == ActionWrite && permission.Action == ActionRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionRoles maps each permission to the minimum role allowed to use it
+var permissionRoles = map[Permission]models.UserRole{
+	{ResourceRoutes, ActionRead}:       models.RoleViewer,
+	{ResourceRoutes, ActionWrite}:      models.RoleUser,
+	{ResourceMiddlewares, ActionRead}:  models.RoleViewer,
+	{ResourceMiddlewares, ActionWrite}: models.RoleUser,
+	{ResourceInstances, ActionRead}:    models.RoleViewer,
+	{ResourceInstances, ActionWrite}:   models.RoleUser,
+	{ResourceInstances, ActionApprove}: models.RoleAdmin,
+	{ResourceUsers, ActionRead}:        models.RoleSuperAdmin,
+	{ResourceUsers, ActionWrite}:       models.RoleSuperAdmin,
+}
+
+// Target is the kind of record identified by the :id path parameter of a write endpoint,
+// whose environment the permission grants are checked against
+type Target string
+
+const (
+	// TargetNone marks endpoints whose records are not bound to an environment, such as users, or that have
+	// no existing record, such as creations, whose handlers check the environment of the records they create
+	TargetNone            Target = "none"
+	TargetInstance        Target = "instance"
+	TargetRoute           Target = "route"
+	TargetMiddleware      Target = "middleware"
+	TargetEnrollmentToken Target = "enrollment_token"
+)
+
+// resourceTargets is the target of the write endpoints of each resource, unless declared with RequireOn
+var resourceTargets = map[Resource]Target{
+	ResourceRoutes:      TargetRoute,
+	ResourceMiddlewares: TargetMiddleware,
+	ResourceInstances:   TargetInstance,
+	ResourceUsers:       TargetNone,
+}
+
+// Require returns a middleware that authenticates the request and allows it only if the
+// user's role grants the permission. Write access to an instance, or to a route or
+// middleware deployed to instances, additionally requires a permission grant covering
+// the environment of those instances unless the user is an admin.
+// Requests made with an API token must also have the permission among the token scopes.
+func (a *Auth) Require(resource Resource, action Action) okapi.Middleware {
+	return a.RequireOn(resource, action, resourceTargets[resource])
+}
+
+// RequireOn is Require for write endpoints whose :id path parameter identifies a target other than
+// the default one of the resource, or that have none
+func (a *Auth) RequireOn(resource Resource, action Action, target Target) okapi.Middleware {
+	permission := Permission{Resource: resource, Action: action}
+	required, ok := permissionRoles[permission]
+	if !ok {
+		panic("middlewares: no role defined for permission " + string(resource) + ":" + string(action))
+	}
+	return func(next okapi.HandlerFunc) okapi.HandlerFunc {
+		return a.Middleware(a.authorize(next, permission, required, target))
+	}
+}
+
+// authorize allows authenticated requests to next if the role of the user, the API token scopes and, for
+// writes, the environment grants of the target allow the permission
+func (a *Auth) authorize(next okapi.HandlerFunc, permission Permission, required models.UserRole, target Target) okapi.HandlerFunc {
+	return func(c *okapi.Context) error {
+		role := models.UserRole(c.GetString(RoleKey))
+		if !role.CanAccess(required) {
+			return c.AbortForbidden("Insufficient permissions")
+		}
+		if scopes, ok := c.Get(ScopesKey); ok && !scopesAllow(scopes.([]string), permission) {
+			return c.AbortForbidden("API token scopes do not allow " + permission.String())
+		}
+		if permission.Action == ActionWrite {
+			allowed, err := a.canModify(c.Context(), role, target, c.Param("id"))
+			if errors.Is(err, repository.ErrNotFound) {
+				return c.AbortNotFound("Not found", err)
+			}
+			if err != nil {
+				return c.AbortInternalServerError("Failed to check permissions", err)
+			}
+			if !allowed {
+				return c.AbortForbidden("Changes to this environment require an admin")
+			}
+		}
+		return next(c)
+	}
+}
+
+// canModify checks the environment grants of the target identified by id. It fails closed: malformed
+// or missing identifiers are refused, and errors other than repository.ErrNotFound are returned.
+func (a *Auth) canModify(ctx context.Context, role models.UserRole, target Target, id string) (bool, error) {
+	switch target {
+	case TargetNone:
+		return true, nil
+	case TargetInstance:
+		instanceID, err := uuid.Parse(id)
+		if err != nil {
+			return false, nil
+		}
+		instance, err := a.instanceRepo.GetByID(ctx, instanceID)
+		if err != nil {
+			return false, err
+		}
+		return a.access.CanModifyInstance(ctx, role, instance)
+	case TargetRoute:
+		routeID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return false, nil
+		}
+		return a.access.CanModifyRoute(ctx, role, uint(routeID))
+	case TargetMiddleware:
+		middlewareID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return false, nil
+		}
+		middleware, err := a.middlewareRepo.GetByID(ctx, uint(middlewareID))
+		if err != nil {
+			return false, err
+		}
+		return a.access.CanModifyMiddleware(ctx, role, middleware.Name)
+	case TargetEnrollmentToken:
+		tokenID, err := uuid.Parse(id)
+		if err != nil {
+			return false, nil
+		}
+		token, err := a.enrollmentRepo.GetByID(ctx, tokenID)
+		if err != nil {
+			return false, err
+		}
+		// Instances registered with the token belong to its environment
+		return a.access.CanModifyInstance(ctx, role, &models.Instance{Environment: token.Environment})
+	}
+	return false, fmt.Errorf("unknown permission target: %s", target)
+}
@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/okapi"
+)
+
+func TestAuthorizeInstanceApproval(t *testing.T) {
+	approve := Permission{Resource: ResourceInstances, Action: ActionApprove}
+	tests := []struct {
+		name   string
+		role   models.UserRole
+		scopes []string
+		want   int
+	}{
+		{name: "viewer", role: models.RoleViewer, want: http.StatusForbidden},
+		{name: "user", role: models.RoleUser, want: http.StatusForbidden},
+		{name: "admin", role: models.RoleAdmin, want: http.StatusOK},
+		{name: "superadmin", role: models.RoleSuperAdmin, want: http.StatusOK},
+		{name: "admin token with write scope", role: models.RoleAdmin, scopes: []string{"instances:write"}, want: http.StatusForbidden},
+		{name: "admin token with approve scope", role: models.RoleAdmin, scopes: []string{"instances:approve"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := okapi.NewTestContext(http.MethodPost, "/api/v1/instances/1/approve", nil)
+			c.Set(RoleKey, string(tt.role))
+			if tt.scopes != nil {
+				c.Set(ScopesKey, tt.scopes)
+			}
+			next := func(c *okapi.Context) error {
+				return c.OK(okapi.M{"status": "ok"})
+			}
+
+			_ = (&Auth{}).authorize(next, approve, approve.RequiredRole(), TargetInstance)(c)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+)
+
+// Snapshot is a rendered configuration along with the content hashes of the views served from it
+type Snapshot struct {
+	Config *Config
+	// Version is the content hash of the whole configuration
+	Version string
+	// RoutesVersion and MiddlewaresVersion are the content hashes of the routes and middlewares views
+	RoutesVersion      string
+	MiddlewaresVersion string
+}
+
+// Cache keeps the rendered configuration of each instance until a change affecting it is committed
+type Cache struct {
+	renderer *Renderer
+
+	mu sync.Mutex
+	// generation is incremented on every invalidation,
+	// so that a configuration rendered before a change is never stored after it
+	generation uint64
+	snapshots  map[uuid.UUID]*Snapshot
+}
+
+// NewCache creates an empty cache, Invalidate must be called with every configuration change
+func NewCache(renderer *Renderer) *Cache {
+	return &Cache{
+		renderer:  renderer,
+		snapshots: make(map[uuid.UUID]*Snapshot),
+	}
+}
+
+// Get returns the cached configuration of the instance, rendering it on a miss.
+// The generation is read before the renderer loads anything, so that a change committed while rendering,
+// and invalidated meanwhile, keeps the outdated configuration out of the cache.
+func (c *Cache) Get(ctx context.Context, instance *models.Instance) (*Snapshot, error) {
+	c.mu.Lock()
+	snapshot, ok := c.snapshots[instance.ID]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return snapshot, nil
+	}
+
+	snapshot, err := c.renderer.Snapshot(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.snapshots[instance.ID] = snapshot
+	}
+	c.mu.Unlock()
+	return snapshot, nil
+}
+
+// Invalidate drops the configuration of the instance affected by the change, or of every instance
+// when the change is not specific to one
+func (c *Cache) Invalidate(change repository.ConfigChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if change.InstanceID != nil {
+		delete(c.snapshots, *change.InstanceID)
+		return
+	}
+	clear(c.snapshots)
+}
+
+// Snapshot renders the configuration of the instance along with its content hashes, without caching it
+func (r *Renderer) Snapshot(ctx context.Context, instance *models.Instance) (*Snapshot, error) {
+	config, err := r.Render(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	version, err := contentHash(config)
+	if err != nil {
+		return nil, err
+	}
+	routesVersion, err := contentHash(config.Routes)
+	if err != nil {
+		return nil, err
+	}
+	middlewaresVersion, err := contentHash(config.Middlewares)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Config:             config,
+		Version:            version,
+		RoutesVersion:      routesVersion,
+		MiddlewaresVersion: middlewaresVersion,
+	}, nil
+}
+
+// contentHash returns the SHA-256 of the JSON encoding of v, which is stable as struct fields are encoded
+// in declaration order and map keys sorted
+func contentHash(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
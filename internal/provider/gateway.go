@@ -0,0 +1,142 @@
+package provider
+
+import "github.com/jkaninda/goma-admin/internal/db/models"
+
+// Route is a route in the Goma Gateway schema. Gateway types are mapped field by field from the models,
+// so that admin-only fields, such as ids, never reach the instances.
+type Route struct {
+	Name           string       `json:"name" yaml:"name"`
+	Path           string       `json:"path" yaml:"path"`
+	Rewrite        *string      `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	Priority       int          `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Enabled        bool         `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Methods        []string     `json:"methods,omitempty" yaml:"methods,omitempty"`
+	Hosts          []string     `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Target         *string      `json:"target,omitempty" yaml:"target,omitempty"`
+	DisableMetrics bool         `json:"disableMetrics,omitempty" yaml:"disableMetrics,omitempty"`
+	Backends       []Backend    `json:"backends,omitempty" yaml:"backends,omitempty"`
+	Maintenance    *Maintenance `json:"maintenance,omitempty" yaml:"maintenance,omitempty"`
+	TLS            *TLS         `json:"tls,omitempty" yaml:"tls,omitempty"`
+	HealthCheck    *HealthCheck `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	Security       *Security    `json:"security,omitempty" yaml:"security,omitempty"`
+	Middlewares    []string     `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+}
+
+type Backend struct {
+	Endpoint  string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Weight    int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Exclusive bool   `json:"exclusive,omitempty" yaml:"exclusive,omitempty"`
+}
+
+type Maintenance struct {
+	Enabled    bool   `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	Message    string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+type TLS struct {
+	Certificates []Certificate `json:"certificates,omitempty" yaml:"certificates,omitempty"`
+}
+
+type Certificate struct {
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+}
+
+type HealthCheck struct {
+	Path            *string `json:"path,omitempty" yaml:"path,omitempty"`
+	Interval        *string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Timeout         *string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	HealthyStatuses []int   `json:"healthyStatuses,omitempty" yaml:"healthyStatuses,omitempty"`
+}
+
+type Security struct {
+	ForwardHostHeaders      bool         `json:"forwardHostHeaders" yaml:"forwardHostHeaders"`
+	EnableExploitProtection bool         `json:"enableExploitProtection" yaml:"enableExploitProtection"`
+	TLS                     *SecurityTLS `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+type SecurityTLS struct {
+	InsecureSkipVerify bool    `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+	RootCAs            *string `json:"rootCAs,omitempty" yaml:"rootCAs,omitempty"`
+	ClientCert         *string `json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+	ClientKey          *string `json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+}
+
+// Middleware is a middleware in the Goma Gateway schema
+type Middleware struct {
+	Name  string         `json:"name" yaml:"name"`
+	Type  string         `json:"type" yaml:"type"`
+	Paths []string       `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Rule  map[string]any `json:"rule,omitempty" yaml:"rule,omitempty"`
+}
+
+func newRoute(route *models.Route) Route {
+	gatewayRoute := Route{
+		Name:           route.Name,
+		Path:           route.Path,
+		Rewrite:        route.Rewrite,
+		Priority:       route.Priority,
+		Enabled:        route.Enabled,
+		Methods:        route.Methods,
+		Hosts:          route.Hosts,
+		Target:         route.Target,
+		DisableMetrics: route.DisableMetrics,
+		Middlewares:    route.Middlewares,
+	}
+	for _, backend := range route.Backends {
+		gatewayRoute.Backends = append(gatewayRoute.Backends, Backend{
+			Endpoint:  backend.Endpoint,
+			Weight:    backend.Weight,
+			Exclusive: backend.Exclusive,
+		})
+	}
+	if route.Maintenance != nil {
+		gatewayRoute.Maintenance = &Maintenance{
+			Enabled:    route.Maintenance.Enabled,
+			StatusCode: route.Maintenance.StatusCode,
+			Message:    route.Maintenance.Message,
+		}
+	}
+	if route.TLS != nil && len(route.TLS.Certificates) > 0 {
+		gatewayRoute.TLS = &TLS{}
+		for _, certificate := range route.TLS.Certificates {
+			gatewayRoute.TLS.Certificates = append(gatewayRoute.TLS.Certificates, Certificate{
+				Cert: certificate.Cert,
+				Key:  certificate.Key,
+			})
+		}
+	}
+	if route.HealthCheck != nil {
+		gatewayRoute.HealthCheck = &HealthCheck{
+			Path:            route.HealthCheck.Path,
+			Interval:        route.HealthCheck.Interval,
+			Timeout:         route.HealthCheck.Timeout,
+			HealthyStatuses: route.HealthCheck.HealthyStatuses,
+		}
+	}
+	if route.Security != nil {
+		gatewayRoute.Security = &Security{
+			ForwardHostHeaders:      route.Security.ForwardHostHeaders,
+			EnableExploitProtection: route.Security.EnableExploitProtection,
+		}
+		if tls := route.Security.TLS; tls != nil {
+			gatewayRoute.Security.TLS = &SecurityTLS{
+				InsecureSkipVerify: tls.InsecureSkipVerify,
+				RootCAs:            tls.RootCAs,
+				ClientCert:         tls.ClientCert,
+				ClientKey:          tls.ClientKey,
+			}
+		}
+	}
+	return gatewayRoute
+}
+
+func newMiddleware(middleware *models.Middleware) Middleware {
+	return Middleware{
+		Name:  middleware.Name,
+		Type:  middleware.Type,
+		Paths: middleware.Paths,
+		Rule:  middleware.Rule,
+	}
+}
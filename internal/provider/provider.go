@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/rules"
+	"github.com/jkaninda/goma-admin/internal/secrets"
+	"github.com/jkaninda/logger"
+	"gorm.io/gorm"
+)
+
+// Config is the configuration served to a gateway instance, in the Goma Gateway HTTP provider schema
+type Config struct {
+	Routes      []Route      `json:"routes" yaml:"routes"`
+	Middlewares []Middleware `json:"middlewares" yaml:"middlewares"`
+}
+
+// Renderer builds the configuration of gateway instances from the routes attached to them
+type Renderer struct {
+	instanceRepo   *repository.InstanceRepository
+	middlewareRepo *repository.MiddlewareRepository
+	cipher         *secrets.Cipher
+}
+
+// NewRenderer creates a renderer decrypting the secrets of middleware rules with cipher
+func NewRenderer(db *gorm.DB, cipher *secrets.Cipher) *Renderer {
+	return &Renderer{
+		instanceRepo:   repository.NewInstanceRepository(db),
+		middlewareRepo: repository.NewMiddlewareRepository(db),
+		cipher:         cipher,
+	}
+}
+
+// Render returns the enabled routes attached to the instance, with the instance overrides applied and ordered
+// by their effective priority, along with the middlewares those routes reference, their secrets decrypted.
+// The overrides are loaded along with the routes, those the instance was loaded with may be outdated.
+func (r *Renderer) Render(ctx context.Context, instance *models.Instance) (*Config, error) {
+	instanceRoutes, err := r.instanceRepo.GetInstanceRoutes(ctx, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := r.instanceRepo.GetRoutesByInstance(ctx, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[uint]*models.InstanceRoute, len(instanceRoutes))
+	for i := range instanceRoutes {
+		overrides[instanceRoutes[i].RouteID] = &instanceRoutes[i]
+	}
+
+	config := &Config{
+		Routes:      make([]Route, 0, len(routes)),
+		Middlewares: []Middleware{},
+	}
+	var names []string
+	referenced := make(map[string]bool)
+	for _, route := range routes {
+		override, ok := overrides[route.ID]
+		if !ok || !override.Enabled || !route.Enabled {
+			continue
+		}
+		if override.Priority != nil {
+			route.Priority = *override.Priority
+		}
+		for _, name := range route.Middlewares {
+			if !referenced[name] {
+				referenced[name] = true
+				names = append(names, name)
+			}
+		}
+		config.Routes = append(config.Routes, newRoute(&route))
+	}
+	// Overrides can change the order returned by the database
+	sort.SliceStable(config.Routes, func(i, j int) bool {
+		if config.Routes[i].Priority != config.Routes[j].Priority {
+			return config.Routes[i].Priority > config.Routes[j].Priority
+		}
+		return config.Routes[i].Name < config.Routes[j].Name
+	})
+
+	middlewares, err := r.middlewareRepo.GetByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(middlewares) < len(names) {
+		logger.Warn("Routes reference unknown middlewares", "instance", instance.Name,
+			"referenced", len(names), "found", len(middlewares))
+	}
+	sort.Slice(middlewares, func(i, j int) bool { return middlewares[i].Name < middlewares[j].Name })
+	for i := range middlewares {
+		rule, err := rules.DecryptSecrets(ctx, r.cipher, middlewares[i].Type, middlewares[i].Rule)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", middlewares[i].Name, err)
+		}
+		middlewares[i].Rule = rule
+		config.Middlewares = append(config.Middlewares, newMiddleware(&middlewares[i]))
+	}
+
+	return config, nil
+}
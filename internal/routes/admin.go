@@ -3,25 +3,153 @@ package routes
 import (
 	"net/http"
 
+	"github.com/jkaninda/goma-admin/internal/middlewares"
 	"github.com/jkaninda/okapi"
 )
 
 func (r *Router) adminRoutes() []okapi.RouteDefinition {
 	group := r.group.Group("/admin").WithTags([]string{"adminService"})
-	group.Use(r.auth.JWT.Middleware)
 
 	return []okapi.RouteDefinition{
 		{
-			Path:    "/users",
-			Method:  http.MethodGet,
-			Handler: adminService.ListUsers,
-			Group:   group,
+			Path:        "/users",
+			Method:      http.MethodGet,
+			Handler:     adminService.ListUsers,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
 		},
 		{
-			Path:    "/users/:id",
-			Method:  http.MethodGet,
-			Handler: adminService.GetUser,
-			Group:   group,
+			Path:        "/users",
+			Method:      http.MethodPost,
+			Handler:     adminService.CreateUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/stats",
+			Method:      http.MethodGet,
+			Handler:     adminService.UserStats,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/users/:id",
+			Method:      http.MethodGet,
+			Handler:     adminService.GetUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/users/:id",
+			Method:      http.MethodPut,
+			Handler:     adminService.UpdateUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/:id",
+			Method:      http.MethodDelete,
+			Handler:     adminService.DeleteUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/:id/deactivate",
+			Method:      http.MethodPost,
+			Handler:     adminService.DeactivateUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/:id/reset-password",
+			Method:      http.MethodPost,
+			Handler:     adminService.ResetPassword,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/:id/unlock",
+			Method:      http.MethodPost,
+			Handler:     adminService.UnlockUser,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/users/:id/mfa",
+			Method:      http.MethodDelete,
+			Handler:     adminService.ResetUserMFA,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/role-policies",
+			Method:      http.MethodGet,
+			Handler:     adminService.ListRolePolicies,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/role-policies/:role",
+			Method:      http.MethodPut,
+			Handler:     adminService.UpdateRolePolicy,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/permissions",
+			Method:      http.MethodGet,
+			Handler:     adminService.ListPermissionGrants,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/permissions",
+			Method:      http.MethodPost,
+			Handler:     adminService.CreatePermissionGrant,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/permissions/:id",
+			Method:      http.MethodDelete,
+			Handler:     adminService.DeletePermissionGrant,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/service-accounts",
+			Method:      http.MethodGet,
+			Handler:     tokenService.ListServiceAccounts,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/service-accounts",
+			Method:      http.MethodPost,
+			Handler:     tokenService.CreateServiceAccount,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/service-accounts/:id/tokens",
+			Method:      http.MethodPost,
+			Handler:     tokenService.CreateServiceAccountToken,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/tokens",
+			Method:      http.MethodGet,
+			Handler:     tokenService.ListTokens,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/tokens/:id",
+			Method:      http.MethodDelete,
+			Handler:     tokenService.RevokeToken,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceUsers, middlewares.ActionWrite)},
 		},
 	}
 }
@@ -1,12 +1,160 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/jkaninda/okapi"
 )
 
 func (r *Router) authRoutes() []okapi.RouteDefinition {
 	group := r.group.Group("/auth").WithTags([]string{"authService"})
-	group.Use(r.auth.JWT.Middleware)
 
-	return []okapi.RouteDefinition{}
+	return []okapi.RouteDefinition{
+		{
+			Path:    "/login",
+			Method:  http.MethodPost,
+			Handler: authService.Login,
+			Group:   group,
+		},
+		{
+			Path:    "/providers",
+			Method:  http.MethodGet,
+			Handler: authService.LoginProviders,
+			Group:   group,
+		},
+		{
+			Path:    "/oidc/login",
+			Method:  http.MethodGet,
+			Handler: authService.OIDCLogin,
+			Group:   group,
+		},
+		{
+			Path:    "/oidc/callback",
+			Method:  http.MethodGet,
+			Handler: authService.OIDCCallback,
+			Group:   group,
+		},
+		{
+			Path:    "/refresh",
+			Method:  http.MethodPost,
+			Handler: authService.Refresh,
+			Group:   group,
+		},
+		{
+			Path:    "/mfa/verify",
+			Method:  http.MethodPost,
+			Handler: authService.VerifyMFA,
+			Group:   group,
+		},
+		{
+			Path:    "/forgot-password",
+			Method:  http.MethodPost,
+			Handler: authService.ForgotPassword,
+			Group:   group,
+		},
+		{
+			Path:    "/reset-password",
+			Method:  http.MethodPost,
+			Handler: authService.ResetPassword,
+			Group:   group,
+		},
+		{
+			Path:        "/logout",
+			Method:      http.MethodPost,
+			Handler:     authService.Logout,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.AllowAccountSetup},
+		},
+		{
+			Path:        "/me",
+			Method:      http.MethodGet,
+			Handler:     authService.Me,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.AllowAccountSetup},
+		},
+		{
+			Path:        "/me",
+			Method:      http.MethodPatch,
+			Handler:     authService.UpdateMe,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/me/password",
+			Method:      http.MethodPost,
+			Handler:     authService.ChangePassword,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.AllowAccountSetup},
+		},
+		{
+			Path:        "/sessions",
+			Method:      http.MethodGet,
+			Handler:     authService.ListSessions,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/sessions",
+			Method:      http.MethodDelete,
+			Handler:     authService.RevokeAllSessions,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/sessions/:id",
+			Method:      http.MethodDelete,
+			Handler:     authService.RevokeSession,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/mfa/enroll",
+			Method:      http.MethodPost,
+			Handler:     authService.EnrollMFA,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.AllowAccountSetup},
+		},
+		{
+			Path:        "/mfa/enable",
+			Method:      http.MethodPost,
+			Handler:     authService.EnableMFA,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.AllowAccountSetup},
+		},
+		{
+			Path:        "/mfa/disable",
+			Method:      http.MethodPost,
+			Handler:     authService.DisableMFA,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/mfa/recovery-codes",
+			Method:      http.MethodPost,
+			Handler:     authService.RegenerateRecoveryCodes,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/tokens",
+			Method:      http.MethodGet,
+			Handler:     tokenService.ListMyTokens,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/tokens",
+			Method:      http.MethodPost,
+			Handler:     tokenService.CreateMyToken,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+		{
+			Path:        "/tokens/:id",
+			Method:      http.MethodDelete,
+			Handler:     tokenService.RevokeMyToken,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.SessionOnly},
+		},
+	}
 }
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	"github.com/jkaninda/okapi"
+)
+
+func (r *Router) instanceRoutes() []okapi.RouteDefinition {
+	group := r.group.Group("/instances").WithTags([]string{"instanceService"})
+	enrollment := r.group.Group("/enrollment-tokens").WithTags([]string{"instanceService"})
+	read := []okapi.Middleware{r.auth.Require(middlewares.ResourceInstances, middlewares.ActionRead)}
+	write := []okapi.Middleware{r.auth.Require(middlewares.ResourceInstances, middlewares.ActionWrite)}
+	approve := []okapi.Middleware{r.auth.Require(middlewares.ResourceInstances, middlewares.ActionApprove)}
+	// Creations and enrollment tokens are checked by the handlers against the environment they target
+	create := []okapi.Middleware{r.auth.RequireOn(middlewares.ResourceInstances, middlewares.ActionWrite, middlewares.TargetNone)}
+	writeToken := []okapi.Middleware{r.auth.RequireOn(middlewares.ResourceInstances, middlewares.ActionWrite, middlewares.TargetEnrollmentToken)}
+
+	return []okapi.RouteDefinition{
+		{
+			Path:        "",
+			Method:      http.MethodGet,
+			Handler:     instanceService.List,
+			Group:       group,
+			Middlewares: read,
+		},
+		{
+			Path:        "",
+			Method:      http.MethodPost,
+			Handler:     instanceService.Create,
+			Group:       group,
+			Middlewares: create,
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodGet,
+			Handler:     instanceService.Get,
+			Group:       group,
+			Middlewares: read,
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodPut,
+			Handler:     instanceService.Update,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodDelete,
+			Handler:     instanceService.Delete,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/approve",
+			Method:      http.MethodPost,
+			Handler:     instanceService.Approve,
+			Group:       group,
+			Middlewares: approve,
+		},
+		{
+			Path:        "/:id/routes",
+			Method:      http.MethodGet,
+			Handler:     instanceService.Routes,
+			Group:       group,
+			Middlewares: read,
+		},
+		{
+			Path:        "/:id/routes",
+			Method:      http.MethodPost,
+			Handler:     instanceService.AttachRoute,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/routes/:routeId",
+			Method:      http.MethodDelete,
+			Handler:     instanceService.DetachRoute,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/reports",
+			Method:      http.MethodGet,
+			Handler:     instanceService.Reports,
+			Group:       group,
+			Middlewares: read,
+		},
+		{
+			Path:        "/:id/drift",
+			Method:      http.MethodGet,
+			Handler:     instanceService.Drift,
+			Group:       group,
+			Middlewares: read,
+		},
+		{
+			Path:        "/:id/credentials",
+			Method:      http.MethodGet,
+			Handler:     instanceService.Credentials,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/credentials",
+			Method:      http.MethodPost,
+			Handler:     instanceService.RotateCredential,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/credentials/:credentialId",
+			Method:      http.MethodDelete,
+			Handler:     instanceService.RevokeCredential,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/client-certificate",
+			Method:      http.MethodPut,
+			Handler:     instanceService.PinClientCertificate,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/client-certificate",
+			Method:      http.MethodDelete,
+			Handler:     instanceService.UnpinClientCertificate,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "/:id/deploy",
+			Method:      http.MethodPost,
+			Handler:     instanceService.Deploy,
+			Group:       group,
+			Middlewares: write,
+		},
+		{
+			Path:        "",
+			Method:      http.MethodGet,
+			Handler:     instanceService.EnrollmentTokens,
+			Group:       enrollment,
+			Middlewares: create,
+		},
+		{
+			Path:        "",
+			Method:      http.MethodPost,
+			Handler:     instanceService.CreateEnrollmentToken,
+			Group:       enrollment,
+			Middlewares: create,
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodDelete,
+			Handler:     instanceService.RevokeEnrollmentToken,
+			Group:       enrollment,
+			Middlewares: writeToken,
+		},
+	}
+}
@@ -5,37 +5,53 @@ import (
 	"net/http"
 
 	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/events"
 	"github.com/jkaninda/goma-admin/internal/middlewares"
 	"github.com/jkaninda/goma-admin/internal/services"
+	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 )
 
 type Router struct {
-	app    *okapi.Okapi
-	config *config.Config
-	cxt    context.Context
-	group  *okapi.Group
-	auth   *middlewares.Auth
+	app          *okapi.Okapi
+	config       *config.Config
+	cxt          context.Context
+	group        *okapi.Group
+	auth         *middlewares.Auth
+	instanceAuth *middlewares.InstanceAuth
 }
 
 var (
 	commonService     = &services.CommonService{}
-	routeService      = &services.RouteService{}
-	providerService   = &services.ProviderService{}
-	middlewareService = &services.MiddlewareService{}
+	routeService      *services.RouteService
+	middlewareService *services.MiddlewareService
 	authService       *services.AuthService
 	adminService      *services.AdminService
+	instanceService   *services.InstanceService
+	tokenService      *services.TokenService
+	providerService   *services.ProviderService
 )
 
 func NewRouter(ctx context.Context, app *okapi.Okapi, conf *config.Config) *Router {
 	authService = services.NewAuthService(conf)
 	adminService = services.NewAdminService(conf)
+	instanceService = services.NewInstanceService(conf)
+	routeService = services.NewRouteService(conf)
+	middlewareService = services.NewMiddlewareService(conf)
+	tokenService = services.NewTokenService(conf)
+	bus, err := events.NewBus(&conf.Redis)
+	if err != nil {
+		logger.Fatal("Failed to create the configuration change bus", "error", err)
+	}
+	bus.Start(ctx)
+	providerService = services.NewProviderService(conf, bus)
 	return &Router{
-		app:    app,
-		config: conf,
-		cxt:    ctx,
-		group:  &okapi.Group{Prefix: "api/v1"},
-		auth:   middlewares.NewAuth(conf),
+		app:          app,
+		config:       conf,
+		cxt:          ctx,
+		group:        &okapi.Group{Prefix: "api/v1"},
+		auth:         middlewares.NewAuth(conf),
+		instanceAuth: middlewares.NewInstanceAuth(conf),
 	}
 }
 func (r *Router) RegisterRoutes() {
@@ -44,6 +60,7 @@ func (r *Router) RegisterRoutes() {
 	r.app.Register(r.routes()...)
 	r.app.Register(r.providerRoutes()...)
 	r.app.Register(r.routeMiddlewares()...)
+	r.app.Register(r.instanceRoutes()...)
 	r.app.Register(r.authRoutes()...)
 	r.app.Register(r.adminRoutes()...)
 }
@@ -67,101 +84,164 @@ func (r *Router) Version() okapi.RouteDefinition {
 
 func (r *Router) routes() []okapi.RouteDefinition {
 	group := r.group.Group("/routes").WithTags([]string{"routeService"})
-	group.Use(r.auth.JWT.Middleware)
 	return []okapi.RouteDefinition{
 		{
-			Path:    "",
-			Method:  http.MethodGet,
-			Handler: routeService.List,
-			Group:   group,
+			Path:        "",
+			Method:      http.MethodGet,
+			Handler:     routeService.List,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceRoutes, middlewares.ActionRead)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodPost,
-			Handler: routeService.Create,
-			Group:   group,
+			Path:        "",
+			Method:      http.MethodPost,
+			Handler:     routeService.Create,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.RequireOn(middlewares.ResourceRoutes, middlewares.ActionWrite, middlewares.TargetNone)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodGet,
-			Handler: routeService.Get,
-			Group:   group,
+			Path:        "/:id",
+			Method:      http.MethodGet,
+			Handler:     routeService.Get,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceRoutes, middlewares.ActionRead)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodPut,
-			Handler: routeService.Update,
-			Group:   group,
+			Path:        "/:id",
+			Method:      http.MethodPut,
+			Handler:     routeService.Update,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceRoutes, middlewares.ActionWrite)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodDelete,
-			Handler: routeService.Delete,
-			Group:   group,
+			Path:        "/:id",
+			Method:      http.MethodDelete,
+			Handler:     routeService.Delete,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceRoutes, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/:id/middlewares",
+			Method:      http.MethodPut,
+			Handler:     routeService.ReorderMiddlewares,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceRoutes, middlewares.ActionWrite)},
 		},
 	}
 }
 func (r *Router) routeMiddlewares() []okapi.RouteDefinition {
 	group := r.group.Group("/middlewares").WithTags([]string{"middlewareService"})
-	group.Use(r.auth.JWT.Middleware)
 	return []okapi.RouteDefinition{
 		{
-			Path:    "",
-			Method:  http.MethodGet,
-			Handler: middlewareService.List,
-			Group:   group,
+			Path:        "",
+			Method:      http.MethodGet,
+			Handler:     middlewareService.List,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionRead)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodPost,
-			Handler: middlewareService.Create,
-			Group:   group,
+			Path:        "",
+			Method:      http.MethodPost,
+			Handler:     middlewareService.Create,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.RequireOn(middlewares.ResourceMiddlewares, middlewares.ActionWrite, middlewares.TargetNone)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodGet,
-			Handler: middlewareService.Get,
-			Group:   group,
+			Path:        "/batch",
+			Method:      http.MethodPost,
+			Handler:     middlewareService.CreateBatch,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.RequireOn(middlewares.ResourceMiddlewares, middlewares.ActionWrite, middlewares.TargetNone)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodPut,
-			Handler: middlewareService.Update,
-			Group:   group,
+			Path:        "/stats",
+			Method:      http.MethodGet,
+			Handler:     middlewareService.Stats,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionRead)},
 		},
 		{
-			Path:    "/:id",
-			Method:  http.MethodDelete,
-			Handler: middlewareService.Delete,
-			Group:   group,
+			Path:        "/types",
+			Method:      http.MethodGet,
+			Handler:     middlewareService.Types,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodGet,
+			Handler:     middlewareService.Get,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionRead)},
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodPut,
+			Handler:     middlewareService.Update,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/:id",
+			Method:      http.MethodDelete,
+			Handler:     middlewareService.Delete,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionWrite)},
+		},
+		{
+			Path:        "/:id/routes",
+			Method:      http.MethodGet,
+			Handler:     middlewareService.Routes,
+			Group:       group,
+			Middlewares: []okapi.Middleware{r.auth.Require(middlewares.ResourceMiddlewares, middlewares.ActionRead)},
 		},
 	}
 }
 func (r *Router) providerRoutes() []okapi.RouteDefinition {
+	// Gateway instances authenticate with their own credentials, not as users
 	group := r.group.Group("/provider").WithTags([]string{"providerService"})
+	instanceAuth := []okapi.Middleware{r.instanceAuth.Middleware}
 	return []okapi.RouteDefinition{
 		{
-			Path:    "/:name",
-			Method:  http.MethodGet,
-			Handler: providerService.Provider,
-			Group:   group,
+			Path:        "/:name",
+			Method:      http.MethodGet,
+			Handler:     providerService.Provider,
+			Group:       group,
+			Middlewares: instanceAuth,
 		},
 		{
-			Path:    "/:name/middlewares",
-			Method:  http.MethodGet,
-			Handler: providerService.Middlewares,
-			Group:   group,
+			Path:        "/:name/middlewares",
+			Method:      http.MethodGet,
+			Handler:     providerService.Middlewares,
+			Group:       group,
+			Middlewares: instanceAuth,
 		},
 		{
-			Path:    "/:name/routes",
-			Method:  http.MethodGet,
-			Handler: providerService.Routes,
-			Group:   group,
+			Path:        "/:name/routes",
+			Method:      http.MethodGet,
+			Handler:     providerService.Routes,
+			Group:       group,
+			Middlewares: instanceAuth,
+		},
+		{
+			Path:        "/:name/watch",
+			Method:      http.MethodGet,
+			Handler:     providerService.Watch,
+			Group:       group,
+			Middlewares: instanceAuth,
+		},
+		{
+			Path:        "/:name/webhook",
+			Method:      http.MethodPost,
+			Handler:     providerService.Webhook,
+			Group:       group,
+			Middlewares: instanceAuth,
 		},
 		{
-			Path:    "/:name/webhook",
+			// Unknown instances authenticate with an enrollment token instead
+			Path:    "/:name/register",
 			Method:  http.MethodPost,
-			Handler: providerService.Webhook,
+			Handler: providerService.Register,
 			Group:   group,
 		},
 	}
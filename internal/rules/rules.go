@@ -0,0 +1,162 @@
+// Package rules describes the middleware types of Goma Gateway, the rules they accept as JSON Schemas,
+// and validates the rules of middlewares against their type
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownType is returned when validating the rule of a middleware type that does not exist
+var ErrUnknownType = errors.New("unknown middleware type")
+
+// FieldError is an invalid field of a rule, named after its JSON path
+type FieldError struct {
+	Field   string
+	Message string
+	Value   any
+}
+
+// Type is a middleware type along with the JSON Schema of its rule
+type Type struct {
+	Name string `json:"name"`
+	// Aliases are other names Goma Gateway accepts for the type
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description"`
+	Schema      *Schema  `json:"schema"`
+
+	rule reflect.Type
+	// secrets are the names of the secret fields of the rule
+	secrets []string
+}
+
+// validator is implemented by rules with constraints that cannot be expressed with struct tags
+type validator interface {
+	validate(errs *fieldErrors)
+}
+
+var (
+	types  []*Type
+	byName = make(map[string]*Type)
+)
+
+// register adds a middleware type whose rule is decoded into values of the type of rule
+func register(name string, aliases []string, description string, rule any) {
+	t := &Type{
+		Name:        name,
+		Aliases:     aliases,
+		Description: description,
+		rule:        reflect.TypeOf(rule),
+	}
+	t.Schema = schemaOf(t.rule)
+	t.Schema.Schema = schemaDialect
+	t.Schema.Title = name
+	for _, f := range fields(t.rule) {
+		if f.Tag.Get("secret") == "true" {
+			t.secrets = append(t.secrets, f.name)
+		}
+	}
+	types = append(types, t)
+	for _, n := range append([]string{name}, aliases...) {
+		byName[n] = t
+	}
+}
+
+// Types returns the middleware types, sorted by name
+func Types() []*Type {
+	sorted := append([]*Type(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// Lookup returns the middleware type named name or one of its aliases
+func Lookup(name string) (*Type, bool) {
+	t, ok := byName[name]
+	return t, ok
+}
+
+// Names returns the names of the middleware types, sorted
+func Names() []string {
+	names := make([]string, 0, len(types))
+	for _, t := range Types() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// Validate checks the rule of a middleware of the given type. Unknown fields are refused, so that
+// a misspelled field is not silently ignored. Field names are prefixed with "rule.".
+func Validate(typeName string, rule map[string]any) ([]FieldError, error) {
+	t, ok := Lookup(typeName)
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	var errs fieldErrors
+	value := reflect.New(t.rule)
+	if err := decodeStrict(rule, value.Interface()); err != nil {
+		errs.add(decodeErrorField(err), decodeErrorMessage(err), nil)
+		return errs, nil
+	}
+	checkTags(value.Elem(), "rule", &errs)
+	if v, ok := value.Interface().(validator); ok {
+		v.validate(&errs)
+	}
+	return errs, nil
+}
+
+// UnknownTypeMessage describes the valid types, for errors about unknown ones
+func UnknownTypeMessage() string {
+	return fmt.Sprintf("must be one of %s", strings.Join(Names(), ", "))
+}
+
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, message string, value any) {
+	*e = append(*e, FieldError{Field: field, Message: message, Value: value})
+}
+
+// decodeStrict decodes a rule into out, refusing the fields out does not have
+func decodeStrict(rule map[string]any, out any) error {
+	if rule == nil {
+		rule = map[string]any{}
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func decodeErrorField(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return "rule." + typeErr.Field
+	}
+	// The decoder reports unknown fields as: json: unknown field "name"
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return "rule." + strings.Trim(name, `"`)
+	}
+	return "rule"
+}
+
+func decodeErrorMessage(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return "must be of type " + jsonType(typeErr.Type)
+	}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return "unknown field"
+	}
+	return "invalid rule: " + err.Error()
+}
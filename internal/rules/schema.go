@@ -0,0 +1,246 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema used to describe rules.
+// Rules are generated from the struct tags of their Go type:
+//
+//	json         the field name, fields without one are not part of the rule
+//	description  the description of the field
+//	required     "true" when the field must be set
+//	enum         the comma separated values a string accepts
+//	minimum      the minimum of a number, zero being left to the gateway default
+//	maximum      the maximum of a number
+//	format       uri, duration, regex, ip-range or size, checked on strings and string lists
+//	secret       "true" when the field is encrypted at rest and never returned by the API, marked writeOnly
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is false for rules and nested objects, and the schema of the values for maps
+	AdditionalProperties any      `json:"additionalProperties,omitempty"`
+	Enum                 []string `json:"enum,omitempty"`
+	Format               string   `json:"format,omitempty"`
+	Minimum              *int64   `json:"minimum,omitempty"`
+	Maximum              *int64   `json:"maximum,omitempty"`
+	WriteOnly            bool     `json:"writeOnly,omitempty"`
+}
+
+// sizePattern matches sizes such as 512, 10KB or 5MiB
+var sizePattern = regexp.MustCompile(`^\d+(\.\d+)?\s*([KMGT]i?B?|B)?$`)
+
+// schemaOf generates the schema of a Go type
+func schemaOf(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+		for _, f := range fields(t) {
+			field := schemaOf(f.Type)
+			field.Description = f.Tag.Get("description")
+			field.Format = f.Tag.Get("format")
+			if enum := f.Tag.Get("enum"); enum != "" {
+				field.Enum = strings.Split(enum, ",")
+			}
+			field.Minimum = intTag(f, "minimum")
+			field.Maximum = intTag(f, "maximum")
+			field.WriteOnly = f.Tag.Get("secret") == "true"
+			if f.Tag.Get("required") == "true" {
+				s.Required = append(s.Required, f.name)
+			}
+			// The format of a list applies to its items
+			if field.Type == "array" && field.Format != "" {
+				field.Items.Format, field.Format = field.Format, ""
+			}
+			s.Properties[f.name] = field
+		}
+		return s
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		s := &Schema{Type: "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			s.AdditionalProperties = schemaOf(t.Elem())
+		}
+		return s
+	}
+	return &Schema{Type: jsonType(t)}
+}
+
+// jsonType returns the JSON type values of a Go type are encoded as
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "any"
+}
+
+// field is a struct field that is part of a rule
+type field struct {
+	reflect.StructField
+	name string
+}
+
+func fields(t reflect.Type) []field {
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		result = append(result, field{StructField: f, name: name})
+	}
+	return result
+}
+
+func intTag(f field, key string) *int64 {
+	value, ok := f.Tag.Lookup(key)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("rules: invalid %s tag on %s", key, f.Name))
+	}
+	return &n
+}
+
+// checkTags checks the value of a rule against the constraints of its struct tags
+func checkTags(v reflect.Value, path string, errs *fieldErrors) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for _, f := range fields(v.Type()) {
+		value := v.FieldByIndex(f.Index)
+		name := path + "." + f.name
+		if f.Tag.Get("required") == "true" && (value.IsZero() || isEmptyList(value)) {
+			errs.add(name, "is required", nil)
+			continue
+		}
+		switch value.Kind() {
+		case reflect.String:
+			checkString(f, name, value.String(), errs)
+		case reflect.Int, reflect.Int64:
+			checkInt(f, name, value.Int(), errs)
+		case reflect.Slice:
+			for i := 0; i < value.Len(); i++ {
+				item := value.Index(i)
+				itemName := fmt.Sprintf("%s[%d]", name, i)
+				if item.Kind() == reflect.String {
+					checkString(f, itemName, item.String(), errs)
+				} else {
+					checkTags(item, itemName, errs)
+				}
+			}
+		case reflect.Struct, reflect.Pointer:
+			checkTags(value, name, errs)
+		}
+	}
+}
+
+func isEmptyList(v reflect.Value) bool {
+	return (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0
+}
+
+// checkString checks a string against the enum and format of its field, empty strings being unset
+func checkString(f field, name, value string, errs *fieldErrors) {
+	if value == "" {
+		return
+	}
+	if enum := f.Tag.Get("enum"); enum != "" && !slices.Contains(strings.Split(enum, ","), value) {
+		errs.add(name, "must be one of "+strings.ReplaceAll(enum, ",", ", "), value)
+		return
+	}
+	if message := checkFormat(f.Tag.Get("format"), value); message != "" {
+		errs.add(name, message, value)
+	}
+}
+
+// checkInt checks a number against the bounds of its field, zero being left to the gateway default
+func checkInt(f field, name string, value int64, errs *fieldErrors) {
+	if value == 0 {
+		return
+	}
+	if minimum := intTag(f, "minimum"); minimum != nil && value < *minimum {
+		errs.add(name, fmt.Sprintf("must be at least %d", *minimum), value)
+	}
+	if maximum := intTag(f, "maximum"); maximum != nil && value > *maximum {
+		errs.add(name, fmt.Sprintf("must be at most %d", *maximum), value)
+	}
+}
+
+// checkFormat returns why a value does not match a format, or an empty string
+func checkFormat(format, value string) string {
+	switch format {
+	case "uri":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "must be an http or https URL"
+		}
+	case "duration":
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return "must be a positive duration such as 30s"
+		}
+	case "regex":
+		if _, err := regexp.Compile(value); err != nil {
+			return "invalid regular expression: " + err.Error()
+		}
+	case "ip-range":
+		if !isIPRange(value) {
+			return "must be an IP address, a CIDR or a range such as 10.0.0.1-10.0.0.9"
+		}
+	case "size":
+		if !sizePattern.MatchString(value) {
+			return "must be a size such as 10MB"
+		}
+	}
+	return ""
+}
+
+func isIPRange(value string) bool {
+	if net.ParseIP(value) != nil {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	start, end, ok := strings.Cut(value, "-")
+	return ok && net.ParseIP(strings.TrimSpace(start)) != nil && net.ParseIP(strings.TrimSpace(end)) != nil
+}
@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"github.com/jkaninda/goma-admin/internal/secrets"
+)
+
+// Redacted replaces the secret fields of rules returned by the API
+const Redacted = "********"
+
+// SecretFields returns the names of the secret fields of the rules of a middleware type
+func SecretFields(typeName string) []string {
+	if t, ok := Lookup(typeName); ok {
+		return t.secrets
+	}
+	return nil
+}
+
+// EncryptSecrets returns a copy of rule with its secret fields encrypted, each field being stored as the
+// encryption of its JSON encoding
+func EncryptSecrets(ctx context.Context, cipher *secrets.Cipher, typeName string, rule map[string]any) (map[string]any, error) {
+	sealed := maps.Clone(rule)
+	for _, name := range SecretFields(typeName) {
+		value, ok := rule[name]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if sealed[name], err = cipher.Encrypt(ctx, data); err != nil {
+			return nil, fmt.Errorf("failed to encrypt rule.%s: %w", name, err)
+		}
+	}
+	return sealed, nil
+}
+
+// DecryptSecrets returns a copy of rule with its secret fields decrypted.
+// Fields stored before secrets were encrypted are kept as is.
+func DecryptSecrets(ctx context.Context, cipher *secrets.Cipher, typeName string, rule map[string]any) (map[string]any, error) {
+	opened := maps.Clone(rule)
+	for _, name := range SecretFields(typeName) {
+		value, ok := rule[name].(string)
+		if !ok || !secrets.IsEncrypted(value) {
+			continue
+		}
+		data, err := cipher.Decrypt(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt rule.%s: %w", name, err)
+		}
+		var field any
+		if err := json.Unmarshal(data, &field); err != nil {
+			return nil, fmt.Errorf("failed to decode rule.%s: %w", name, err)
+		}
+		opened[name] = field
+	}
+	return opened, nil
+}
+
+// RedactSecrets returns a copy of rule with the values of its secret fields replaced by Redacted
+func RedactSecrets(typeName string, rule map[string]any) map[string]any {
+	redacted := maps.Clone(rule)
+	for _, name := range SecretFields(typeName) {
+		if _, ok := rule[name]; ok {
+			redacted[name] = Redacted
+		}
+	}
+	return redacted
+}
+
+// ReencryptSecrets returns a copy of rule with the secret fields that are not encrypted with the current master key
+// encrypted with it, fields stored before secrets were encrypted being encrypted too. changed is false when every
+// secret field was already encrypted with the current master key.
+func ReencryptSecrets(ctx context.Context, cipher *secrets.Cipher, typeName string, rule map[string]any) (reencrypted map[string]any, changed bool, err error) {
+	return encryptStoredSecrets(ctx, cipher, typeName, rule, true)
+}
+
+// EncryptPlaintextSecrets returns a copy of rule with the secret fields stored before secrets were encrypted
+// encrypted, changed being false when every secret field was already encrypted
+func EncryptPlaintextSecrets(ctx context.Context, cipher *secrets.Cipher, typeName string, rule map[string]any) (encrypted map[string]any, changed bool, err error) {
+	return encryptStoredSecrets(ctx, cipher, typeName, rule, false)
+}
+
+// encryptStoredSecrets encrypts the plaintext secret fields of rule, and the fields encrypted with another master
+// key than the current one when reencrypt is set
+func encryptStoredSecrets(ctx context.Context, cipher *secrets.Cipher, typeName string, rule map[string]any, reencrypt bool) (map[string]any, bool, error) {
+	encrypted := maps.Clone(rule)
+	changed := false
+	for _, name := range SecretFields(typeName) {
+		value, ok := rule[name]
+		if !ok {
+			continue
+		}
+		var err error
+		if s, ok := value.(string); ok && secrets.IsEncrypted(s) {
+			if !reencrypt || cipher.IsCurrent(s) {
+				continue
+			}
+			encrypted[name], err = cipher.Reencrypt(ctx, s)
+		} else {
+			var data []byte
+			if data, err = json.Marshal(value); err == nil {
+				encrypted[name], err = cipher.Encrypt(ctx, data)
+			}
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to encrypt rule.%s: %w", name, err)
+		}
+		changed = true
+	}
+	return encrypted, changed, nil
+}
@@ -0,0 +1,180 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	register("basic", []string{"basicAuth"}, "Authenticates requests with HTTP basic authentication", BasicAuth{})
+	register("jwt", []string{"jwtAuth"}, "Authenticates requests bearing a JSON Web Token", JWTAuth{})
+	register("forwardAuth", []string{"auth"}, "Delegates authentication to an external service", ForwardAuth{})
+	register("oauth", nil, "Authenticates users with an OAuth 2.0 provider", OAuth{})
+	register("rateLimit", []string{"ratelimit"}, "Limits the number of requests per client", RateLimit{})
+	register("access", nil, "Blocks the requests to the paths of the middleware", Access{})
+	register("accessPolicy", nil, "Allows or denies requests by source IP address", AccessPolicy{})
+	register("addPrefix", nil, "Adds a prefix to the path of requests", AddPrefix{})
+	register("redirectRegex", nil, "Redirects requests whose URL matches a pattern", RegexReplacement{})
+	register("rewriteRegex", nil, "Rewrites the path of requests matching a pattern", RegexReplacement{})
+	register("redirectScheme", nil, "Redirects requests to another scheme", RedirectScheme{})
+	register("bodyLimit", nil, "Limits the size of request bodies", BodyLimit{})
+	register("httpCache", nil, "Caches responses", HTTPCache{})
+	register("userAgentBlock", nil, "Blocks requests by user agent", UserAgentBlock{})
+	register("headers", nil, "Sets request and response headers", Headers{})
+}
+
+// BasicAuth is the rule of basic middlewares
+type BasicAuth struct {
+	Realm           string   `json:"realm,omitempty" description:"Realm sent in authentication challenges"`
+	ForwardUsername bool     `json:"forwardUsername,omitempty" description:"Forward the authenticated username to the backend"`
+	Users           []string `json:"users" required:"true" secret:"true" description:"Users as username:password, the password being plain or bcrypt hashed"`
+}
+
+func (r *BasicAuth) validate(errs *fieldErrors) {
+	for i, user := range r.Users {
+		if name, password, ok := strings.Cut(user, ":"); !ok || name == "" || password == "" {
+			errs.add(fmt.Sprintf("rule.users[%d]", i), "must be username:password", nil)
+		}
+	}
+}
+
+// JWTAuth is the rule of jwt middlewares
+type JWTAuth struct {
+	Alg                  string            `json:"alg,omitempty" enum:"HS256,HS384,HS512,RS256,RS384,RS512,ES256,ES384,ES512" description:"Signing algorithm of the tokens"`
+	Secret               string            `json:"secret,omitempty" secret:"true" description:"Secret of HMAC signed tokens"`
+	PublicKey            string            `json:"publicKey,omitempty" description:"PEM encoded public key of RSA or ECDSA signed tokens"`
+	JwksURL              string            `json:"jwksUrl,omitempty" format:"uri" description:"URL of the JSON Web Key Set of the issuer"`
+	JwksFile             string            `json:"jwksFile,omitempty" description:"Path of a JSON Web Key Set file on the gateway"`
+	Issuer               string            `json:"issuer,omitempty" description:"Expected issuer of the tokens"`
+	Audience             string            `json:"audience,omitempty" description:"Expected audience of the tokens"`
+	ForwardAuthorization bool              `json:"forwardAuthorization,omitempty" description:"Forward the Authorization header to the backend"`
+	ClaimsExpression     string            `json:"claimsExpression,omitempty" description:"Expression the claims must satisfy"`
+	ForwardHeaders       map[string]string `json:"forwardHeaders,omitempty" description:"Claims forwarded to the backend, by header name"`
+}
+
+func (r *JWTAuth) validate(errs *fieldErrors) {
+	if r.Secret == "" && r.PublicKey == "" && r.JwksURL == "" && r.JwksFile == "" {
+		errs.add("rule.secret", "one of secret, publicKey, jwksUrl or jwksFile is required", nil)
+	}
+}
+
+// ForwardAuth is the rule of forwardAuth middlewares
+type ForwardAuth struct {
+	AuthURL                     string            `json:"authUrl" required:"true" format:"uri" description:"URL of the authentication service"`
+	AuthSignIn                  string            `json:"authSignIn,omitempty" format:"uri" description:"URL unauthenticated users are redirected to"`
+	EnableHostForwarding        bool              `json:"enableHostForwarding,omitempty" description:"Forward the Host header to the authentication service"`
+	SkipInsecureVerify          bool              `json:"skipInsecureVerify,omitempty" description:"Skip the verification of the certificate of the authentication service"`
+	AuthRequestHeaders          []string          `json:"authRequestHeaders,omitempty" description:"Request headers sent to the authentication service"`
+	AddAuthCookiesToResponse    []string          `json:"addAuthCookiesToResponse,omitempty" description:"Cookies of the authentication service added to the response"`
+	AuthResponseHeaders         []string          `json:"authResponseHeaders,omitempty" description:"Headers of the authentication service forwarded to the backend"`
+	AuthResponseHeadersAsParams map[string]string `json:"authResponseHeadersAsParams,omitempty" description:"Headers of the authentication service forwarded as query parameters"`
+}
+
+// OAuthEndpoint are the endpoints of a custom OAuth provider
+type OAuthEndpoint struct {
+	AuthURL     string `json:"authUrl,omitempty" format:"uri" description:"Authorization endpoint"`
+	TokenURL    string `json:"tokenUrl,omitempty" format:"uri" description:"Token endpoint"`
+	UserInfoURL string `json:"userInfoUrl,omitempty" format:"uri" description:"User info endpoint"`
+}
+
+// OAuth is the rule of oauth middlewares
+type OAuth struct {
+	Provider     string        `json:"provider,omitempty" enum:"google,github,gitlab,amazon,facebook,custom" description:"OAuth provider, custom requiring the endpoints"`
+	ClientID     string        `json:"clientId" required:"true" description:"Client ID"`
+	ClientSecret string        `json:"clientSecret" required:"true" secret:"true" description:"Client secret"`
+	RedirectURL  string        `json:"redirectUrl" required:"true" format:"uri" description:"URL the provider redirects users to"`
+	RedirectPath string        `json:"redirectPath,omitempty" description:"Path users are redirected to once authenticated"`
+	CookiePath   string        `json:"cookiePath,omitempty" description:"Path of the session cookie"`
+	Scopes       []string      `json:"scopes,omitempty" description:"Requested scopes"`
+	State        string        `json:"state,omitempty" description:"State sent to the provider"`
+	JWTSecret    string        `json:"jwtSecret,omitempty" secret:"true" description:"Secret signing the session tokens"`
+	Endpoint     OAuthEndpoint `json:"endpoint,omitempty" description:"Endpoints of a custom provider"`
+}
+
+func (r *OAuth) validate(errs *fieldErrors) {
+	if r.Provider == "custom" && (r.Endpoint.AuthURL == "" || r.Endpoint.TokenURL == "") {
+		errs.add("rule.endpoint", "authUrl and tokenUrl are required for a custom provider", nil)
+	}
+}
+
+// RateLimit is the rule of rateLimit middlewares
+type RateLimit struct {
+	Unit            string `json:"unit" required:"true" enum:"second,minute,hour" description:"Unit of the limit"`
+	RequestsPerUnit int    `json:"requestsPerUnit" required:"true" minimum:"1" description:"Requests allowed per unit"`
+	BanAfter        int    `json:"banAfter,omitempty" minimum:"1" description:"Clients exceeding the limit this many times are banned"`
+	BanDuration     string `json:"banDuration,omitempty" format:"duration" description:"Duration of bans"`
+}
+
+// Access is the rule of access middlewares
+type Access struct {
+	StatusCode int `json:"statusCode,omitempty" minimum:"400" maximum:"599" description:"Status code of blocked requests"`
+}
+
+// AccessPolicy is the rule of accessPolicy middlewares
+type AccessPolicy struct {
+	Action       string   `json:"action" required:"true" enum:"ALLOW,DENY" description:"Whether the source ranges are allowed or denied"`
+	SourceRanges []string `json:"sourceRanges" required:"true" format:"ip-range" description:"IP addresses, CIDRs or ranges"`
+}
+
+// AddPrefix is the rule of addPrefix middlewares
+type AddPrefix struct {
+	Prefix string `json:"prefix" required:"true" description:"Prefix added to the path"`
+}
+
+func (r *AddPrefix) validate(errs *fieldErrors) {
+	if r.Prefix != "" && r.Prefix[0] != '/' {
+		errs.add("rule.prefix", "must start with /", r.Prefix)
+	}
+}
+
+// RegexReplacement is the rule of redirectRegex and rewriteRegex middlewares
+type RegexReplacement struct {
+	Pattern     string `json:"pattern" required:"true" format:"regex" description:"Regular expression matched against the request"`
+	Replacement string `json:"replacement" required:"true" description:"Replacement, which may reference the groups of the pattern"`
+}
+
+// RedirectScheme is the rule of redirectScheme middlewares
+type RedirectScheme struct {
+	Scheme    string `json:"scheme" required:"true" enum:"http,https" description:"Scheme requests are redirected to"`
+	Port      int    `json:"port,omitempty" minimum:"1" maximum:"65535" description:"Port requests are redirected to"`
+	Permanent bool   `json:"permanent,omitempty" description:"Redirect permanently"`
+}
+
+// BodyLimit is the rule of bodyLimit middlewares
+type BodyLimit struct {
+	Limit string `json:"limit" required:"true" format:"size" description:"Maximum size of request bodies, such as 10MB"`
+}
+
+// HTTPCache is the rule of httpCache middlewares
+type HTTPCache struct {
+	MaxTTL                   int    `json:"maxTtl,omitempty" minimum:"1" description:"Maximum time responses are cached, in seconds"`
+	MaxStale                 int    `json:"maxStale,omitempty" minimum:"1" description:"Maximum time stale responses are served, in seconds"`
+	DisableCacheStatusHeader bool   `json:"disableCacheStatusHeader,omitempty" description:"Do not add the cache status header to responses"`
+	ExcludedResponseCodes    []int  `json:"excludedResponseCodes,omitempty" description:"Status codes of responses that are not cached"`
+	MemoryLimit              string `json:"memoryLimit,omitempty" format:"size" description:"Maximum size of the cache, such as 100MB"`
+}
+
+func (r *HTTPCache) validate(errs *fieldErrors) {
+	for i, code := range r.ExcludedResponseCodes {
+		if code < 100 || code > 599 {
+			errs.add(fmt.Sprintf("rule.excludedResponseCodes[%d]", i), "must be an HTTP status code", code)
+		}
+	}
+}
+
+// UserAgentBlock is the rule of userAgentBlock middlewares
+type UserAgentBlock struct {
+	UserAgents []string `json:"userAgents" required:"true" description:"Blocked user agents"`
+}
+
+// Headers is the rule of headers middlewares
+type Headers struct {
+	RequestHeaders  map[string]string `json:"requestHeaders,omitempty" description:"Headers set on requests, an empty value removing the header"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty" description:"Headers set on responses, an empty value removing the header"`
+}
+
+func (r *Headers) validate(errs *fieldErrors) {
+	if len(r.RequestHeaders) == 0 && len(r.ResponseHeaders) == 0 {
+		errs.add("rule", "requestHeaders or responseHeaders is required", nil)
+	}
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jkaninda/logger"
+)
+
+// LocalKMS encrypts data keys with master keys read from a file
+type LocalKMS struct {
+	keyID string
+	keys  map[string][]byte
+}
+
+// NewLocalKMS reads the master keys of the file at path, one base64 encoded 32 bytes key per line, lines starting
+// with # being ignored. The first key is the current one, the others are only used to decrypt values encrypted
+// before a rotation. Keys are identified by a hash of them, which versions the values encrypted with each.
+// A missing file is an error wrapping fs.ErrNotExist, see CreateKeyFile.
+func NewLocalKMS(path string) (*LocalKMS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+	}
+	kms := &LocalKMS{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || len(key) != dataKeySize {
+			return nil, fmt.Errorf("secrets key file %s, line %d: keys must be base64 encoded %d bytes keys", path, line, dataKeySize)
+		}
+		id := localKeyID(key)
+		if kms.keyID == "" {
+			kms.keyID = id
+		}
+		kms.keys[id] = key
+	}
+	if kms.keyID == "" {
+		return nil, fmt.Errorf("secrets key file %s contains no key", path)
+	}
+	return kms, nil
+}
+
+func (k *LocalKMS) KeyID() string {
+	return k.keyID
+}
+
+func (k *LocalKMS) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	return seal(k.keys[k.keyID], dataKey, []byte(k.keyID))
+}
+
+func (k *LocalKMS) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+// localKeyID identifies a master key by a hash of it, so that values encrypted with another key are recognized
+func localKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "local-" + hex.EncodeToString(sum[:4])
+}
+
+// CreateKeyFile creates a key file holding a new master key for NewLocalKMS, every admin replica must then be
+// given a copy of it. An existing file is never overwritten, the values encrypted with its keys would be lost.
+func CreateKeyFile(path string) error {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	data := []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	logger.Warn("Generated a new secrets key file, back it up and share it with every admin replica", "path", path)
+	return nil
+}
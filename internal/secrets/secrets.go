@@ -0,0 +1,157 @@
+// Package secrets encrypts the secrets stored in the database with envelope encryption: every value is encrypted
+// with its own data key, which is itself encrypted with a master key held by a key management service
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prefix marks encrypted values, which are formatted as enc:v1:<key ID>:<encrypted data key>:<encrypted value>
+const Prefix = "enc:v1:"
+
+// dataKeySize is the size of data keys, for AES-256
+const dataKeySize = 32
+
+var (
+	// ErrUnknownKey is returned when decrypting a value encrypted with a master key the KMS does not hold
+	ErrUnknownKey = errors.New("unknown master key")
+	// ErrMalformed is returned when decrypting a value that is not in the format written by Encrypt
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+var encoding = base64.RawStdEncoding
+
+// KMS encrypts and decrypts data keys with master keys it never reveals.
+// LocalKMS keeps the master key in a file, other services can be used by implementing this interface.
+type KMS interface {
+	// KeyID identifies the master key new data keys are encrypted with, it must not contain a colon
+	KeyID() string
+	// WrapKey encrypts a data key with the master key identified by KeyID
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key encrypted with the master key identified by keyID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts values with data keys protected by a KMS
+type Cipher struct {
+	kms KMS
+}
+
+func NewCipher(kms KMS) *Cipher {
+	return &Cipher{kms: kms}
+}
+
+// IsEncrypted checks if value was returned by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt encrypts plaintext with a new data key
+func (c *Cipher) Encrypt(ctx context.Context, plaintext []byte) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	keyID := c.kms.KeyID()
+	wrapped, err := c.kms.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt data key: %w", err)
+	}
+	// The key ID is authenticated, so that a value cannot be moved to another master key
+	sealed, err := seal(dataKey, plaintext, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + keyID + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt
+func (c *Cipher) Decrypt(ctx context.Context, value string) ([]byte, error) {
+	keyID, wrapped, sealed, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := c.kms.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	return open(dataKey, sealed, []byte(keyID))
+}
+
+// IsCurrent checks if value was encrypted with the current master key of the KMS
+func (c *Cipher) IsCurrent(value string) bool {
+	keyID, _, _, err := parse(value)
+	return err == nil && keyID == c.kms.KeyID()
+}
+
+// Reencrypt encrypts value again with the current master key. Values that are not encrypted are taken as
+// plaintext, so that secrets stored before encryption was introduced are encrypted.
+func (c *Cipher) Reencrypt(ctx context.Context, value string) (string, error) {
+	plaintext := []byte(value)
+	if IsEncrypted(value) {
+		var err error
+		if plaintext, err = c.Decrypt(ctx, value); err != nil {
+			return "", err
+		}
+	}
+	return c.Encrypt(ctx, plaintext)
+}
+
+func parse(value string) (keyID string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if !IsEncrypted(value) || len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, ErrMalformed
+	}
+	if wrapped, err = encoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = encoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+// seal encrypts plaintext with AES-GCM, the random nonce being prepended to the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a ciphertext returned by seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,23 +1,375 @@
 package services
 
 import (
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
 	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 )
 
 type AdminService struct {
+	conf           *config.Config
+	userRepo       *repository.UserRepository
+	permissionRepo *repository.PermissionRepository
 }
 
 func NewAdminService(conf *config.Config) *AdminService {
-	return &AdminService{}
+	return &AdminService{
+		conf:           conf,
+		userRepo:       repository.NewUserRepository(conf.Database.DB),
+		permissionRepo: repository.NewPermissionRepository(conf.Database.DB),
+	}
 }
 
+// ListUsers lists users page by page, optionally filtered by a search query and a role
 func (s *AdminService) ListUsers(c *okapi.Context) error {
-	return c.OK(nil)
+	page, pageSize := pagination(c)
+	role := c.Query("role")
+	if role != "" && !models.UserRole(role).IsValid() {
+		return c.AbortBadRequest("Invalid role: " + role)
+	}
+	users, total, err := s.userRepo.Search(c.Context(), strings.TrimSpace(c.Query("search")), role, page, pageSize)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list users", err)
+	}
+	return c.OK(dto.UserListResponse{
+		Users:    users,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
 }
 
 func (s *AdminService) GetUser(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(user)
+}
+
+// UserStats returns user counts by status and role
+func (s *AdminService) UserStats(c *okapi.Context) error {
+	stats, err := s.userRepo.GetUserStats(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to get user stats", err)
+	}
+	return c.OK(stats)
+}
+
+func (s *AdminService) CreateUser(c *okapi.Context) error {
+	var req dto.CreateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if req.Role == "" {
+		req.Role = string(models.RoleUser)
+	}
+	if !models.UserRole(req.Role).IsValid() {
+		return c.AbortBadRequest("Invalid role: " + req.Role)
+	}
+	if err := validatePassword(&s.conf.Auth.PasswordPolicy, req.Password); err != nil {
+		return c.AbortBadRequest("Invalid password", err)
+	}
+
+	email := strings.TrimSpace(req.Email)
+	exists, err := s.userRepo.ExistsByEmail(c.Context(), email)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create user", err)
+	}
+	if exists {
+		return c.AbortConflict("Email already in use: " + email)
+	}
+	username := strings.TrimSpace(req.Username)
+	exists, err = s.userRepo.ExistsByUsername(c.Context(), username)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create user", err)
+	}
+	if exists {
+		return c.AbortConflict("Username already in use: " + username)
+	}
+
+	user := &models.User{
+		Email:    email,
+		Name:     req.Name,
+		Username: username,
+		Role:     req.Role,
+		Active:   true,
+		Metadata: models.JSONB{"created_by": currentUserID(c).String()},
+	}
+	if err := user.SetPassword(req.Password); err != nil {
+		return c.AbortInternalServerError("Failed to hash password", err)
+	}
+	if err := s.userRepo.Create(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to create user", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateUser, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"email": user.Email, "role": user.Role})
+
+	return c.Created(user)
+}
+
+// UpdateUser changes the name, role or active flag of a user
+func (s *AdminService) UpdateUser(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.UpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+
+	self := user.ID == currentUserID(c)
+	changes := models.JSONB{}
+	if req.Name != nil {
+		user.Name = *req.Name
+		changes["name"] = user.Name
+	}
+	if req.Role != nil && *req.Role != user.Role {
+		if !models.UserRole(*req.Role).IsValid() {
+			return c.AbortBadRequest("Invalid role: " + *req.Role)
+		}
+		if self {
+			return c.AbortBadRequest("You cannot change your own role")
+		}
+		changes["role"] = models.JSONB{"from": user.Role, "to": *req.Role}
+		user.Role = *req.Role
+	}
+	deactivated := false
+	if req.Active != nil && *req.Active != user.Active {
+		if self && !*req.Active {
+			return c.AbortBadRequest("You cannot deactivate your own account")
+		}
+		deactivated = !*req.Active
+		user.Active = *req.Active
+		changes["active"] = user.Active
+	}
+
+	if err := s.userRepo.Update(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to update user", err)
+	}
+	if deactivated {
+		if err := s.userRepo.RevokeAllUserSessions(c.Context(), user.ID); err != nil {
+			logger.Warn("Failed to revoke sessions of deactivated user", "user", user.ID, "error", err)
+		}
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateUser, "user", user.ID.String(), models.AuditStatusSuccess, changes)
+
+	return c.OK(user)
+}
+
+// DeactivateUser disables a user account and revokes its sessions
+func (s *AdminService) DeactivateUser(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if user.ID == currentUserID(c) {
+		return c.AbortBadRequest("You cannot deactivate your own account")
+	}
+
+	user.Active = false
+	if err := s.userRepo.Update(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to deactivate user", err)
+	}
+	if err := s.userRepo.RevokeAllUserSessions(c.Context(), user.ID); err != nil {
+		return c.AbortInternalServerError("Failed to revoke user sessions", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeactivateUser, "user", user.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// DeleteUser permanently deletes a user along with its sessions
+func (s *AdminService) DeleteUser(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if user.ID == currentUserID(c) {
+		return c.AbortBadRequest("You cannot delete your own account")
+	}
+	if err := s.userRepo.HardDelete(c.Context(), user.ID); err != nil {
+		return c.AbortInternalServerError("Failed to delete user", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeleteUser, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"email": user.Email})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// ResetPassword sets a new password for a user and signs them out everywhere.
+// When no password is provided, a temporary one is generated and returned once;
+// the user must then change it on next login.
+func (s *AdminService) ResetPassword(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if user.AuthProvider != models.AuthProviderLocal {
+		return c.AbortBadRequest("The password of this user is managed by its identity provider")
+	}
+
+	var response dto.ResetPasswordResponse
+	password := req.Password
+	if password == "" {
+		password, err = util.GenerateRandomToken(12)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to generate password", err)
+		}
+		response.TemporaryPassword = password
+	} else if err := validatePassword(&s.conf.Auth.PasswordPolicy, password); err != nil {
+		return c.AbortBadRequest("Invalid password", err)
+	}
+	if err := user.SetPassword(password); err != nil {
+		return c.AbortInternalServerError("Failed to hash password", err)
+	}
+	if err := s.userRepo.UpdatePassword(c.Context(), user.ID, user.Password, response.TemporaryPassword != ""); err != nil {
+		return c.AbortInternalServerError("Failed to reset password", err)
+	}
+	if err := s.userRepo.RevokeAllUserSessions(c.Context(), user.ID); err != nil {
+		logger.Warn("Failed to revoke sessions after password reset", "user", user.ID, "error", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionResetPassword, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"generated": response.TemporaryPassword != ""})
 
-	return c.OK(nil)
+	return c.OK(response)
+}
+
+// UnlockUser clears the lockout and failed login counter of a user account
+func (s *AdminService) UnlockUser(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if err := s.userRepo.UnlockAccount(c.Context(), user.ID); err != nil {
+		return c.AbortInternalServerError("Failed to unlock user", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUnlockUser, "user", user.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// ResetUserMFA removes MFA from a user account, for users who lost their authenticator and recovery codes.
+// Users whose role requires MFA will have to enroll again on next login.
+func (s *AdminService) ResetUserMFA(c *okapi.Context) error {
+	user, err := s.getUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	user.DisableMFA()
+	if err := s.userRepo.UpdateMFA(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to reset MFA", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionMFARemove, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"by": "admin"})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// ListRolePolicies lists the security policies enforced per role
+func (s *AdminService) ListRolePolicies(c *okapi.Context) error {
+	policies, err := s.permissionRepo.ListRolePolicies(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list role policies", err)
+	}
+	return c.OK(policies)
+}
+
+// UpdateRolePolicy sets the security policy of a role, such as requiring MFA
+func (s *AdminService) UpdateRolePolicy(c *okapi.Context) error {
+	role := c.Param("role")
+	if !models.UserRole(role).IsValid() {
+		return c.AbortBadRequest("Invalid role: " + role)
+	}
+	var req dto.RolePolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+
+	policy := &models.RolePolicy{Role: role, RequireMFA: req.RequireMFA}
+	if err := s.permissionRepo.SaveRolePolicy(c.Context(), policy); err != nil {
+		return c.AbortInternalServerError("Failed to update role policy", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateRolePolicy, "role_policy", role, models.AuditStatusSuccess,
+		models.JSONB{"requireMfa": policy.RequireMFA})
+
+	return c.OK(policy)
+}
+
+// ListPermissionGrants lists the environment permission grants
+func (s *AdminService) ListPermissionGrants(c *okapi.Context) error {
+	grants, err := s.permissionRepo.List(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list permission grants", err)
+	}
+	return c.OK(grants)
+}
+
+// CreatePermissionGrant allows a role to modify the instances of an environment
+func (s *AdminService) CreatePermissionGrant(c *okapi.Context) error {
+	var req dto.PermissionGrantRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !models.UserRole(req.Role).IsValid() {
+		return c.AbortBadRequest("Invalid role: " + req.Role)
+	}
+	if req.Environment != models.EnvironmentAny && !validEnvironments[models.InstanceEnvironment(req.Environment)] {
+		return c.AbortBadRequest("Invalid environment: " + req.Environment)
+	}
+
+	grant := &models.PermissionGrant{
+		Role:        req.Role,
+		Environment: req.Environment,
+		Tags:        req.Tags,
+		Description: req.Description,
+	}
+	if err := s.permissionRepo.Create(c.Context(), grant); err != nil {
+		return c.AbortInternalServerError("Failed to create permission grant", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionGrantPermission, "permission_grant", strconv.FormatUint(uint64(grant.ID), 10),
+		models.AuditStatusSuccess, models.JSONB{"role": grant.Role, "environment": grant.Environment, "tags": grant.Tags})
+
+	return c.Created(grant)
+}
+
+// DeletePermissionGrant removes an environment permission grant
+func (s *AdminService) DeletePermissionGrant(c *okapi.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.AbortBadRequest("Invalid permission grant ID", err)
+	}
+	if err := s.permissionRepo.Delete(c.Context(), uint(id)); err != nil {
+		return c.AbortNotFound("Permission grant not found", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionRevokePermission, "permission_grant", c.Param("id"),
+		models.AuditStatusSuccess, nil)
+
+	return c.OK(okapi.M{"status": "ok"})
+}
 
+// getUser loads the user identified by the :id path parameter
+func (s *AdminService) getUser(c *okapi.Context) (*models.User, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, errBadRequest("Invalid user ID", err)
+	}
+	user, err := s.userRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return nil, errNotFound("User not found", err)
+	}
+	return user, nil
 }
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/logger"
+	"github.com/jkaninda/okapi"
+)
+
+// recordAudit writes an audit log entry for the current request.
+// A nil userID records the entry without an owner; failures are logged and never interrupt the request.
+func recordAudit(c *okapi.Context, repo *repository.UserRepository, userID uuid.UUID, action models.AuditAction,
+	resource, resourceID string, status models.AuditStatus, details models.JSONB) {
+	auditLog := &models.AuditLog{
+		Action:     string(action),
+		Resource:   resource,
+		ResourceID: resourceID,
+		IPAddress:  c.RealIP(),
+		UserAgent:  c.Header("User-Agent"),
+		Status:     string(status),
+		Details:    details,
+	}
+	if userID != uuid.Nil {
+		auditLog.UserID = &userID
+	}
+	if err := repo.CreateAuditLog(c.Context(), auditLog); err != nil {
+		logger.Warn("Failed to create audit log", "action", action, "error", err)
+	}
+}
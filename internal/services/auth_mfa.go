@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/mfa"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/okapi"
+)
+
+const (
+	// mfaChallengeTTL is how long the user has to enter a code after a successful password check
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaAudienceSuffix keeps MFA challenges from being accepted as access tokens
+	mfaAudienceSuffix = "/mfa"
+	recoveryCodeCount = 10
+)
+
+// VerifyMFA completes a login started with a password by checking a TOTP or recovery code
+func (s *AuthService) VerifyMFA(c *okapi.Context) error {
+	var req dto.MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	userID, err := s.parseMFAChallenge(req.MFAToken)
+	if err != nil {
+		return c.AbortUnauthorized("Invalid or expired MFA token")
+	}
+	user, err := s.userRepo.GetByID(c.Context(), userID)
+	if err != nil || !user.MFAEnabled {
+		return c.AbortUnauthorized("Invalid or expired MFA token")
+	}
+	if user.IsLocked() {
+		c.SetHeader("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil).Seconds())+1))
+		return c.AbortLocked("Account is temporarily locked due to too many failed login attempts")
+	}
+	if !user.Active {
+		return c.AbortForbidden("Account is disabled")
+	}
+
+	method, err := s.verifyMFACode(c, user, req.Code)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to verify MFA code", err)
+	}
+	if method == "" {
+		s.registerFailedLogin(c, user, "invalid_mfa_code")
+		return c.AbortUnauthorized("Invalid MFA code")
+	}
+
+	return s.startSession(c, user, models.JSONB{"mfa": method})
+}
+
+// EnrollMFA generates a TOTP secret for the signed-in user.
+// MFA is only enabled once a code generated from it is confirmed with EnableMFA.
+func (s *AuthService) EnrollMFA(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if user.HasExternalMFA() {
+		return c.AbortBadRequest("MFA is managed by your identity provider")
+	}
+	if user.MFAEnabled {
+		return c.AbortConflict("MFA is already enabled")
+	}
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return c.AbortInternalServerError("Failed to generate MFA secret", err)
+	}
+	user.MFASecret = secret
+	user.MFALastUsedStep = 0
+	if err := s.userRepo.UpdateMFA(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to start MFA enrollment", err)
+	}
+
+	return c.OK(dto.MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: mfa.ProvisioningURI(secret, util.AppName, user.Email),
+	})
+}
+
+// EnableMFA confirms the enrollment with a code from the authenticator and returns recovery codes
+func (s *AuthService) EnableMFA(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.MFACodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if user.MFAEnabled {
+		return c.AbortConflict("MFA is already enabled")
+	}
+	if user.MFASecret == "" {
+		return c.AbortBadRequest("MFA enrollment has not been started")
+	}
+	step, ok := mfa.Validate(user.MFASecret, req.Code, time.Now())
+	if !ok {
+		return c.AbortBadRequest("Invalid MFA code")
+	}
+	codes, hashed, err := newRecoveryCodes()
+	if err != nil {
+		return c.AbortInternalServerError("Failed to generate recovery codes", err)
+	}
+
+	user.MFAEnabled = true
+	user.MFALastUsedStep = step
+	user.MFARecoveryCodes = hashed
+	if err := s.userRepo.UpdateMFA(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to enable MFA", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionMFAEnroll, "user", user.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(dto.MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA removes MFA from the signed-in user's account, unless their role requires it
+func (s *AuthService) DisableMFA(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.MFADisableRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !user.MFAEnabled {
+		return c.AbortBadRequest("MFA is not enabled")
+	}
+	required, err := s.permissionRepo.RequiresMFA(c.Context(), user.Role)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to check MFA policy", err)
+	}
+	if required {
+		return c.AbortForbidden("MFA is required for your role")
+	}
+	if !user.CheckPassword(req.Password) {
+		return c.AbortBadRequest("Current password is incorrect")
+	}
+	method, err := s.verifyMFACode(c, user, req.Code)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to verify MFA code", err)
+	}
+	if method == "" {
+		return c.AbortBadRequest("Invalid MFA code")
+	}
+
+	user.DisableMFA()
+	if err := s.userRepo.UpdateMFA(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to disable MFA", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionMFARemove, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"by": "self"})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed-in user
+func (s *AuthService) RegenerateRecoveryCodes(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.MFACodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !user.MFAEnabled {
+		return c.AbortBadRequest("MFA is not enabled")
+	}
+	step, ok := mfa.Validate(user.MFASecret, req.Code, time.Now())
+	if !ok {
+		return c.AbortBadRequest("Invalid MFA code")
+	}
+	used, err := s.userRepo.UseMFAStep(c.Context(), user.ID, step)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to verify MFA code", err)
+	}
+	if !used {
+		return c.AbortBadRequest("Invalid MFA code")
+	}
+	codes, hashed, err := newRecoveryCodes()
+	if err != nil {
+		return c.AbortInternalServerError("Failed to generate recovery codes", err)
+	}
+
+	if err := s.userRepo.UpdateRecoveryCodes(c.Context(), user.ID, hashed); err != nil {
+		return c.AbortInternalServerError("Failed to save recovery codes", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionMFARecoveryCodes, "user", user.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(dto.MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifyMFACode checks a TOTP code, or consumes a recovery code, and returns the method that matched.
+// An empty method means the code is invalid. Codes are consumed with conditional updates, so that
+// concurrent requests cannot use the same code twice.
+func (s *AuthService) verifyMFACode(c *okapi.Context, user *models.User, code string) (string, error) {
+	if step, ok := mfa.Validate(user.MFASecret, code, time.Now()); ok {
+		used, err := s.userRepo.UseMFAStep(c.Context(), user.ID, step)
+		if err != nil || !used {
+			return "", err
+		}
+		user.MFALastUsedStep = step
+		return "totp", nil
+	}
+
+	hashed := util.HashToken(mfa.NormalizeRecoveryCode(code))
+	index := slices.Index(user.MFARecoveryCodes, hashed)
+	if index < 0 {
+		return "", nil
+	}
+	used, err := s.userRepo.UseRecoveryCode(c.Context(), user.ID, hashed)
+	if err != nil || !used {
+		return "", err
+	}
+	user.MFARecoveryCodes = slices.Delete(user.MFARecoveryCodes, index, index+1)
+	return "recovery_code", nil
+}
+
+// issueMFAChallenge signs a short-lived token proving the user passed the password check
+func (s *AuthService) issueMFAChallenge(user *models.User) (*dto.MFAChallengeResponse, error) {
+	token, err := okapi.GenerateJwtToken([]byte(s.conf.JWT.Secret), jwt.MapClaims{
+		"sub": user.ID.String(),
+		"iss": s.conf.JWT.Issuer,
+		"aud": s.conf.JWT.Audience + mfaAudienceSuffix,
+	}, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   time.Now().Add(mfaChallengeTTL).Unix(),
+	}, nil
+}
+
+// parseMFAChallenge validates an MFA challenge and returns the ID of the user it was issued for
+func (s *AuthService) parseMFAChallenge(token string) (uuid.UUID, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.conf.JWT.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.conf.JWT.Issuer),
+		jwt.WithAudience(s.conf.JWT.Audience+mfaAudienceSuffix),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if subject == "" {
+		return uuid.Nil, errors.New("missing subject")
+	}
+	return uuid.Parse(subject)
+}
+
+// newRecoveryCodes returns fresh recovery codes along with the hashes to store
+func newRecoveryCodes() ([]string, models.StringArray, error) {
+	codes, err := mfa.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	hashed := make(models.StringArray, len(codes))
+	for i, code := range codes {
+		hashed[i] = util.HashToken(code)
+	}
+	return codes, hashed, nil
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
+	"github.com/jkaninda/okapi"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcStateCookie carries the state, nonce and PKCE verifier of a login between the redirect and the callback
+	oidcStateCookie    = "goma_oidc_state"
+	oidcStateTTL       = 10 * time.Minute
+	oidcAudienceSuffix = "/oidc"
+	oidcCallbackPath   = "/auth/callback"
+	oidcLoginPath      = "/auth/login"
+)
+
+// oidcClient discovers the identity provider on first use, so that the server
+// can start while the provider is unreachable
+type oidcClient struct {
+	conf     *config.OIDCConfig
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCClient(conf *config.OIDCConfig) *oidcClient {
+	if !conf.Enabled {
+		return nil
+	}
+	return &oidcClient{conf: conf}
+}
+
+// init returns the OAuth2 configuration and ID token verifier of the provider
+func (o *oidcClient) init(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.oauth2 != nil {
+		return o.oauth2, o.verifier, nil
+	}
+	provider, err := oidc.NewProvider(ctx, o.conf.IssuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	o.oauth2 = &oauth2.Config{
+		ClientID:     o.conf.ClientID,
+		ClientSecret: o.conf.ClientSecret,
+		RedirectURL:  o.conf.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.conf.Scopes,
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.conf.ClientID})
+	return o.oauth2, o.verifier, nil
+}
+
+// oidcClaims are the ID token claims used to provision users
+type oidcClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
+
+// LoginProviders tells the login page which sign-in methods are available
+func (s *AuthService) LoginProviders(c *okapi.Context) error {
+	_, ldapEnabled := s.authenticators[models.AuthProviderLDAP]
+	return c.OK(dto.LoginProvidersResponse{
+		Local: s.conf.Auth.LocalLoginEnabled,
+		LDAP:  ldapEnabled,
+		OIDC: dto.OIDCProviderResponse{
+			Enabled:     s.oidc != nil,
+			DisplayName: s.conf.OIDC.DisplayName,
+		},
+	})
+}
+
+// OIDCLogin redirects the browser to the identity provider, using the authorization code flow with PKCE
+func (s *AuthService) OIDCLogin(c *okapi.Context) error {
+	if s.oidc == nil {
+		return c.AbortNotFound("OIDC login is not enabled")
+	}
+	oauth2Config, _, err := s.oidc.init(c.Context())
+	if err != nil {
+		return c.AbortServiceUnavailable("Identity provider is unavailable", err)
+	}
+	state, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to start login", err)
+	}
+	nonce, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to start login", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	cookie, err := okapi.GenerateJwtToken([]byte(s.conf.JWT.Secret), jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"iss":      s.conf.JWT.Issuer,
+		"aud":      s.conf.JWT.Audience + oidcAudienceSuffix,
+	}, oidcStateTTL)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to start login", err)
+	}
+	s.setOIDCStateCookie(c, cookie, int(oidcStateTTL.Seconds()))
+
+	c.Redirect(http.StatusFound, oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+	return nil
+}
+
+// OIDCCallback completes the login started by OIDCLogin and redirects to the dashboard with the session tokens
+func (s *AuthService) OIDCCallback(c *okapi.Context) error {
+	if s.oidc == nil {
+		return c.AbortNotFound("OIDC login is not enabled")
+	}
+	cookie, _ := c.Cookie(oidcStateCookie)
+	s.setOIDCStateCookie(c, "", -1)
+
+	if providerError := c.Query("error"); providerError != "" {
+		logger.Warn("OIDC login rejected by identity provider", "error", providerError, "description", c.Query("error_description"))
+		return s.oidcLoginFailed(c, "Login was cancelled or rejected by the identity provider")
+	}
+	state, err := s.parseOIDCState(cookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(state["state"]), []byte(c.Query("state"))) != 1 {
+		return s.oidcLoginFailed(c, "Login session expired, please try again")
+	}
+	claims, err := s.exchangeOIDCCode(c.Context(), c.Query("code"), state["verifier"], state["nonce"])
+	if err != nil {
+		logger.Error("OIDC code exchange failed", "error", err)
+		return s.oidcLoginFailed(c, "Could not verify your identity")
+	}
+	user, err := s.provisionOIDCUser(c, claims)
+	if err != nil {
+		userID := uuid.Nil
+		if user != nil {
+			userID = user.ID
+		}
+		recordAudit(c, s.userRepo, userID, models.AuditActionLoginFailed, "user", claims.Subject, models.AuditStatusFailure,
+			models.JSONB{"provider": models.AuthProviderOIDC, "email": claims.Email, "reason": err.Error()})
+		return s.oidcLoginFailed(c, err.Error())
+	}
+
+	response, err := s.createSession(c, user, models.JSONB{"provider": models.AuthProviderOIDC})
+	if err != nil {
+		logger.Error("Failed to create session after OIDC login", "user", user.ID, "error", err)
+		return s.oidcLoginFailed(c, "Failed to create session")
+	}
+	// Tokens are passed in the fragment so that they are not sent to servers or written to access logs
+	fragment := url.Values{}
+	fragment.Set("access_token", response.AccessToken)
+	fragment.Set("refresh_token", response.RefreshToken)
+	fragment.Set("expires_at", fmt.Sprint(response.ExpiresAt))
+	fragment.Set("token_type", response.TokenType)
+	c.Redirect(http.StatusFound, s.conf.Server.AppURL+oidcCallbackPath+"#"+fragment.Encode())
+	return nil
+}
+
+// exchangeOIDCCode redeems the authorization code and validates the ID token against the provider keys
+func (s *AuthService) exchangeOIDCCode(ctx context.Context, code, verifier, nonce string) (*oidcClaims, error) {
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+	oauth2Config, idTokenVerifier, err := s.oidc.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := idTokenVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+
+	var raw map[string]any
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	claims := &oidcClaims{
+		Subject:           idToken.Subject,
+		Email:             claimString(raw, "email"),
+		Name:              claimString(raw, "name"),
+		PreferredUsername: claimString(raw, "preferred_username"),
+		Groups:            claimStrings(raw, s.conf.OIDC.GroupsClaim),
+	}
+	claims.EmailVerified, _ = raw["email_verified"].(bool)
+	if claims.Email == "" {
+		return nil, errors.New("ID token has no email claim")
+	}
+	return claims, nil
+}
+
+// provisionOIDCUser finds, links or creates the user matching the ID token and applies the role mapping.
+// The returned errors are shown to the user.
+func (s *AuthService) provisionOIDCUser(c *okapi.Context, claims *oidcClaims) (*models.User, error) {
+	role := mapGroupsToRole(claims.Groups, s.conf.OIDC.RoleMapping, s.conf.OIDC.DefaultRole)
+	if role == "" {
+		return nil, errors.New("your account is not allowed to access this dashboard")
+	}
+
+	user, err := s.userRepo.GetByExternalID(c.Context(), models.AuthProviderOIDC, claims.Subject)
+	if errors.Is(err, repository.ErrNotFound) {
+		user, err = s.userRepo.GetByEmail(c.Context(), claims.Email)
+		if err == nil && !canLinkOIDCUser(user, claims, s.conf.OIDC.LinkByEmail) {
+			return user, errors.New("an account with this email already exists")
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			if !s.conf.OIDC.AllowSignup {
+				return nil, errors.New("your account has not been created yet, contact an administrator")
+			}
+			return s.createOIDCUser(c, claims, role)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to look up OIDC user", "subject", claims.Subject, "error", err)
+		return nil, errors.New("failed to sign you in")
+	}
+	if !user.Active {
+		return user, errors.New("your account is disabled")
+	}
+	if user.IsLocked() {
+		return user, errors.New("your account is temporarily locked")
+	}
+
+	user.AuthProvider = models.AuthProviderOIDC
+	user.ExternalID = claims.Subject
+	user.Email = claims.Email
+	user.EmailVerified = claims.EmailVerified
+	if claims.Name != "" {
+		user.Name = claims.Name
+	}
+	// Roles are only managed by the identity provider once groups are mapped
+	if len(s.conf.OIDC.RoleMapping) > 0 && role != user.Role {
+		logger.Info("Updating role from identity provider groups", "user", user.ID, "from", user.Role, "to", role)
+		user.Role = role
+	}
+	if err := s.userRepo.Update(c.Context(), user); err != nil {
+		logger.Error("Failed to update OIDC user", "user", user.ID, "error", err)
+		return user, errors.New("failed to update your account")
+	}
+	return user, nil
+}
+
+// canLinkOIDCUser tells whether the first login of an OIDC user may take over the existing account with the same
+// email. This trusts the provider with the account, so it must be enabled and vouched for by the provider, and
+// accounts whose takeover would be the most harmful are never linked.
+func canLinkOIDCUser(user *models.User, claims *oidcClaims, linkByEmail bool) bool {
+	return linkByEmail && claims.EmailVerified && !user.ServiceAccount && !user.MFAEnabled && !user.CanAccess(models.RoleAdmin)
+}
+
+// createOIDCUser provisions a user on their first login
+func (s *AuthService) createOIDCUser(c *okapi.Context, claims *oidcClaims, role string) (*models.User, error) {
+	username, err := availableUsername(c.Context(), s.userRepo, claims.PreferredUsername, claims.Email)
+	if err != nil {
+		logger.Error("Failed to pick a username", "error", err)
+		return nil, errors.New("failed to create your account")
+	}
+	// The password is never used, users of the identity provider cannot log in locally
+	password, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, errors.New("failed to create your account")
+	}
+	user := &models.User{
+		Email:         claims.Email,
+		Name:          claims.Name,
+		Username:      username,
+		Role:          role,
+		Active:        true,
+		EmailVerified: claims.EmailVerified,
+		AuthProvider:  models.AuthProviderOIDC,
+		ExternalID:    claims.Subject,
+		Metadata:      models.JSONB{"created_by": models.AuthProviderOIDC},
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, errors.New("failed to create your account")
+	}
+	if err := s.userRepo.Create(c.Context(), user); err != nil {
+		logger.Error("Failed to create OIDC user", "email", claims.Email, "error", err)
+		return nil, errors.New("failed to create your account")
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionCreateUser, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"provider": models.AuthProviderOIDC, "email": user.Email, "role": user.Role})
+
+	return user, nil
+}
+
+// availableUsername returns the preferred username, or the local part of the email,
+// suffixed with a number when already taken
+func availableUsername(ctx context.Context, userRepo *repository.UserRepository, preferred, email string) (string, error) {
+	base := preferred
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	username := base
+	for i := 2; ; i++ {
+		exists, err := userRepo.ExistsByUsername(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return username, nil
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+func (s *AuthService) parseOIDCState(cookie string) (map[string]string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(cookie, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.conf.JWT.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.conf.JWT.Issuer),
+		jwt.WithAudience(s.conf.JWT.Audience+oidcAudienceSuffix),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"state":    claimString(claims, "state"),
+		"nonce":    claimString(claims, "nonce"),
+		"verifier": claimString(claims, "verifier"),
+	}, nil
+}
+
+func (s *AuthService) setOIDCStateCookie(c *okapi.Context, value string, maxAge int) {
+	http.SetCookie(c.ResponseWriter(), &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.conf.OIDC.RedirectURL, "https://"),
+		// Lax lets the cookie through the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcLoginFailed sends the browser back to the login page with an error message
+func (s *AuthService) oidcLoginFailed(c *okapi.Context, message string) error {
+	c.Redirect(http.StatusFound, s.conf.Server.AppURL+oidcLoginPath+"?error="+url.QueryEscape(message))
+	return nil
+}
+
+// mapGroupsToRole returns the highest role mapped from the groups, or the default role
+func mapGroupsToRole(groups []string, mapping map[string]string, defaultRole string) string {
+	role := defaultRole
+	for _, group := range groups {
+		mapped, ok := mapping[group]
+		if ok && (role == "" || models.UserRole(mapped).CanAccess(models.UserRole(role))) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func claimString(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings reads a claim holding either a list of strings or a single string
+func claimStrings(claims map[string]any, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+)
+
+const (
+	testOIDCClientID = "goma-admin"
+	testOIDCCode     = "authorization-code"
+	testOIDCKeyID    = "test-key"
+)
+
+// mockOIDCProvider serves the discovery document, keys and token endpoint of an identity provider,
+// issuing an ID token with the claims of the test for the authorization code
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// signingKey signs the ID tokens, the key published by the provider unless a test forges them
+	signingKey *rsa.PrivateKey
+	claims     jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, signingKey: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testOIDCKeyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != testOIDCCode || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, p.claims)
+		token.Header["kid"] = testOIDCKeyID
+		idToken, err := token.SignedString(p.signingKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// idTokenClaims returns valid claims for the nonce, to be altered by the tests
+func (p *mockOIDCProvider) idTokenClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            testOIDCClientID,
+		"sub":            "subject-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"developers", "viewers"},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func newTestOIDCService(issuerURL string) *AuthService {
+	conf := &config.Config{OIDC: config.OIDCConfig{
+		Enabled:     true,
+		IssuerURL:   issuerURL,
+		ClientID:    testOIDCClientID,
+		RedirectURL: "http://localhost:8080/api/v1/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		GroupsClaim: "groups",
+	}}
+	return &AuthService{conf: conf, oidc: newOIDCClient(&conf.OIDC)}
+}
+
+func TestExchangeOIDCCode(t *testing.T) {
+	const nonce = "nonce-1"
+	tests := []struct {
+		name    string
+		code    string
+		nonce   string
+		forge   bool
+		alter   func(jwt.MapClaims)
+		wantErr bool
+		check   func(*testing.T, *oidcClaims)
+	}{
+		{
+			name:  "valid ID token",
+			code:  testOIDCCode,
+			nonce: nonce,
+			check: func(t *testing.T, claims *oidcClaims) {
+				if claims.Subject != "subject-1" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+					t.Errorf("unexpected claims: %+v", claims)
+				}
+				if len(claims.Groups) != 2 || claims.Groups[0] != "developers" {
+					t.Errorf("unexpected groups: %v", claims.Groups)
+				}
+			},
+		},
+		{
+			name:  "missing email_verified is not verified",
+			code:  testOIDCCode,
+			nonce: nonce,
+			alter: func(claims jwt.MapClaims) { delete(claims, "email_verified") },
+			check: func(t *testing.T, claims *oidcClaims) {
+				if claims.EmailVerified {
+					t.Error("email should not be verified")
+				}
+			},
+		},
+		{name: "missing code", code: "", nonce: nonce, wantErr: true},
+		{name: "rejected code", code: "other-code", nonce: nonce, wantErr: true},
+		{name: "nonce mismatch", code: testOIDCCode, nonce: "other-nonce", wantErr: true},
+		{name: "forged signature", code: testOIDCCode, nonce: nonce, forge: true, wantErr: true},
+		{
+			name:    "other audience",
+			code:    testOIDCCode,
+			nonce:   nonce,
+			alter:   func(claims jwt.MapClaims) { claims["aud"] = "other-client" },
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			code:    testOIDCCode,
+			nonce:   nonce,
+			alter:   func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: true,
+		},
+		{
+			name:    "missing email",
+			code:    testOIDCCode,
+			nonce:   nonce,
+			alter:   func(claims jwt.MapClaims) { delete(claims, "email") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newMockOIDCProvider(t)
+			provider.claims = provider.idTokenClaims(nonce)
+			if tt.alter != nil {
+				tt.alter(provider.claims)
+			}
+			if tt.forge {
+				forged, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				provider.signingKey = forged
+			}
+			service := newTestOIDCService(provider.URL)
+
+			claims, err := service.exchangeOIDCCode(context.Background(), tt.code, "verifier", tt.nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got claims %+v", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, claims)
+		})
+	}
+}
+
+func TestCanLinkOIDCUser(t *testing.T) {
+	verified := &oidcClaims{Email: "jane@example.com", EmailVerified: true}
+	unverified := &oidcClaims{Email: "jane@example.com"}
+	tests := []struct {
+		name        string
+		user        models.User
+		claims      *oidcClaims
+		linkByEmail bool
+		want        bool
+	}{
+		{name: "verified email", user: models.User{Role: string(models.RoleUser)}, claims: verified, linkByEmail: true, want: true},
+		{name: "linking disabled", user: models.User{Role: string(models.RoleUser)}, claims: verified},
+		{name: "unverified email", user: models.User{Role: string(models.RoleUser)}, claims: unverified, linkByEmail: true},
+		{name: "admin", user: models.User{Role: string(models.RoleAdmin)}, claims: verified, linkByEmail: true},
+		{name: "superadmin", user: models.User{Role: string(models.RoleSuperAdmin)}, claims: verified, linkByEmail: true},
+		{name: "MFA enrolled", user: models.User{Role: string(models.RoleViewer), MFAEnabled: true}, claims: verified, linkByEmail: true},
+		{name: "service account", user: models.User{Role: string(models.RoleUser), ServiceAccount: true}, claims: verified, linkByEmail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canLinkOIDCUser(&tt.user, tt.claims, tt.linkByEmail); got != tt.want {
+				t.Errorf("canLinkOIDCUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapGroupsToRole(t *testing.T) {
+	mapping := map[string]string{"platform-admins": "admin", "developers": "user"}
+	tests := []struct {
+		name        string
+		groups      []string
+		defaultRole string
+		want        string
+	}{
+		{name: "highest mapped role wins", groups: []string{"developers", "platform-admins"}, defaultRole: "viewer", want: "admin"},
+		{name: "mapped role", groups: []string{"developers"}, defaultRole: "viewer", want: "user"},
+		{name: "default role", groups: []string{"other"}, defaultRole: "viewer", want: "viewer"},
+		{name: "rejected without default role", groups: []string{"other"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapGroupsToRole(tt.groups, mapping, tt.defaultRole); got != tt.want {
+				t.Errorf("mapGroupsToRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,29 +1,577 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
 	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/mailer"
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
 	"github.com/jkaninda/okapi"
 )
 
+const tokenTypeBearer = "Bearer"
+
 type AuthService struct {
+	conf           *config.Config
+	userRepo       *repository.UserRepository
+	permissionRepo *repository.PermissionRepository
+	mailer         mailer.Mailer
+	// authenticators verify password logins, keyed by the auth provider of the accounts they verify
+	authenticators map[string]Authenticator
+	// oidc is nil when OIDC login is disabled
+	oidc *oidcClient
 }
 
 func NewAuthService(conf *config.Config) *AuthService {
-	return &AuthService{}
+	userRepo := repository.NewUserRepository(conf.Database.DB)
+	return &AuthService{
+		conf:           conf,
+		userRepo:       userRepo,
+		permissionRepo: repository.NewPermissionRepository(conf.Database.DB),
+		mailer:         mailer.New(&conf.Mail),
+		authenticators: newAuthenticators(conf, userRepo),
+		oidc:           newOIDCClient(&conf.OIDC),
+	}
 }
 
+// Login signs in with a password, verified by the authenticator of the account's provider.
+// Unknown logins are tried against the directory when LDAP is enabled.
 func (s *AuthService) Login(c *okapi.Context) error {
+	if len(s.authenticators) == 0 {
+		return c.AbortForbidden("Password login is disabled")
+	}
 	var req dto.LoginRequest
 	if err := c.Bind(&req); err != nil {
 		return c.AbortBadRequest("Invalid request", err)
 	}
 
-	return c.OK(nil)
+	login := strings.TrimSpace(req.Email)
+	user := s.findLoginUser(c.Context(), login)
+	authenticator := s.authenticatorFor(user)
+	if authenticator == nil {
+		// Take as long as a wrong password, so that the response time does not reveal which accounts exist
+		compareDummyPassword(req.Password)
+		recordAudit(c, s.userRepo, uuid.Nil, models.AuditActionLoginFailed, "user", "", models.AuditStatusFailure,
+			models.JSONB{"email": login, "reason": "unknown_user"})
+		return c.AbortUnauthorized("Invalid email or password")
+	}
+	if user != nil && user.IsLocked() {
+		recordAudit(c, s.userRepo, user.ID, models.AuditActionLoginFailed, "user", user.ID.String(), models.AuditStatusFailure,
+			models.JSONB{"reason": "account_locked", "lockedUntil": user.LockedUntil})
+		c.SetHeader("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil).Seconds())+1))
+		return c.AbortLocked("Account is temporarily locked due to too many failed login attempts")
+	}
+	authenticated, err := authenticator.Authenticate(c, user, login, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidCredentials) && user != nil:
+			s.registerFailedLogin(c, user, "invalid_password")
+			return c.AbortUnauthorized("Invalid email or password")
+		case errors.Is(err, errInvalidCredentials):
+			recordAudit(c, s.userRepo, uuid.Nil, models.AuditActionLoginFailed, "user", "", models.AuditStatusFailure,
+				models.JSONB{"email": login, "reason": "unknown_user"})
+			return c.AbortUnauthorized("Invalid email or password")
+		}
+		recordAudit(c, s.userRepo, uuid.Nil, models.AuditActionLoginFailed, "user", "", models.AuditStatusFailure,
+			models.JSONB{"email": login, "reason": err.Error()})
+		return abort(c, err)
+	}
+	user = authenticated
+	if !user.Active {
+		return c.AbortForbidden("Account is disabled")
+	}
+	if user.MFAEnabled {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to issue MFA challenge", err)
+		}
+		return c.OK(challenge)
+	}
+
+	return s.startSession(c, user, nil)
+}
+
+// findLoginUser returns the account matching the login by email, or by username for directory users,
+// nil when there is none
+func (s *AuthService) findLoginUser(ctx context.Context, login string) *models.User {
+	user, err := s.userRepo.GetByEmail(ctx, login)
+	if err == nil {
+		return user
+	}
+	user, err = s.userRepo.GetByUsername(ctx, login)
+	if err != nil || user.AuthProvider != models.AuthProviderLDAP {
+		return nil
+	}
+	return user
+}
+
+// authenticatorFor returns the authenticator verifying the password of the account, nil when the account
+// cannot sign in with a password. Unknown logins are left to the directory, if enabled.
+func (s *AuthService) authenticatorFor(user *models.User) Authenticator {
+	if user == nil {
+		return s.authenticators[models.AuthProviderLDAP]
+	}
+	if user.ServiceAccount {
+		return nil
+	}
+	return s.authenticators[user.AuthProvider]
+}
+
+// Refresh exchanges a refresh token for a new access token and rotates the refresh token
+func (s *AuthService) Refresh(c *okapi.Context) error {
+	var req dto.RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+
+	refreshToken := util.HashToken(req.RefreshToken)
+	session, err := s.userRepo.GetSessionByRefreshToken(c.Context(), refreshToken)
+	if err != nil || !session.IsValid() {
+		return c.AbortUnauthorized("Invalid or expired refresh token")
+	}
+	if !session.User.Active {
+		return c.AbortForbidden("Account is disabled")
+	}
+
+	session.IPAddress = c.RealIP()
+	session.UserAgent = c.Header("User-Agent")
+	response, err := s.issueTokens(&session.User, session)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to issue tokens", err)
+	}
+	// The new tokens are only returned once they replaced the refresh token, which a concurrent refresh or a
+	// revocation prevents
+	if err := s.userRepo.RotateSessionTokens(c.Context(), session, refreshToken); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return c.AbortUnauthorized("Invalid or expired refresh token")
+		}
+		return c.AbortInternalServerError("Failed to refresh session", err)
+	}
 
+	return c.OK(response)
 }
 
 func (s *AuthService) Logout(c *okapi.Context) error {
+	sessionID, err := uuid.Parse(c.GetString(middlewares.SessionIDKey))
+	if err != nil {
+		return c.AbortUnauthorized("Invalid session")
+	}
+	if err := s.userRepo.RevokeSession(c.Context(), sessionID); err != nil {
+		return c.AbortInternalServerError("Failed to revoke session", err)
+	}
+	if userID, err := uuid.Parse(c.GetString(middlewares.UserIDKey)); err == nil {
+		recordAudit(c, s.userRepo, userID, models.AuditActionLogout, "session", sessionID.String(), models.AuditStatusSuccess, nil)
+	}
 
 	return c.OK(okapi.M{"status": "ok"})
 }
+
+// ListSessions returns the active sessions of the signed-in user
+func (s *AuthService) ListSessions(c *okapi.Context) error {
+	userID, err := uuid.Parse(c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		return c.AbortUnauthorized("Invalid token")
+	}
+	sessions, err := s.userRepo.GetUserSessions(c.Context(), userID)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list sessions", err)
+	}
+
+	currentID := c.GetString(middlewares.SessionIDKey)
+	response := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.IsValid() {
+			continue
+		}
+		response = append(response, dto.SessionResponse{
+			ID:        session.ID.String(),
+			IPAddress: session.IPAddress,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   session.ID.String() == currentID,
+		})
+	}
+	return c.OK(response)
+}
+
+// RevokeSession revokes one of the signed-in user's sessions
+func (s *AuthService) RevokeSession(c *okapi.Context) error {
+	userID, err := uuid.Parse(c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		return c.AbortUnauthorized("Invalid token")
+	}
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid session ID", err)
+	}
+	session, err := s.userRepo.GetSessionByID(c.Context(), sessionID)
+	if err != nil || session.UserID != userID {
+		return c.AbortNotFound("Session not found")
+	}
+	if err := s.userRepo.RevokeSession(c.Context(), session.ID); err != nil {
+		return c.AbortInternalServerError("Failed to revoke session", err)
+	}
+	recordAudit(c, s.userRepo, userID, models.AuditActionRevokeSession, "session", session.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// RevokeAllSessions signs the user out everywhere by revoking all of their sessions
+func (s *AuthService) RevokeAllSessions(c *okapi.Context) error {
+	userID, err := uuid.Parse(c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		return c.AbortUnauthorized("Invalid token")
+	}
+	if err := s.userRepo.RevokeAllUserSessions(c.Context(), userID); err != nil {
+		return c.AbortInternalServerError("Failed to revoke sessions", err)
+	}
+	recordAudit(c, s.userRepo, userID, models.AuditActionRevokeSession, "session", "*", models.AuditStatusSuccess, nil)
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// Me returns the signed-in user
+func (s *AuthService) Me(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(user)
+}
+
+// UpdateMe changes the name, username or avatar of the signed-in user
+func (s *AuthService) UpdateMe(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.UpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+
+	changes := models.JSONB{}
+	if req.Name != nil {
+		user.Name = strings.TrimSpace(*req.Name)
+		changes["name"] = user.Name
+	}
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" {
+			return c.AbortBadRequest("Username cannot be empty")
+		}
+		if username != user.Username {
+			exists, err := s.userRepo.ExistsByUsername(c.Context(), username)
+			if err != nil {
+				return c.AbortInternalServerError("Failed to update profile", err)
+			}
+			if exists {
+				return c.AbortConflict("Username already in use: " + username)
+			}
+			user.Username = username
+			changes["username"] = username
+		}
+	}
+	if req.Avatar != nil {
+		user.Avatar = strings.TrimSpace(*req.Avatar)
+		changes["avatar"] = user.Avatar
+	}
+
+	if err := s.userRepo.Update(c.Context(), user); err != nil {
+		return c.AbortInternalServerError("Failed to update profile", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionUpdateProfile, "user", user.ID.String(), models.AuditStatusSuccess, changes)
+
+	return c.OK(user)
+}
+
+// ChangePassword changes the password of the signed-in user and revokes their other sessions
+func (s *AuthService) ChangePassword(c *okapi.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if user.AuthProvider != models.AuthProviderLocal {
+		return c.AbortBadRequest("Your password is managed by your identity provider")
+	}
+	if !user.CheckPassword(req.CurrentPassword) {
+		recordAudit(c, s.userRepo, user.ID, models.AuditActionPasswordChange, "user", user.ID.String(), models.AuditStatusFailure,
+			models.JSONB{"reason": "invalid_password"})
+		return c.AbortBadRequest("Current password is incorrect")
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return c.AbortBadRequest("New password must be different from the current password")
+	}
+	if err := validatePassword(&s.conf.Auth.PasswordPolicy, req.NewPassword); err != nil {
+		return c.AbortBadRequest("Invalid password", err)
+	}
+
+	if err := user.SetPassword(req.NewPassword); err != nil {
+		return c.AbortInternalServerError("Failed to hash password", err)
+	}
+	if err := s.userRepo.UpdatePassword(c.Context(), user.ID, user.Password, false); err != nil {
+		return c.AbortInternalServerError("Failed to change password", err)
+	}
+	if sessionID, err := uuid.Parse(c.GetString(middlewares.SessionIDKey)); err == nil {
+		if err := s.userRepo.RevokeOtherUserSessions(c.Context(), user.ID, sessionID); err != nil {
+			logger.Error("Failed to revoke sessions after password change", "user", user.ID, "error", err)
+		}
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionPasswordChange, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"method": "self"})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// ForgotPassword emails a single-use password reset link to the user.
+// The response is the same whether or not the account exists.
+func (s *AuthService) ForgotPassword(c *okapi.Context) error {
+	if !s.conf.Auth.LocalLoginEnabled {
+		return c.AbortForbidden("Password login is disabled")
+	}
+	var req dto.ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	response := okapi.M{"message": "If the account exists, a password reset link has been sent"}
+
+	user, err := s.userRepo.GetByEmail(c.Context(), strings.TrimSpace(req.Email))
+	if err != nil || !user.Active || user.ServiceAccount || user.AuthProvider != models.AuthProviderLocal {
+		return c.OK(response)
+	}
+	token, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to generate reset token", err)
+	}
+	resetToken := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: util.HashToken(token),
+		IPAddress: c.RealIP(),
+		ExpiresAt: time.Now().Add(s.conf.Auth.PasswordResetTTL),
+	}
+	if err := s.userRepo.CreatePasswordResetToken(c.Context(), resetToken); err != nil {
+		return c.AbortInternalServerError("Failed to create reset token", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionPasswordForgot, "user", user.ID.String(), models.AuditStatusSuccess, nil)
+
+	// Send in the background so the response time does not reveal whether the account exists
+	msg := passwordResetMessage(user, s.conf.Server.AppURL+"/auth/reset-password?token="+token, s.conf.Auth.PasswordResetTTL)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			logger.Error("Failed to send password reset email", "user", user.ID, "error", err)
+		}
+	}()
+
+	return c.OK(response)
+}
+
+// ResetPassword consumes a password reset token, sets the new password and signs the user out everywhere
+func (s *AuthService) ResetPassword(c *okapi.Context) error {
+	if !s.conf.Auth.LocalLoginEnabled {
+		return c.AbortForbidden("Password login is disabled")
+	}
+	var req dto.ResetPasswordWithTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if err := validatePassword(&s.conf.Auth.PasswordPolicy, req.Password); err != nil {
+		return c.AbortBadRequest("Invalid password", err)
+	}
+
+	resetToken, err := s.userRepo.GetPasswordResetToken(c.Context(), util.HashToken(req.Token))
+	if err != nil || !resetToken.IsValid() || !resetToken.User.Active {
+		return c.AbortBadRequest("Invalid or expired reset token")
+	}
+
+	user := &resetToken.User
+	if err := user.SetPassword(req.Password); err != nil {
+		return c.AbortInternalServerError("Failed to hash password", err)
+	}
+	if err := s.userRepo.ResetPasswordWithToken(c.Context(), resetToken, user.Password); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return c.AbortBadRequest("Invalid or expired reset token")
+		}
+		return c.AbortInternalServerError("Failed to reset password", err)
+	}
+	if err := s.userRepo.RevokeAllUserSessions(c.Context(), user.ID); err != nil {
+		logger.Error("Failed to revoke sessions after password reset", "user", user.ID, "error", err)
+	}
+	// Proving ownership of the email address lifts an ongoing lockout
+	if err := s.userRepo.UnlockAccount(c.Context(), user.ID); err != nil {
+		logger.Warn("Failed to unlock account after password reset", "user", user.ID, "error", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionPasswordChange, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"method": "reset_token"})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// startSession creates a session for an authenticated user and responds with its tokens
+func (s *AuthService) startSession(c *okapi.Context, user *models.User, details models.JSONB) error {
+	response, err := s.createSession(c, user, details)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(response)
+}
+
+// createSession creates a session for an authenticated user and returns its tokens
+func (s *AuthService) createSession(c *okapi.Context, user *models.User, details models.JSONB) (*dto.AuthResponse, error) {
+	session := &models.UserSession{
+		UserID:    user.ID,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Header("User-Agent"),
+	}
+	response, err := s.issueTokens(user, session)
+	if err != nil {
+		return nil, errInternal("Failed to issue tokens", err)
+	}
+	// Users of external identity providers are expected to use the MFA of their provider
+	if !user.MFAEnabled && !user.HasExternalMFA() {
+		required, err := s.permissionRepo.RequiresMFA(c.Context(), user.Role)
+		if err != nil {
+			return nil, errInternal("Failed to check MFA policy", err)
+		}
+		response.User.MFAEnrollmentRequired = required
+	}
+	if err := s.userRepo.CreateSession(c.Context(), session); err != nil {
+		return nil, errInternal("Failed to create session", err)
+	}
+	if err := s.userRepo.UpdateLastLogin(c.Context(), user.ID, c.RealIP()); err != nil {
+		logger.Warn("Failed to update last login", "user", user.ID, "error", err)
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionLogin, "session", session.ID.String(), models.AuditStatusSuccess, details)
+
+	return response, nil
+}
+
+// registerFailedLogin increments the failed login counter and locks the account once the configured threshold
+// is reached. The decision uses the count returned by the database, so that concurrent attempts cannot skip it.
+func (s *AuthService) registerFailedLogin(c *okapi.Context, user *models.User, reason string) {
+	failedLogins, err := s.userRepo.IncrementFailedLogins(c.Context(), user.ID)
+	if err != nil {
+		logger.Error("Failed to increment failed logins", "user", user.ID, "error", err)
+		user.IncrementFailedLogins()
+		failedLogins = user.FailedLogins
+	}
+	user.FailedLogins = failedLogins
+
+	details := models.JSONB{"reason": reason, "failedLogins": user.FailedLogins}
+	if user.FailedLogins >= s.conf.Auth.MaxFailedLogins {
+		duration := s.lockoutDuration(user.FailedLogins)
+		if err := s.userRepo.LockAccount(c.Context(), user.ID, duration); err != nil {
+			logger.Error("Failed to lock account", "user", user.ID, "error", err)
+		} else {
+			logger.Warn("Account locked after too many failed login attempts", "user", user.ID, "duration", duration)
+			details["lockedFor"] = duration.String()
+		}
+	}
+	recordAudit(c, s.userRepo, user.ID, models.AuditActionLoginFailed, "user", user.ID.String(), models.AuditStatusFailure, details)
+}
+
+// lockoutDuration returns the progressive lockout duration: the base duration is doubled
+// for every failed attempt past the threshold, each of them locking the account again, up to the configured maximum
+func (s *AuthService) lockoutDuration(failedLogins int) time.Duration {
+	duration := s.conf.Auth.LockoutDuration
+	for i := s.conf.Auth.MaxFailedLogins; i < failedLogins; i++ {
+		duration *= 2
+		if duration >= s.conf.Auth.MaxLockoutDuration {
+			return s.conf.Auth.MaxLockoutDuration
+		}
+	}
+	return min(duration, s.conf.Auth.MaxLockoutDuration)
+}
+
+// issueTokens signs a new access token bound to the session and generates a fresh refresh token.
+// The session is updated in place with the new token identifiers and expiry.
+func (s *AuthService) issueTokens(user *models.User, session *models.UserSession) (*dto.AuthResponse, error) {
+	if session.ID == uuid.Nil {
+		session.ID = uuid.New()
+	}
+	refreshToken, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	tokenID := uuid.NewString()
+	expiresAt := time.Now().Add(s.conf.JWT.AccessTokenTTL)
+	accessToken, err := okapi.GenerateJwtToken([]byte(s.conf.JWT.Secret), jwt.MapClaims{
+		"sub":   user.ID.String(),
+		"email": user.Email,
+		"role":  user.Role,
+		"sid":   session.ID.String(),
+		"jti":   tokenID,
+		"iss":   s.conf.JWT.Issuer,
+		"aud":   s.conf.JWT.Audience,
+	}, s.conf.JWT.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Token = tokenID
+	session.RefreshToken = util.HashToken(refreshToken)
+	session.ExpiresAt = time.Now().Add(s.conf.JWT.RefreshTokenTTL)
+
+	return &dto.AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.Unix(),
+		TokenType:    tokenTypeBearer,
+		User:         toUserResponse(user),
+	}, nil
+}
+
+func toUserResponse(user *models.User) dto.UserResponse {
+	return dto.UserResponse{
+		ID:                 user.ID.String(),
+		Email:              user.Email,
+		Name:               user.Name,
+		Roles:              user.Role,
+		MustChangePassword: user.MustChangePassword,
+		MFAEnabled:         user.MFAEnabled,
+	}
+}
+
+// currentUser loads the signed-in user
+func (s *AuthService) currentUser(c *okapi.Context) (*models.User, error) {
+	user, err := s.userRepo.GetByID(c.Context(), currentUserID(c))
+	if err != nil {
+		return nil, errNotFound("User not found", err)
+	}
+	return user, nil
+}
+
+func passwordResetMessage(user *models.User, link string, ttl time.Duration) mailer.Message {
+	name := user.Name
+	if name == "" {
+		name = user.Email
+	}
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Goma Admin password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"We received a request to reset your password. Use the link below to choose a new one:\n\n"+
+			"%s\n\n"+
+			"This link expires in %s and can only be used once. "+
+			"If you did not request a password reset, you can ignore this email.\n",
+			name, link, ttl),
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/ldap"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
+	"github.com/jkaninda/okapi"
+)
+
+// errInvalidCredentials is returned by authenticators when the login or the password is wrong
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator verifies the password of a login.
+// user is the existing account matching the login, nil when unknown; authenticators able to provision
+// accounts return a new user in that case. Errors other than errInvalidCredentials are service errors
+// shown to the user.
+type Authenticator interface {
+	Authenticate(c *okapi.Context, user *models.User, login, password string) (*models.User, error)
+}
+
+// newAuthenticators returns the enabled authenticators, keyed by the auth provider of the accounts they verify
+func newAuthenticators(conf *config.Config, userRepo *repository.UserRepository) map[string]Authenticator {
+	authenticators := make(map[string]Authenticator)
+	if conf.Auth.LocalLoginEnabled {
+		authenticators[models.AuthProviderLocal] = localAuthenticator{}
+	}
+	if conf.LDAP.Enabled {
+		client, err := ldap.NewClient(&conf.LDAP)
+		if err != nil {
+			logger.Error("LDAP login is disabled", "error", err)
+		} else {
+			authenticators[models.AuthProviderLDAP] = &ldapAuthenticator{conf: &conf.LDAP, client: client, userRepo: userRepo}
+		}
+	}
+	return authenticators
+}
+
+// localAuthenticator checks the password hash stored in the database
+type localAuthenticator struct{}
+
+func (localAuthenticator) Authenticate(_ *okapi.Context, user *models.User, _, password string) (*models.User, error) {
+	if user == nil || !user.CheckPassword(password) {
+		return nil, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// ldapAuthenticator binds to the directory as the user and provisions the account on its first login
+type ldapAuthenticator struct {
+	conf     *config.LDAPConfig
+	client   *ldap.Client
+	userRepo *repository.UserRepository
+}
+
+func (a *ldapAuthenticator) Authenticate(c *okapi.Context, _ *models.User, login, password string) (*models.User, error) {
+	entry, err := a.client.Authenticate(login, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		logger.Error("LDAP authentication failed", "error", err)
+		return nil, newServiceError(http.StatusServiceUnavailable, "Directory is unavailable", nil)
+	}
+	return a.provision(c, entry)
+}
+
+// provision finds or creates the user of the directory entry and applies the role mapping
+func (a *ldapAuthenticator) provision(c *okapi.Context, entry *ldap.Entry) (*models.User, error) {
+	if entry.Disabled {
+		return nil, errForbidden("Your account is disabled")
+	}
+	if entry.Email == "" {
+		return nil, errForbidden("Your directory account has no email address")
+	}
+	role := mapGroupsToRole(entry.Groups, a.conf.RoleMapping, a.conf.DefaultRole)
+	if role == "" {
+		return nil, errForbidden("Your account is not allowed to access this dashboard")
+	}
+
+	user, err := a.userRepo.GetByExternalID(c.Context(), models.AuthProviderLDAP, entry.DN)
+	if err != nil {
+		// Directory logins never take over an account of another provider
+		exists, err := a.userRepo.ExistsByEmail(c.Context(), entry.Email)
+		if err != nil {
+			return nil, errInternal("Failed to sign in", err)
+		}
+		if exists {
+			return nil, errForbidden("An account with this email already exists")
+		}
+		if !a.conf.AllowSignup {
+			return nil, errForbidden("Your account has not been created yet, contact an administrator")
+		}
+		return a.createUser(c, entry, role)
+	}
+
+	user.Email = entry.Email
+	if entry.Name != "" {
+		user.Name = entry.Name
+	}
+	// Roles are only managed by the directory once groups are mapped
+	if len(a.conf.RoleMapping) > 0 && role != user.Role {
+		logger.Info("Updating role from directory groups", "user", user.ID, "from", user.Role, "to", role)
+		user.Role = role
+	}
+	if err := a.userRepo.Update(c.Context(), user); err != nil {
+		return nil, errInternal("Failed to update your account", err)
+	}
+	return user, nil
+}
+
+// createUser provisions a directory user on their first login
+func (a *ldapAuthenticator) createUser(c *okapi.Context, entry *ldap.Entry, role string) (*models.User, error) {
+	username, err := availableUsername(c.Context(), a.userRepo, entry.Username, entry.Email)
+	if err != nil {
+		return nil, errInternal("Failed to create your account", err)
+	}
+	// The password is never used, directory users are always verified against the directory
+	password, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, errInternal("Failed to create your account", err)
+	}
+	user := &models.User{
+		Email:        entry.Email,
+		Name:         entry.Name,
+		Username:     username,
+		Role:         role,
+		Active:       true,
+		AuthProvider: models.AuthProviderLDAP,
+		ExternalID:   entry.DN,
+		Metadata:     models.JSONB{"created_by": models.AuthProviderLDAP},
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, errInternal("Failed to create your account", err)
+	}
+	if err := a.userRepo.Create(c.Context(), user); err != nil {
+		return nil, errInternal("Failed to create your account", err)
+	}
+	recordAudit(c, a.userRepo, user.ID, models.AuditActionCreateUser, "user", user.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"provider": models.AuthProviderLDAP, "email": user.Email, "role": user.Role})
+
+	return user, nil
+}
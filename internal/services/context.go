@@ -0,0 +1,42 @@
+package services
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	"github.com/jkaninda/okapi"
+)
+
+// currentUserID returns the ID of the authenticated user, or uuid.Nil when unknown
+func currentUserID(c *okapi.Context) uuid.UUID {
+	id, err := uuid.Parse(c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// currentRole returns the role of the authenticated user
+func currentRole(c *okapi.Context) models.UserRole {
+	return models.UserRole(c.GetString(middlewares.RoleKey))
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pagination reads the page and page_size query parameters, falling back to sane defaults
+func pagination(c *okapi.Context) (page, pageSize int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err = strconv.Atoi(c.Query("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	return page, min(pageSize, maxPageSize)
+}
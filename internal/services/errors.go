@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jkaninda/okapi"
+)
+
+// serviceError carries the HTTP status a handler should answer with.
+// Helpers return it so handlers can write the response in one place.
+type serviceError struct {
+	code    int
+	message string
+	err     error
+}
+
+func (e *serviceError) Error() string {
+	return e.message
+}
+
+func (e *serviceError) Unwrap() error {
+	return e.err
+}
+
+func newServiceError(code int, message string, err error) *serviceError {
+	return &serviceError{code: code, message: message, err: err}
+}
+
+func errBadRequest(message string, err error) error {
+	return newServiceError(http.StatusBadRequest, message, err)
+}
+
+func errNotFound(message string, err error) error {
+	return newServiceError(http.StatusNotFound, message, err)
+}
+
+func errForbidden(message string) error {
+	return newServiceError(http.StatusForbidden, message, nil)
+}
+
+func errInternal(message string, err error) error {
+	return newServiceError(http.StatusInternalServerError, message, err)
+}
+
+// validationError carries the field-level errors of a request, answered with 422 Unprocessable Entity
+type validationError struct {
+	message string
+	fields  []okapi.ValidationError
+}
+
+func (e *validationError) Error() string {
+	return e.message
+}
+
+// fieldErrors collects the field-level errors found while validating a request
+type fieldErrors []okapi.ValidationError
+
+func (f *fieldErrors) add(field, message string, value any) {
+	*f = append(*f, okapi.ValidationError{Field: field, Message: message, Value: value})
+}
+
+// err returns the collected errors as a validationError, or nil when there are none
+func (f fieldErrors) err(message string) error {
+	if len(f) == 0 {
+		return nil
+	}
+	return &validationError{message: message, fields: f}
+}
+
+// abort writes the error response matching err, defaulting to 500 Internal Server Error
+func abort(c *okapi.Context, err error) error {
+	var ve *validationError
+	if errors.As(err, &ve) {
+		return c.AbortValidationErrors(ve.fields, ve.message)
+	}
+	var se *serviceError
+	if !errors.As(err, &se) {
+		se = newServiceError(http.StatusInternalServerError, "Internal server error", err)
+	}
+	details := ""
+	if se.err != nil {
+		details = se.err.Error()
+	}
+	return c.JSON(se.code, okapi.ErrorResponse{
+		Code:      se.code,
+		Message:   se.message,
+		Details:   details,
+		Timestamp: time.Now(),
+	})
+}
@@ -1,21 +1,560 @@
 package services
 
-import "github.com/jkaninda/okapi"
+import (
+	"strconv"
+	"time"
 
-type InstanceService struct{}
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/access"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	"github.com/jkaninda/goma-admin/internal/provider"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/okapi"
+)
 
-func (s InstanceService) List(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+// validEnvironments lists the environments an instance can belong to
+var validEnvironments = map[models.InstanceEnvironment]bool{
+	models.EnvironmentDevelopment: true,
+	models.EnvironmentStaging:     true,
+	models.EnvironmentProduction:  true,
+	models.EnvironmentTesting:     true,
 }
-func (s InstanceService) Create(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+type InstanceService struct {
+	instanceRepo   *repository.InstanceRepository
+	routeRepo      *repository.RouteRepository
+	userRepo       *repository.UserRepository
+	credentialRepo *repository.InstanceCredentialRepository
+	reportRepo     *repository.InstanceReportRepository
+	enrollmentRepo *repository.EnrollmentTokenRepository
+	renderer       *provider.Renderer
+	access         *access.Checker
+	gracePeriod    time.Duration
+}
+
+func NewInstanceService(conf *config.Config) *InstanceService {
+	return &InstanceService{
+		instanceRepo:   repository.NewInstanceRepository(conf.Database.DB),
+		routeRepo:      repository.NewRouteRepository(conf.Database.DB),
+		userRepo:       repository.NewUserRepository(conf.Database.DB),
+		credentialRepo: repository.NewInstanceCredentialRepository(conf.Database.DB),
+		reportRepo:     repository.NewInstanceReportRepository(conf.Database.DB),
+		enrollmentRepo: repository.NewEnrollmentTokenRepository(conf.Database.DB),
+		renderer:       provider.NewRenderer(conf.Database.DB, conf.Secrets.Cipher),
+		access:         access.NewChecker(conf.Database.DB),
+		gracePeriod:    conf.Provider.CredentialGracePeriod,
+	}
+}
+
+func (s *InstanceService) List(c *okapi.Context) error {
+	var (
+		instances []models.Instance
+		err       error
+	)
+	if c.Query("pending") == "true" {
+		instances, err = s.instanceRepo.ListPending(c.Context())
+	} else if env := c.Query("environment"); env != "" {
+		instances, err = s.instanceRepo.ListByEnvironment(c.Context(), env)
+	} else {
+		instances, err = s.instanceRepo.List(c.Context())
+	}
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list instances", err)
+	}
+	return c.OK(instances)
+}
+
+func (s *InstanceService) Create(c *okapi.Context) error {
+	var req dto.InstanceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !validEnvironments[models.InstanceEnvironment(req.Environment)] {
+		return c.AbortBadRequest("Invalid environment: " + req.Environment)
+	}
+
+	instance := &models.Instance{Enabled: true}
+	applyInstanceRequest(instance, &req)
+	if err := s.authorize(c, instance); err != nil {
+		return abort(c, err)
+	}
+	exists, err := s.instanceRepo.Exists(c.Context(), instance.Name)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create instance", err)
+	}
+	if exists {
+		return c.AbortConflict("Instance already exists: " + instance.Name)
+	}
+	if err := s.instanceRepo.Create(c.Context(), instance); err != nil {
+		return c.AbortInternalServerError("Failed to create instance", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": instance.Name, "environment": instance.Environment})
+
+	return c.Created(instance)
+}
+
+func (s *InstanceService) Get(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(instance)
+}
+
+func (s *InstanceService) Update(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.InstanceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !validEnvironments[models.InstanceEnvironment(req.Environment)] {
+		return c.AbortBadRequest("Invalid environment: " + req.Environment)
+	}
+	if req.Name != instance.Name {
+		exists, err := s.instanceRepo.Exists(c.Context(), req.Name)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to update instance", err)
+		}
+		if exists {
+			return c.AbortConflict("Instance already exists: " + req.Name)
+		}
+	}
+
+	applyInstanceRequest(instance, &req)
+	// The current environment is checked by the route permission, check the target one as well
+	if err := s.authorize(c, instance); err != nil {
+		return abort(c, err)
+	}
+	if err := s.instanceRepo.Update(c.Context(), instance); err != nil {
+		return c.AbortInternalServerError("Failed to update instance", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": instance.Name, "environment": instance.Environment})
+
+	return c.OK(instance)
+}
+
+func (s *InstanceService) Delete(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if err := s.instanceRepo.Delete(c.Context(), instance.ID); err != nil {
+		return c.AbortInternalServerError("Failed to delete instance", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeleteInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": instance.Name})
+
+	return c.OK(okapi.M{"status": "ok"})
 }
-func (s InstanceService) Get(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Approve lets an instance that registered itself with an enrollment token be served its configuration
+func (s *InstanceService) Approve(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if !instance.Pending {
+		return c.AbortConflict("Instance is not pending approval")
+	}
+	if err := s.instanceRepo.Approve(c.Context(), instance.ID); err != nil {
+		return c.AbortInternalServerError("Failed to approve instance", err)
+	}
+	instance.Pending = false
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionApproveInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": instance.Name, "environment": instance.Environment})
+
+	return c.OK(instance)
+}
+
+// Routes lists the routes attached to an instance
+func (s *InstanceService) Routes(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	routes, err := s.instanceRepo.GetRoutesByInstance(c.Context(), instance.ID)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list instance routes", err)
+	}
+	return c.OK(routeResponses(routes))
+}
+
+// AttachRoute attaches a route to an instance, optionally overriding its enabled flag and priority
+func (s *InstanceService) AttachRoute(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.AttachRouteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	route, err := s.routeRepo.GetByID(c.Context(), req.RouteID)
+	if err != nil {
+		return c.AbortNotFound("Route not found", err)
+	}
+
+	options := &models.InstanceRoute{
+		Enabled:    true,
+		Priority:   req.Priority,
+		DeployedBy: c.GetString(middlewares.EmailKey),
+	}
+	if req.Enabled != nil {
+		options.Enabled = *req.Enabled
+	}
+	if err := s.instanceRepo.AttachRoute(c.Context(), instance.ID, route.ID, options); err != nil {
+		return c.AbortInternalServerError("Failed to attach route", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionAttachRoute, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"route": route.Name})
+
+	return c.OK(okapi.M{"status": "ok"})
 }
-func (s InstanceService) Update(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// DetachRoute removes a route from an instance
+func (s *InstanceService) DetachRoute(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	routeID, err := strconv.ParseUint(c.Param("routeId"), 10, 64)
+	if err != nil {
+		return c.AbortBadRequest("Invalid route ID", err)
+	}
+	if err := s.instanceRepo.DetachRoute(c.Context(), instance.ID, uint(routeID)); err != nil {
+		return c.AbortNotFound("Route not attached to instance", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDetachRoute, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"routeId": routeID})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// Deploy replaces the set of routes served by an instance
+func (s *InstanceService) Deploy(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.DeployRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	for _, routeID := range req.RouteIDs {
+		if _, err := s.routeRepo.GetByID(c.Context(), routeID); err != nil {
+			return c.AbortNotFound("Route not found: "+strconv.FormatUint(uint64(routeID), 10), err)
+		}
+	}
+
+	options := &models.InstanceRoute{
+		DeployedBy:    c.GetString(middlewares.EmailKey),
+		ConfigVersion: req.ConfigVersion,
+	}
+	if err := s.instanceRepo.SyncRoutes(c.Context(), instance.ID, req.RouteIDs, options); err != nil {
+		return c.AbortInternalServerError("Failed to deploy instance", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeployInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"routeIds": req.RouteIDs, "configVersion": req.ConfigVersion})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// Reports lists the health and configuration reports sent by an instance, newest first
+func (s *InstanceService) Reports(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	page, pageSize := pagination(c)
+	reports, total, err := s.reportRepo.ListByInstance(c.Context(), instance.ID, page, pageSize)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list instance reports", err)
+	}
+	return c.OK(dto.InstanceReportListResponse{
+		Reports:  reports,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
 }
-func (s InstanceService) Delete(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Drift compares the configuration currently published to an instance with the one it last reported running
+func (s *InstanceService) Drift(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	snapshot, err := s.renderer.Snapshot(c.Context(), instance)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to render configuration", err)
+	}
+	response := dto.InstanceDriftResponse{
+		PublishedConfigVersion: snapshot.Version,
+		AppliedConfigVersion:   instance.AppliedConfigVersion,
+		InSync:                 instance.AppliedConfigVersion == snapshot.Version,
+		LastSeen:               instance.LastSeen,
+	}
+	// Instances that never reported have no last report
+	if report, err := s.reportRepo.GetLatest(c.Context(), instance.ID); err == nil {
+		response.LastReport = report
+	}
+	return c.OK(response)
+}
+
+// Credentials lists the credentials of an instance, secrets are never returned
+func (s *InstanceService) Credentials(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	credentials, err := s.credentialRepo.ListByInstance(c.Context(), instance.ID)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list instance credentials", err)
+	}
+	return c.OK(credentials)
+}
+
+// RotateCredential creates a credential for an instance. The previous credentials keep working
+// for the grace period, so that the instance can be reconfigured without downtime.
+func (s *InstanceService) RotateCredential(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.RotateInstanceCredentialRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	gracePeriod := s.gracePeriod
+	if req.GracePeriod != "" {
+		if gracePeriod, err = time.ParseDuration(req.GracePeriod); err != nil || gracePeriod < 0 {
+			return c.AbortBadRequest("Invalid grace period: "+req.GracePeriod, err)
+		}
+	}
+
+	credential, plain, err := newInstanceCredential(currentUserID(c))
+	if err != nil {
+		return abort(c, err)
+	}
+	credential.InstanceID = instance.ID
+	if err := s.credentialRepo.Rotate(c.Context(), credential, time.Now().Add(gracePeriod)); err != nil {
+		return c.AbortInternalServerError("Failed to rotate instance credential", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionRotateInstanceCredential, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"credential": credential.ID.String(), "gracePeriod": gracePeriod.String()})
+
+	return c.Created(dto.RotateInstanceCredentialResponse{Secret: plain, Credential: *credential})
+}
+
+// RevokeCredential immediately revokes a credential of an instance
+func (s *InstanceService) RevokeCredential(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	credentialID, err := uuid.Parse(c.Param("credentialId"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid credential ID", err)
+	}
+	if err := s.credentialRepo.Revoke(c.Context(), instance.ID, credentialID); err != nil {
+		return c.AbortNotFound("Instance credential not found or already revoked", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionRevokeInstanceCredential, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"credential": credentialID.String()})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// PinClientCertificate requires the instance to present the given client certificate on the provider endpoints
+func (s *InstanceService) PinClientCertificate(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.ClientCertificateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	cert, err := middlewares.ParseCertificate(req.Certificate)
+	if err != nil {
+		return c.AbortBadRequest("Invalid client certificate", err)
+	}
+	instance.ClientCertFingerprint = middlewares.CertificateFingerprint(cert)
+	if err := s.instanceRepo.SetClientCertFingerprint(c.Context(), instance.ID, instance.ClientCertFingerprint); err != nil {
+		return c.AbortInternalServerError("Failed to pin client certificate", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionPinClientCertificate, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"fingerprint": instance.ClientCertFingerprint, "subject": cert.Subject.String()})
+
+	return c.OK(instance)
+}
+
+// UnpinClientCertificate lets the instance authenticate without a client certificate again
+func (s *InstanceService) UnpinClientCertificate(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	instance.ClientCertFingerprint = ""
+	if err := s.instanceRepo.SetClientCertFingerprint(c.Context(), instance.ID, ""); err != nil {
+		return c.AbortInternalServerError("Failed to unpin client certificate", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUnpinClientCertificate, "instance", instance.ID.String(), models.AuditStatusSuccess, nil)
+
+	return c.OK(instance)
+}
+
+// EnrollmentTokens lists the enrollment tokens of the environments the user may modify, values are never returned
+func (s *InstanceService) EnrollmentTokens(c *okapi.Context) error {
+	tokens, err := s.enrollmentRepo.List(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list enrollment tokens", err)
+	}
+	allowed := make(map[string]bool)
+	visible := make([]models.EnrollmentToken, 0, len(tokens))
+	for _, token := range tokens {
+		canModify, checked := allowed[token.Environment]
+		if !checked {
+			canModify, err = s.access.CanModifyInstance(c.Context(), currentRole(c), &models.Instance{Environment: token.Environment})
+			if err != nil {
+				return c.AbortInternalServerError("Failed to check permissions", err)
+			}
+			allowed[token.Environment] = canModify
+		}
+		if canModify {
+			visible = append(visible, token)
+		}
+	}
+	return c.OK(visible)
+}
+
+// CreateEnrollmentToken creates a token letting unknown instances of an environment register themselves
+func (s *InstanceService) CreateEnrollmentToken(c *okapi.Context) error {
+	var req dto.CreateEnrollmentTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !validEnvironments[models.InstanceEnvironment(req.Environment)] {
+		return c.AbortBadRequest("Invalid environment: " + req.Environment)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.AbortBadRequest("Expiry must be in the future")
+	}
+	// Registered instances belong to the environment, so does the right to create them
+	if err := s.authorize(c, &models.Instance{Environment: req.Environment}); err != nil {
+		return abort(c, err)
+	}
+
+	secret, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to generate enrollment token", err)
+	}
+	plain := models.EnrollmentTokenPrefix + secret
+	token := &models.EnrollmentToken{
+		Environment: req.Environment,
+		Description: req.Description,
+		Prefix:      plain[:apiTokenDisplayLength],
+		TokenHash:   util.HashToken(plain),
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if creator := currentUserID(c); creator != uuid.Nil {
+		token.CreatedBy = &creator
+	}
+	if err := s.enrollmentRepo.Create(c.Context(), token); err != nil {
+		return c.AbortInternalServerError("Failed to create enrollment token", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateEnrollmentToken, "enrollment_token", token.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"environment": token.Environment})
+
+	return c.Created(dto.CreateEnrollmentTokenResponse{Token: plain, EnrollmentToken: *token})
+}
+
+// RevokeEnrollmentToken revokes an enrollment token, instances registered with it are kept
+func (s *InstanceService) RevokeEnrollmentToken(c *okapi.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid enrollment token ID", err)
+	}
+	token, err := s.enrollmentRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return c.AbortNotFound("Enrollment token not found", err)
+	}
+	if err := s.authorize(c, &models.Instance{Environment: token.Environment}); err != nil {
+		return abort(c, err)
+	}
+	if err := s.enrollmentRepo.Revoke(c.Context(), token.ID); err != nil {
+		return c.AbortNotFound("Enrollment token not found or already revoked", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionRevokeEnrollmentToken, "enrollment_token", token.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"environment": token.Environment})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// getInstance loads the instance identified by the :id path parameter
+func (s *InstanceService) getInstance(c *okapi.Context) (*models.Instance, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, errBadRequest("Invalid instance ID", err)
+	}
+	instance, err := s.instanceRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return nil, errNotFound("Instance not found", err)
+	}
+	return instance, nil
+}
+
+// authorize checks that the signed-in user has a permission grant covering the instance
+func (s *InstanceService) authorize(c *okapi.Context, instance *models.Instance) error {
+	allowed, err := s.access.CanModifyInstance(c.Context(), currentRole(c), instance)
+	if err != nil {
+		return errInternal("Failed to check permissions", err)
+	}
+	if !allowed {
+		return errForbidden("Changes to the " + instance.Environment + " environment require an admin")
+	}
+	return nil
+}
+
+// newInstanceCredential generates a credential, returned along with its secret in clear text.
+// The caller sets the instance it belongs to.
+func newInstanceCredential(createdBy uuid.UUID) (*models.InstanceCredential, string, error) {
+	secret, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", errInternal("Failed to generate instance credential", err)
+	}
+	plain := models.InstanceCredentialPrefix + secret
+	credential := &models.InstanceCredential{
+		Prefix:     plain[:apiTokenDisplayLength],
+		SecretHash: util.HashToken(plain),
+		SigningKey: util.SigningKey(plain),
+	}
+	if createdBy != uuid.Nil {
+		credential.CreatedBy = &createdBy
+	}
+	return credential, plain, nil
+}
+
+func applyInstanceRequest(instance *models.Instance, req *dto.InstanceRequest) {
+	instance.Name = req.Name
+	instance.Environment = req.Environment
+	instance.Description = req.Description
+	instance.Endpoint = req.Endpoint
+	instance.MetricsEndpoint = req.MetricsEndpoint
+	instance.HealthEndpoint = req.HealthEndpoint
+	instance.Region = req.Region
+	instance.Tags = req.Tags
+	instance.Metadata = req.Metadata
+	if req.Enabled != nil {
+		instance.Enabled = *req.Enabled
+	}
 }
@@ -1,21 +1,354 @@
 package services
 
-import "github.com/jkaninda/okapi"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-type MiddlewareService struct{}
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/rules"
+	"github.com/jkaninda/goma-admin/internal/secrets"
+	"github.com/jkaninda/okapi"
+)
 
-func (m MiddlewareService) List(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+type MiddlewareService struct {
+	middlewareRepo *repository.MiddlewareRepository
+	userRepo       *repository.UserRepository
+	cipher         *secrets.Cipher
 }
-func (m MiddlewareService) Create(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+func NewMiddlewareService(conf *config.Config) *MiddlewareService {
+	return &MiddlewareService{
+		middlewareRepo: repository.NewMiddlewareRepository(conf.Database.DB),
+		userRepo:       repository.NewUserRepository(conf.Database.DB),
+		cipher:         conf.Secrets.Cipher,
+	}
+}
+
+// List lists middlewares page by page, optionally filtered by a search query and a type
+func (s *MiddlewareService) List(c *okapi.Context) error {
+	page, pageSize := pagination(c)
+	middlewares, total, err := s.middlewareRepo.ListFiltered(c.Context(), strings.TrimSpace(c.Query("search")), c.Query("type"), page, pageSize)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list middlewares", err)
+	}
+	response := dto.MiddlewareListResponse{
+		Middlewares: make([]dto.MiddlewareResponse, len(middlewares)),
+		Total:       total,
+		Page:        page,
+		PageSize:    pageSize,
+	}
+	for i := range middlewares {
+		response.Middlewares[i] = middlewareResponse(&middlewares[i])
+	}
+	return c.OK(response)
+}
+
+func (s *MiddlewareService) Create(c *okapi.Context) error {
+	var req dto.MiddlewareRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	var fields fieldErrors
+	validateMiddleware(&fields, "", &req)
+	if err := fields.err("Invalid middleware"); err != nil {
+		return abort(c, err)
+	}
+	if err := s.encryptRule(c.Context(), &req); err != nil {
+		return abort(c, err)
+	}
+	exists, err := s.middlewareRepo.Exists(c.Context(), req.Name)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create middleware", err)
+	}
+	if exists {
+		return c.AbortConflict("Middleware already exists: " + req.Name)
+	}
+
+	middleware := &models.Middleware{}
+	applyMiddlewareRequest(middleware, &req)
+	if err := s.middlewareRepo.Create(c.Context(), middleware); err != nil {
+		return abort(c, middlewareWriteError("Failed to create middleware", err))
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateMiddleware, "middleware", strconv.FormatUint(uint64(middleware.ID), 10), models.AuditStatusSuccess,
+		models.JSONB{"name": middleware.Name, "type": middleware.Type})
+
+	return c.Created(middlewareResponse(middleware))
+}
+
+// CreateBatch creates several middlewares at once, none being created if any of them is refused
+func (s *MiddlewareService) CreateBatch(c *okapi.Context) error {
+	var batch dto.MiddlewareBatchRequest
+	if err := c.Bind(&batch); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	req := batch.Middlewares
+	if len(req) == 0 {
+		return c.AbortBadRequest("At least one middleware is required")
+	}
+	names := make([]string, len(req))
+	seen := make(map[string]bool, len(req))
+	var fields fieldErrors
+	for i := range req {
+		if req[i].Name == "" || req[i].Type == "" {
+			return c.AbortBadRequest("Middlewares require a name and a type")
+		}
+		if seen[req[i].Name] {
+			return c.AbortBadRequest("Middleware listed more than once: " + req[i].Name)
+		}
+		seen[req[i].Name] = true
+		names[i] = req[i].Name
+		validateMiddleware(&fields, fmt.Sprintf("middlewares[%d].", i), &req[i])
+	}
+	if err := fields.err("Invalid middlewares"); err != nil {
+		return abort(c, err)
+	}
+	existing, err := s.middlewareRepo.ExistsByNames(c.Context(), names)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create middlewares", err)
+	}
+	for _, name := range names {
+		if existing[name] {
+			return c.AbortConflict("Middleware already exists: " + name)
+		}
+	}
+
+	middlewares := make([]models.Middleware, len(req))
+	for i := range req {
+		if err := s.encryptRule(c.Context(), &req[i]); err != nil {
+			return abort(c, err)
+		}
+		applyMiddlewareRequest(&middlewares[i], &req[i])
+	}
+	if err := s.middlewareRepo.CreateBatch(c.Context(), middlewares); err != nil {
+		return abort(c, middlewareWriteError("Failed to create middlewares", err))
+	}
+	response := make([]dto.MiddlewareResponse, len(middlewares))
+	for i := range middlewares {
+		response[i] = middlewareResponse(&middlewares[i])
+		recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateMiddleware, "middleware", strconv.FormatUint(uint64(middlewares[i].ID), 10), models.AuditStatusSuccess,
+			models.JSONB{"name": middlewares[i].Name, "type": middlewares[i].Type})
+	}
+
+	return c.Created(response)
+}
+
+func (s *MiddlewareService) Get(c *okapi.Context) error {
+	middleware, err := s.getMiddleware(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(middlewareResponse(middleware))
+}
+
+// Update replaces a middleware. Renaming it updates the routes using it.
+// Secret fields sent back redacted keep their stored value.
+func (s *MiddlewareService) Update(c *okapi.Context) error {
+	middleware, err := s.getMiddleware(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.MiddlewareRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if err := s.keepSecrets(c.Context(), &req, middleware); err != nil {
+		return abort(c, err)
+	}
+	var fields fieldErrors
+	validateMiddleware(&fields, "", &req)
+	if err := fields.err("Invalid middleware"); err != nil {
+		return abort(c, err)
+	}
+	if err := s.encryptRule(c.Context(), &req); err != nil {
+		return abort(c, err)
+	}
+	previousName := middleware.Name
+	if req.Name != previousName {
+		exists, err := s.middlewareRepo.Exists(c.Context(), req.Name)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to update middleware", err)
+		}
+		if exists {
+			return c.AbortConflict("Middleware already exists: " + req.Name)
+		}
+	}
+
+	applyMiddlewareRequest(middleware, &req)
+	if err := s.middlewareRepo.Update(c.Context(), middleware); err != nil {
+		return abort(c, middlewareWriteError("Failed to update middleware", err))
+	}
+	details := models.JSONB{"name": middleware.Name, "type": middleware.Type}
+	if previousName != middleware.Name {
+		details["previousName"] = previousName
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateMiddleware, "middleware", strconv.FormatUint(uint64(middleware.ID), 10), models.AuditStatusSuccess, details)
+
+	return c.OK(middlewareResponse(middleware))
 }
-func (m MiddlewareService) Get(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Delete deletes a middleware. Middlewares still used by routes are only deleted with ?force=true,
+// which removes them from those routes.
+func (s *MiddlewareService) Delete(c *okapi.Context) error {
+	middleware, err := s.getMiddleware(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	routes, err := s.middlewareRepo.Delete(c.Context(), middleware.ID, c.Query("force") == "true")
+	if errors.Is(err, repository.ErrConflict) {
+		return c.JSON(http.StatusConflict, dto.MiddlewareInUseResponse{
+			ErrorResponse: okapi.ErrorResponse{
+				Code:      http.StatusConflict,
+				Message:   "Middleware is used by routes, remove it from them or delete it with force=true",
+				Timestamp: time.Now(),
+			},
+			Routes: routeReferences(routes),
+		})
+	}
+	if err != nil {
+		return abort(c, middlewareWriteError("Failed to delete middleware", err))
+	}
+	details := models.JSONB{"name": middleware.Name}
+	if len(routes) > 0 {
+		details["removedFromRoutes"] = routeReferences(routes)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeleteMiddleware, "middleware", strconv.FormatUint(uint64(middleware.ID), 10), models.AuditStatusSuccess, details)
+
+	return c.OK(okapi.M{"status": "ok"})
 }
-func (m MiddlewareService) Update(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Routes lists the routes using a middleware
+func (s *MiddlewareService) Routes(c *okapi.Context) error {
+	middleware, err := s.getMiddleware(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	routes, err := s.middlewareRepo.GetRoutesByMiddleware(c.Context(), middleware.Name)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list middleware routes", err)
+	}
+	return c.OK(routeResponses(routes))
 }
-func (m MiddlewareService) Delete(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Stats returns middleware counts by type and the most used middlewares
+func (s *MiddlewareService) Stats(c *okapi.Context) error {
+	stats, err := s.middlewareRepo.GetMiddlewareStats(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to get middleware stats", err)
+	}
+	return c.OK(stats)
+}
+
+// Types lists the middleware types along with the JSON Schemas of their rules
+func (s *MiddlewareService) Types(c *okapi.Context) error {
+	return c.OK(rules.Types())
+}
+
+// getMiddleware loads the middleware identified by the :id path parameter
+func (s *MiddlewareService) getMiddleware(c *okapi.Context) (*models.Middleware, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, errBadRequest("Invalid middleware ID", err)
+	}
+	middleware, err := s.middlewareRepo.GetByID(c.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, errNotFound("Middleware not found", err)
+		}
+		return nil, errInternal("Failed to get middleware", err)
+	}
+	return middleware, nil
+}
+
+// encryptRule encrypts the secret fields of the rule of a validated request
+func (s *MiddlewareService) encryptRule(ctx context.Context, req *dto.MiddlewareRequest) error {
+	rule, err := rules.EncryptSecrets(ctx, s.cipher, req.Type, req.Rule)
+	if err != nil {
+		return errInternal("Failed to encrypt middleware secrets", err)
+	}
+	req.Rule = rule
+	return nil
+}
+
+// keepSecrets replaces the secret fields of an update left redacted with the values stored in the middleware.
+// Stored values only apply to a middleware of the same type, fields left redacted are refused by validateMiddleware.
+func (s *MiddlewareService) keepSecrets(ctx context.Context, req *dto.MiddlewareRequest, middleware *models.Middleware) error {
+	if req.Type != middleware.Type {
+		return nil
+	}
+	var stored map[string]any
+	for _, name := range rules.SecretFields(middleware.Type) {
+		if req.Rule[name] != rules.Redacted {
+			continue
+		}
+		if stored == nil {
+			var err error
+			if stored, err = rules.DecryptSecrets(ctx, s.cipher, middleware.Type, middleware.Rule); err != nil {
+				return errInternal("Failed to decrypt middleware secrets", err)
+			}
+		}
+		if value, ok := stored[name]; ok {
+			req.Rule[name] = value
+		}
+	}
+	return nil
+}
+
+// middlewareWriteError maps the errors of the middleware repository to responses
+func middlewareWriteError(message string, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return errNotFound("Middleware not found", err)
+	case errors.Is(err, repository.ErrDuplicate):
+		return newServiceError(http.StatusConflict, "Middleware already exists", err)
+	}
+	return errInternal(message, err)
+}
+
+// middlewareResponse returns a middleware with the secret fields of its rule redacted
+func middlewareResponse(middleware *models.Middleware) dto.MiddlewareResponse {
+	response := dto.MiddlewareResponse{ID: middleware.ID, Middleware: *middleware}
+	response.Rule = rules.RedactSecrets(middleware.Type, middleware.Rule)
+	return response
+}
+
+func routeReferences(routes []models.Route) []dto.RouteReference {
+	references := make([]dto.RouteReference, len(routes))
+	for i, route := range routes {
+		references[i] = dto.RouteReference{ID: route.ID, Name: route.Name, Path: route.Path}
+	}
+	return references
+}
+
+// validateMiddleware checks the rule of a middleware against the schema of its type,
+// the fields of the errors being prefixed with prefix
+func validateMiddleware(fields *fieldErrors, prefix string, req *dto.MiddlewareRequest) {
+	ruleErrors, err := rules.Validate(req.Type, req.Rule)
+	if err != nil {
+		fields.add(prefix+"type", rules.UnknownTypeMessage(), req.Type)
+		return
+	}
+	for _, e := range ruleErrors {
+		fields.add(prefix+e.Field, e.Message, e.Value)
+	}
+	// Redacted secrets left by keepSecrets have no stored value, they would be published as is
+	for _, name := range rules.SecretFields(req.Type) {
+		if req.Rule[name] == rules.Redacted {
+			fields.add(prefix+"rule."+name, "secret is redacted but no stored value exists", nil)
+		}
+	}
+}
+
+func applyMiddlewareRequest(middleware *models.Middleware, req *dto.MiddlewareRequest) {
+	middleware.Name = req.Name
+	middleware.Type = req.Type
+	middleware.Paths = req.Paths
+	middleware.Rule = req.Rule
 }
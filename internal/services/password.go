@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jkaninda/goma-admin/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared with the passwords of logins without an account, hashed with the cost of real passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("goma-admin-dummy-password"), bcrypt.DefaultCost)
+
+// validatePassword checks that a password satisfies the password policy
+func validatePassword(policy *config.PasswordPolicy, password string) error {
+	var missing []string
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		missing = append(missing, fmt.Sprintf("be at least %d characters long", policy.MinLength))
+	}
+	if policy.RequireUppercase && !strings.ContainsFunc(password, unicode.IsUpper) {
+		missing = append(missing, "contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !strings.ContainsFunc(password, unicode.IsLower) {
+		missing = append(missing, "contain a lowercase letter")
+	}
+	if policy.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		missing = append(missing, "contain a digit")
+	}
+	if policy.RequireSymbol && !strings.ContainsFunc(password, isSymbol) {
+		missing = append(missing, "contain a symbol")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("password must %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// compareDummyPassword spends the time of a password verification, for logins without an account
+func compareDummyPassword(password string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
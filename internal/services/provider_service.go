@@ -1,18 +1,383 @@
 package services
 
-import "github.com/jkaninda/okapi"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
-type ProviderService struct{}
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/events"
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	"github.com/jkaninda/goma-admin/internal/provider"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/logger"
+	"github.com/jkaninda/okapi"
+)
 
-func (s ProviderService) Provider(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+const (
+	// ConfigVersionHeader carries the content hash of the whole configuration of the instance
+	ConfigVersionHeader = "X-Goma-Config-Version"
+	// streamPingInterval keeps idle watch streams open through proxies
+	streamPingInterval = 30 * time.Second
+
+	// Bounds of the reports sent to the webhook
+	maxWebhookBodySize   = 256 << 10
+	maxReportBackends    = 1000
+	maxReportErrorCounts = 100
+	// maxVersionLength and maxConfigVersionLength are the sizes of the version columns of the reports
+	maxVersionLength       = 50
+	maxConfigVersionLength = 64
+)
+
+// instanceNamePattern restricts the names instances register themselves under, as they end up in URLs and logs
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// ProviderService implements the Goma Gateway HTTP provider endpoints polled by gateway instances
+type ProviderService struct {
+	instanceRepo      *repository.InstanceRepository
+	reportRepo        *repository.InstanceReportRepository
+	enrollmentRepo    *repository.EnrollmentTokenRepository
+	userRepo          *repository.UserRepository
+	cache             *provider.Cache
+	bus               *events.Bus
+	maxWait           time.Duration
+	enrollmentEnabled bool
+}
+
+func NewProviderService(conf *config.Config, bus *events.Bus) *ProviderService {
+	cache := provider.NewCache(provider.NewRenderer(conf.Database.DB, conf.Secrets.Cipher))
+	bus.Listen(cache.Invalidate)
+	return &ProviderService{
+		instanceRepo:      repository.NewInstanceRepository(conf.Database.DB),
+		reportRepo:        repository.NewInstanceReportRepository(conf.Database.DB),
+		enrollmentRepo:    repository.NewEnrollmentTokenRepository(conf.Database.DB),
+		userRepo:          repository.NewUserRepository(conf.Database.DB),
+		cache:             cache,
+		bus:               bus,
+		maxWait:           conf.Provider.MaxWait,
+		enrollmentEnabled: conf.Provider.EnrollmentEnabled,
+	}
+}
+
+// Provider returns the routes and middlewares of the instance
+func (s *ProviderService) Provider(c *okapi.Context) error {
+	return s.serve(c, func(snapshot *provider.Snapshot) (string, any) {
+		return snapshot.Version, snapshot.Config
+	})
+}
+
+// Routes returns the routes of the instance
+func (s *ProviderService) Routes(c *okapi.Context) error {
+	return s.serve(c, func(snapshot *provider.Snapshot) (string, any) {
+		return snapshot.RoutesVersion, okapi.M{"routes": snapshot.Config.Routes}
+	})
+}
+
+// Middlewares returns the middlewares referenced by the routes of the instance
+func (s *ProviderService) Middlewares(c *okapi.Context) error {
+	return s.serve(c, func(snapshot *provider.Snapshot) (string, any) {
+		return snapshot.MiddlewaresVersion, okapi.M{"middlewares": snapshot.Config.Middlewares}
+	})
 }
-func (s ProviderService) Routes(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Watch streams the configuration of the instance as Server-Sent Events, a config event being sent
+// on connection and on every change. The event ID is the configuration version, so that a client
+// reconnecting with Last-Event-ID is only sent a configuration it does not have.
+func (s *ProviderService) Watch(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	// Subscribe before rendering so that no change is missed in between
+	changes, unsubscribe := s.bus.Subscribe()
+	defer unsubscribe()
+
+	ctx := c.Context()
+	lastVersion := c.Header("Last-Event-ID")
+	messages := make(chan okapi.Message)
+	go func() {
+		defer close(messages)
+		for {
+			snapshot, err := s.cache.Get(ctx, instance)
+			if err != nil {
+				logger.Error("Failed to render configuration", "instance", instance.Name, "error", err)
+				return
+			}
+			if snapshot.Version != lastVersion {
+				lastVersion = snapshot.Version
+				message := okapi.Message{ID: snapshot.Version, Event: "config", Data: snapshot.Config, Serializer: okapi.JSONSerializer{}}
+				select {
+				case messages <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-changes:
+			case <-ctx.Done():
+				return
+			}
+			// The stream ends when the instance is disabled or deleted
+			if instance, err = s.reloadInstance(ctx, instance.ID); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = c.SSEStreamWithOptions(ctx, messages, &okapi.StreamOptions{PingInterval: streamPingInterval})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
 }
-func (s ProviderService) Middlewares(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Webhook records the health and configuration report of the instance, and tells it whether the
+// configuration it applied is the one currently published
+func (s *ProviderService) Webhook(c *okapi.Context) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	request := c.Request()
+	request.Body = http.MaxBytesReader(c.ResponseWriter(), request.Body, maxWebhookBodySize)
+	var req dto.WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !models.InstanceStatus(req.Status).IsValid() {
+		return c.AbortBadRequest("Invalid status: " + req.Status)
+	}
+	if len(req.Backends) > maxReportBackends || len(req.ErrorCounts) > maxReportErrorCounts {
+		return c.AbortBadRequest(fmt.Sprintf("Reports are limited to %d backends and %d error counts",
+			maxReportBackends, maxReportErrorCounts))
+	}
+	if len(req.Version) > maxVersionLength || len(req.ConfigVersion) > maxConfigVersionLength {
+		return c.AbortBadRequest("Invalid version or config_version")
+	}
+	snapshot, err := s.cache.Get(c.Context(), instance)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to render configuration", err)
+	}
+
+	report := &models.InstanceReport{
+		InstanceID:             instance.ID,
+		Status:                 req.Status,
+		Version:                req.Version,
+		AppliedConfigVersion:   req.ConfigVersion,
+		PublishedConfigVersion: snapshot.Version,
+		InSync:                 req.ConfigVersion == snapshot.Version,
+		Backends:               req.Backends,
+		ErrorCounts:            req.ErrorCounts,
+	}
+	if err := s.reportRepo.Create(c.Context(), report); err != nil {
+		return c.AbortInternalServerError("Failed to record instance report", err)
+	}
+	if !report.InSync && instance.AppliedConfigVersion != req.ConfigVersion {
+		logger.Warn("Instance runs a configuration other than the published one", "instance", instance.Name,
+			"applied", req.ConfigVersion, "published", snapshot.Version)
+	}
+
+	return c.OK(dto.WebhookResponse{ConfigVersion: snapshot.Version, InSync: report.InSync})
 }
-func (s ProviderService) Webhook(c *okapi.Context) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Register creates an unknown instance named :name, authenticated with an enrollment token of its environment.
+// The instance is pending until approved by an admin, and is returned a credential to use on the other endpoints.
+func (s *ProviderService) Register(c *okapi.Context) error {
+	if !s.enrollmentEnabled {
+		return c.AbortForbidden("Instance enrollment is disabled")
+	}
+	token, err := s.enrollmentToken(c)
+	if err != nil {
+		logger.Warn("Rejected instance registration", "instance", c.Param("name"), "ip", c.RealIP(), "error", err)
+		return c.AbortUnauthorized("Invalid enrollment token")
+	}
+	var req dto.RegisterInstanceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	name := c.Param("name")
+	if !instanceNamePattern.MatchString(name) {
+		return c.AbortBadRequest("Invalid instance name: names are 1 to 63 letters, digits, dots, dashes or underscores, starting with a letter or digit")
+	}
+
+	credential, plain, err := newInstanceCredential(uuid.Nil)
+	if err != nil {
+		return abort(c, err)
+	}
+	instance := &models.Instance{
+		Name:            name,
+		Environment:     token.Environment,
+		Endpoint:        req.Endpoint,
+		MetricsEndpoint: req.MetricsEndpoint,
+		HealthEndpoint:  req.HealthEndpoint,
+		Version:         req.Version,
+		Region:          req.Region,
+		Tags:            req.Tags,
+		Enabled:         true,
+		Metadata:        req.Metadata,
+	}
+	if err := s.instanceRepo.Register(c.Context(), instance, credential); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return c.AbortConflict("Instance already exists: " + name)
+		}
+		return c.AbortInternalServerError("Failed to register instance", err)
+	}
+	if err := s.enrollmentRepo.UpdateLastUsed(c.Context(), token.ID, c.RealIP()); err != nil {
+		logger.Warn("Failed to update enrollment token last use", "token", token.ID, "error", err)
+	}
+	recordAudit(c, s.userRepo, uuid.Nil, models.AuditActionRegisterInstance, "instance", instance.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": instance.Name, "environment": instance.Environment, "enrollmentToken": token.ID.String()})
+	logger.Info("Instance registered, pending approval", "instance", instance.Name, "environment", instance.Environment)
+
+	return c.Created(dto.RegisterInstanceResponse{Secret: plain, Instance: *instance, Credential: *credential})
+}
+
+// serve writes a view of the configuration of the instance. With ?wait= and an If-None-Match header
+// matching the current view, the request is held until the view changes or the wait is over.
+func (s *ProviderService) serve(c *okapi.Context, view func(*provider.Snapshot) (string, any)) error {
+	instance, err := s.getInstance(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	wait, err := s.waitDuration(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var changes <-chan repository.ConfigChange
+	if wait > 0 && c.Header("If-None-Match") != "" {
+		// Subscribe before rendering so that no change is missed in between
+		ch, unsubscribe := s.bus.Subscribe()
+		defer unsubscribe()
+		changes = ch
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		snapshot, err := s.cache.Get(c.Context(), instance)
+		if err != nil {
+			return abort(c, errInternal("Failed to render configuration", err))
+		}
+		hash, payload := view(snapshot)
+		if changes == nil || !etagMatches(c.Header("If-None-Match"), viewETag(c, hash)) {
+			return respondConfig(c, snapshot, hash, payload)
+		}
+		select {
+		case <-changes:
+			if instance, err = s.reloadInstance(c.Context(), instance.ID); err != nil {
+				return abort(c, err)
+			}
+		case <-timeout.C:
+			return respondConfig(c, snapshot, hash, payload)
+		case <-c.Context().Done():
+			return nil
+		}
+	}
+}
+
+// waitDuration parses the ?wait= long-polling duration, capped to the configured maximum
+func (s *ProviderService) waitDuration(c *okapi.Context) (time.Duration, error) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, errBadRequest("Invalid wait duration: "+value, err)
+	}
+	return min(wait, s.maxWait), nil
+}
+
+// getInstance returns the instance authenticated by the InstanceAuth middleware, if enabled
+func (s *ProviderService) getInstance(c *okapi.Context) (*models.Instance, error) {
+	value, _ := c.Get(middlewares.InstanceKey)
+	instance, ok := value.(*models.Instance)
+	if !ok {
+		return nil, newServiceError(http.StatusUnauthorized, "Invalid instance credentials", nil)
+	}
+	if !instance.Enabled {
+		return nil, errForbidden("Instance is disabled")
+	}
+	return instance, nil
+}
+
+// enrollmentToken returns the valid enrollment token the request carries as a bearer token
+func (s *ProviderService) enrollmentToken(c *okapi.Context) (*models.EnrollmentToken, error) {
+	plain, ok := strings.CutPrefix(c.Header("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(plain, models.EnrollmentTokenPrefix) {
+		return nil, errors.New("missing enrollment token")
+	}
+	token, err := s.enrollmentRepo.GetByHash(c.Context(), util.HashToken(plain))
+	if err != nil {
+		return nil, err
+	}
+	if !token.IsValid() {
+		return nil, errors.New("enrollment token revoked or expired")
+	}
+	return token, nil
+}
+
+// reloadInstance loads the instance again after a change, which may have been to its routes or itself
+func (s *ProviderService) reloadInstance(ctx context.Context, id uuid.UUID) (*models.Instance, error) {
+	instance, err := s.instanceRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errNotFound("Instance not found", err)
+	}
+	if !instance.Enabled {
+		return nil, errForbidden("Instance is disabled")
+	}
+	if instance.Pending {
+		return nil, errForbidden("Instance is pending approval")
+	}
+	return instance, nil
+}
+
+// respondConfig writes a view of the configuration identified by its content hash, as YAML when asked
+// with ?format=yaml or the Accept header, JSON otherwise.
+// Clients sending the ETag of the view in If-None-Match get 304 Not Modified.
+func respondConfig(c *okapi.Context, snapshot *provider.Snapshot, hash string, v any) error {
+	etag := viewETag(c, hash)
+	c.SetHeader("ETag", etag)
+	c.SetHeader(ConfigVersionHeader, snapshot.Version)
+	c.SetHeader("Cache-Control", "no-cache")
+	c.SetHeader("Vary", "Accept")
+	if etagMatches(c.Header("If-None-Match"), etag) {
+		c.WriteStatus(http.StatusNotModified)
+		return nil
+	}
+	if wantsYAML(c) {
+		return c.YAML(http.StatusOK, v)
+	}
+	return c.OK(v)
+}
+
+// viewETag returns the ETag of a view of the configuration in the format asked for
+func viewETag(c *okapi.Context, hash string) string {
+	if wantsYAML(c) {
+		return `"` + hash + `-yaml"`
+	}
+	return `"` + hash + `"`
+}
+
+func wantsYAML(c *okapi.Context) bool {
+	return c.Query("format") == "yaml" || strings.Contains(c.Header("Accept"), "yaml")
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/dto"
+)
+
+// restoreRouteKeys fills the keys a route request leaves out with those stored in the route, stored being nil
+// for a new route, since the API only returns their fingerprints:
+// TLS certificates without a key keep the key stored with the same certificate, and a security TLS configuration
+// without a client certificate and key keeps the stored ones. Empty client certificates and keys remove them.
+func restoreRouteKeys(req *dto.RouteRequest, stored *models.Route) {
+	if req.TLS != nil && stored != nil {
+		keys := make(map[string]string, len(stored.TLSCertificates))
+		for _, cert := range stored.TLSCertificates {
+			keys[cert.Cert] = cert.Key
+		}
+		for i := range req.TLS.Certificates {
+			if cert := &req.TLS.Certificates[i]; cert.Key == "" {
+				cert.Key = keys[cert.Cert]
+			}
+		}
+	}
+
+	if req.Security == nil || req.Security.TLS == nil {
+		return
+	}
+	tls := req.Security.TLS
+	switch {
+	case tls.ClientCert == nil && tls.ClientKey == nil:
+		if stored != nil && stored.Security != nil && stored.Security.TLS != nil {
+			tls.ClientCert, tls.ClientKey = stored.Security.TLS.ClientCert, stored.Security.TLS.ClientKey
+		}
+	case tls.ClientCert != nil && tls.ClientKey != nil && *tls.ClientCert == "" && *tls.ClientKey == "":
+		tls.ClientCert, tls.ClientKey = nil, nil
+	}
+}
+
+// routeResponse returns a route with its private keys and client certificate replaced by their fingerprints
+func routeResponse(route *models.Route) dto.RouteResponse {
+	response := dto.RouteResponse{Route: *route}
+	if route.TLS != nil {
+		response.TLS = &dto.TLSResponse{Certificates: make([]dto.TLSCertificateResponse, len(route.TLS.Certificates))}
+		for i, cert := range route.TLS.Certificates {
+			response.TLS.Certificates[i] = dto.TLSCertificateResponse{Cert: cert.Cert, KeyFingerprint: keyFingerprint(cert.Key)}
+		}
+	}
+	if sec := route.Security; sec != nil {
+		response.Security = &dto.SecurityResponse{
+			ForwardHostHeaders:      sec.ForwardHostHeaders,
+			EnableExploitProtection: sec.EnableExploitProtection,
+		}
+		if sec.TLS != nil {
+			response.Security.TLS = &dto.SecurityTLSResponse{
+				InsecureSkipVerify: sec.TLS.InsecureSkipVerify,
+				RootCAs:            sec.TLS.RootCAs,
+			}
+			if sec.TLS.ClientCert != nil {
+				response.Security.TLS.ClientCertFingerprint = certFingerprint(*sec.TLS.ClientCert)
+			}
+			if sec.TLS.ClientKey != nil {
+				response.Security.TLS.ClientKeyFingerprint = keyFingerprint(*sec.TLS.ClientKey)
+			}
+		}
+	}
+	return response
+}
+
+func routeResponses(routes []models.Route) []dto.RouteResponse {
+	responses := make([]dto.RouteResponse, len(routes))
+	for i := range routes {
+		responses[i] = routeResponse(&routes[i])
+	}
+	return responses
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a PEM encoded certificate
+func certFingerprint(certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return ""
+	}
+	return fingerprint(block.Bytes)
+}
+
+// keyFingerprint returns the SHA-256 fingerprint of the public key of a PEM encoded private key,
+// which matches the fingerprint of the public key of its certificate
+func keyFingerprint(keyPEM string) string {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return ""
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return ""
+	}
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return ""
+	}
+	return fingerprint(der)
+}
+
+func parsePrivateKey(der []byte) (any, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
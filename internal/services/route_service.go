@@ -1,21 +1,241 @@
 package services
 
-import "github.com/jkaninda/okapi"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
-type RouteService struct{}
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/okapi"
+)
 
-func (r RouteService) List(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+type RouteService struct {
+	routeRepo      *repository.RouteRepository
+	middlewareRepo *repository.MiddlewareRepository
+	userRepo       *repository.UserRepository
 }
-func (r RouteService) Create(c okapi.C) error {
-	return c.Created(okapi.M{"Status": "Created"})
+
+func NewRouteService(conf *config.Config) *RouteService {
+	return &RouteService{
+		routeRepo:      repository.NewRouteRepository(conf.Database.DB),
+		middlewareRepo: repository.NewMiddlewareRepository(conf.Database.DB),
+		userRepo:       repository.NewUserRepository(conf.Database.DB),
+	}
 }
-func (r RouteService) Get(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+func (s *RouteService) List(c *okapi.Context) error {
+	routes, err := s.routeRepo.List(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list routes", err)
+	}
+	return c.OK(routeResponses(routes))
 }
-func (r RouteService) Update(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+func (s *RouteService) Create(c *okapi.Context) error {
+	var req dto.RouteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	restoreRouteKeys(&req, nil)
+	if err := s.validateRoute(c.Context(), &req); err != nil {
+		return abort(c, err)
+	}
+	exists, err := s.routeRepo.Exists(c.Context(), req.Name)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create route", err)
+	}
+	if exists {
+		return c.AbortConflict("Route already exists: " + req.Name)
+	}
+
+	route := &models.Route{Enabled: true}
+	applyRouteRequest(route, &req)
+	if err := s.routeRepo.Create(c.Context(), route); err != nil {
+		return abort(c, routeWriteError("Failed to create route", route, err))
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateRoute, "route", strconv.FormatUint(uint64(route.ID), 10), models.AuditStatusSuccess,
+		models.JSONB{"name": route.Name, "path": route.Path})
+
+	if route, err = s.reloadRoute(c, route.ID); err != nil {
+		return abort(c, err)
+	}
+	return c.Created(routeResponse(route))
+}
+
+func (s *RouteService) Get(c *okapi.Context) error {
+	route, err := s.getRoute(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.OK(routeResponse(route))
 }
-func (r RouteService) Delete(c okapi.C) error {
-	return c.OK(okapi.M{"Status": "Ok"})
+
+// Update replaces a route along with its backends, maintenance, TLS certificates, health check,
+// security settings and middlewares
+func (s *RouteService) Update(c *okapi.Context) error {
+	route, err := s.getRoute(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.RouteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	restoreRouteKeys(&req, route)
+	if err := s.validateRoute(c.Context(), &req); err != nil {
+		return abort(c, err)
+	}
+	if req.Name != route.Name {
+		exists, err := s.routeRepo.Exists(c.Context(), req.Name)
+		if err != nil {
+			return c.AbortInternalServerError("Failed to update route", err)
+		}
+		if exists {
+			return c.AbortConflict("Route already exists: " + req.Name)
+		}
+	}
+
+	applyRouteRequest(route, &req)
+	if err := s.routeRepo.Update(c.Context(), route); err != nil {
+		return abort(c, routeWriteError("Failed to update route", route, err))
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateRoute, "route", strconv.FormatUint(uint64(route.ID), 10), models.AuditStatusSuccess,
+		models.JSONB{"name": route.Name, "path": route.Path})
+
+	if route, err = s.reloadRoute(c, route.ID); err != nil {
+		return abort(c, err)
+	}
+	return c.OK(routeResponse(route))
+}
+
+func (s *RouteService) Delete(c *okapi.Context) error {
+	route, err := s.getRoute(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if err := s.routeRepo.Delete(c.Context(), route.ID); err != nil {
+		return abort(c, routeWriteError("Failed to delete route", route, err))
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionDeleteRoute, "route", strconv.FormatUint(uint64(route.ID), 10), models.AuditStatusSuccess,
+		models.JSONB{"name": route.Name})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// ReorderMiddlewares changes the execution order of the middlewares of a route, without changing the route otherwise
+func (s *RouteService) ReorderMiddlewares(c *okapi.Context) error {
+	route, err := s.getRoute(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	var req dto.RouteMiddlewareOrderRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	var fields fieldErrors
+	attached := make(map[string]bool, len(route.Middlewares))
+	for _, name := range route.Middlewares {
+		attached[name] = true
+	}
+	seen := make(map[string]bool, len(req.Middlewares))
+	for i, name := range req.Middlewares {
+		field := fmt.Sprintf("middlewares[%d]", i)
+		switch {
+		case seen[name]:
+			fields.add(field, "is listed more than once", name)
+		case !attached[name]:
+			fields.add(field, "is not a middleware of the route", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range route.Middlewares {
+		if !seen[name] {
+			fields.add("middlewares", "must list every middleware of the route, missing "+name, nil)
+		}
+	}
+	if err := fields.err("Invalid middleware order"); err != nil {
+		return abort(c, err)
+	}
+
+	if err := s.routeRepo.ReorderMiddlewares(c.Context(), route.ID, req.Middlewares); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return c.AbortConflict("The middlewares of the route changed, reload it and try again", err)
+		}
+		return abort(c, routeWriteError("Failed to reorder route middlewares", route, err))
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionUpdateRoute, "route", strconv.FormatUint(uint64(route.ID), 10), models.AuditStatusSuccess,
+		models.JSONB{"name": route.Name, "middlewares": req.Middlewares})
+
+	if route, err = s.reloadRoute(c, route.ID); err != nil {
+		return abort(c, err)
+	}
+	return c.OK(routeResponse(route))
+}
+
+// getRoute loads the route identified by the :id path parameter
+func (s *RouteService) getRoute(c *okapi.Context) (*models.Route, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, errBadRequest("Invalid route ID", err)
+	}
+	route, err := s.routeRepo.GetByID(c.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, errNotFound("Route not found", err)
+		}
+		return nil, errInternal("Failed to get route", err)
+	}
+	return route, nil
+}
+
+// reloadRoute loads a route once written, so that it is returned as stored with its associations
+func (s *RouteService) reloadRoute(c *okapi.Context, id uint) (*models.Route, error) {
+	route, err := s.routeRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return nil, errInternal("Failed to get route", err)
+	}
+	return route, nil
+}
+
+// routeWriteError maps the errors of the route repository to responses
+func routeWriteError(message string, route *models.Route, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return errNotFound("Route not found", err)
+	case errors.Is(err, repository.ErrDuplicate):
+		return newServiceError(http.StatusConflict, "Route already exists: "+route.Name, err)
+	}
+	return errInternal(message, err)
+}
+
+// applyRouteRequest replaces the fields and associations of the route with those of the request
+func applyRouteRequest(route *models.Route, req *dto.RouteRequest) {
+	route.Name = req.Name
+	route.Path = req.Path
+	route.Rewrite = req.Rewrite
+	route.Priority = req.Priority
+	route.Methods = make(models.StringArray, len(req.Methods))
+	for i, method := range req.Methods {
+		route.Methods[i] = strings.ToUpper(method)
+	}
+	route.Hosts = req.Hosts
+	route.Target = req.Target
+	route.DisableMetrics = req.DisableMetrics
+	route.Backends = req.Backends
+	route.Maintenance = req.Maintenance
+	route.TLS = req.TLS
+	route.HealthCheck = req.HealthCheck
+	route.Security = req.Security
+	route.Middlewares = req.Middlewares
+	// Drop the associations loaded with the route, which would otherwise be kept
+	route.TLSCertificates = nil
+	route.RouteMiddlewares = nil
+	if req.Enabled != nil {
+		route.Enabled = *req.Enabled
+	}
 }
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jkaninda/goma-admin/internal/dto"
+)
+
+// httpMethods are the methods a route can be restricted to
+var httpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// validateRoute checks a route request before it is stored, so that a configuration the gateways
+// would reject is never published. Field names are those of the request.
+func (s *RouteService) validateRoute(ctx context.Context, req *dto.RouteRequest) error {
+	var fields fieldErrors
+
+	if strings.TrimSpace(req.Name) == "" {
+		fields.add("name", "must not be empty", req.Name)
+	}
+	if err := validatePathPattern(req.Path); err != nil {
+		fields.add("path", err.Error(), req.Path)
+	}
+	if req.Rewrite != nil && !strings.HasPrefix(*req.Rewrite, "/") {
+		fields.add("rewrite", "must start with /", *req.Rewrite)
+	}
+	for i, method := range req.Methods {
+		if !httpMethods[strings.ToUpper(method)] {
+			fields.add(fmt.Sprintf("methods[%d]", i), "unknown HTTP method", method)
+		}
+	}
+	for i, host := range req.Hosts {
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			fields.add(fmt.Sprintf("hosts[%d]", i), "must be a host name", host)
+		}
+	}
+
+	switch {
+	case req.Target != nil && len(req.Backends) > 0:
+		fields.add("target", "target and backends are mutually exclusive", *req.Target)
+	case req.Target == nil && len(req.Backends) == 0:
+		fields.add("target", "a target or backends are required", nil)
+	case req.Target != nil:
+		if err := validateUpstreamURL(*req.Target); err != nil {
+			fields.add("target", err.Error(), *req.Target)
+		}
+	}
+	for i, backend := range req.Backends {
+		if err := validateUpstreamURL(backend.Endpoint); err != nil {
+			fields.add(fmt.Sprintf("backends[%d].endpoint", i), err.Error(), backend.Endpoint)
+		}
+		if backend.Weight < 0 {
+			fields.add(fmt.Sprintf("backends[%d].weight", i), "must not be negative", backend.Weight)
+		}
+	}
+
+	// A zero status code is left to the default
+	if m := req.Maintenance; m != nil && m.StatusCode != 0 && (m.StatusCode < 400 || m.StatusCode > 599) {
+		fields.add("maintenance.statusCode", "must be a 4xx or 5xx status code", m.StatusCode)
+	}
+
+	if hc := req.HealthCheck; hc != nil {
+		if hc.Path != nil && !strings.HasPrefix(*hc.Path, "/") {
+			fields.add("healthCheck.path", "must start with /", *hc.Path)
+		}
+		if hc.Interval != nil {
+			if err := validateDuration(*hc.Interval); err != nil {
+				fields.add("healthCheck.interval", err.Error(), *hc.Interval)
+			}
+		}
+		if hc.Timeout != nil {
+			if err := validateDuration(*hc.Timeout); err != nil {
+				fields.add("healthCheck.timeout", err.Error(), *hc.Timeout)
+			}
+		}
+		for i, status := range hc.HealthyStatuses {
+			if status < 100 || status > 599 {
+				fields.add(fmt.Sprintf("healthCheck.healthyStatuses[%d]", i), "must be an HTTP status code", status)
+			}
+		}
+	}
+
+	if req.TLS != nil {
+		for i, cert := range req.TLS.Certificates {
+			field := fmt.Sprintf("tls.certificates[%d]", i)
+			if err := validateKeyPair(cert.Cert, cert.Key); err != nil {
+				fields.add(field+"."+err.field, err.message, nil)
+			}
+		}
+	}
+
+	if sec := req.Security; sec != nil && sec.TLS != nil {
+		if sec.TLS.RootCAs != nil && !x509.NewCertPool().AppendCertsFromPEM([]byte(*sec.TLS.RootCAs)) {
+			fields.add("security.tls.rootCAs", "must contain PEM encoded certificates", nil)
+		}
+		switch clientCert, clientKey := sec.TLS.ClientCert, sec.TLS.ClientKey; {
+		case clientCert == nil && clientKey == nil:
+		case clientCert == nil:
+			fields.add("security.tls.clientCert", "is required along with clientKey", nil)
+		case clientKey == nil:
+			fields.add("security.tls.clientKey", "is required along with clientCert", nil)
+		default:
+			if err := validateKeyPair(*clientCert, *clientKey); err != nil {
+				field := map[string]string{"cert": "security.tls.clientCert", "key": "security.tls.clientKey"}[err.field]
+				fields.add(field, err.message, nil)
+			}
+		}
+	}
+
+	if len(req.Middlewares) > 0 {
+		existing, err := s.middlewareRepo.ExistsByNames(ctx, req.Middlewares)
+		if err != nil {
+			return errInternal("Failed to check route middlewares", err)
+		}
+		seen := make(map[string]bool, len(req.Middlewares))
+		for i, name := range req.Middlewares {
+			field := fmt.Sprintf("middlewares[%d]", i)
+			switch {
+			case seen[name]:
+				fields.add(field, "is listed more than once", name)
+			case !existing[name]:
+				fields.add(field, "no middleware with this name", name)
+			}
+			seen[name] = true
+		}
+	}
+
+	return fields.err("Invalid route")
+}
+
+// validatePathPattern checks a route path, which may contain {name} or {name:regexp} variables.
+// Braces are counted the way the gateway router does, so that patterns may hold quantifiers such as {3}.
+func validatePathPattern(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("must start with /")
+	}
+	if strings.ContainsAny(path, " \t\n") {
+		return errors.New("must not contain whitespace")
+	}
+	depth, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return errors.New("unbalanced braces")
+			}
+			if depth == 0 {
+				if err := validatePathVariable(path[start+1 : i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced braces")
+	}
+	return nil
+}
+
+// validatePathVariable checks the content of a path variable, a name optionally followed by :regexp
+func validatePathVariable(variable string) error {
+	name, pattern, hasPattern := strings.Cut(variable, ":")
+	if name == "" {
+		return errors.New("path variables must be named")
+	}
+	if hasPattern {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern of variable %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// validateUpstreamURL checks the URL of a target or backend
+func validateUpstreamURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return errors.New("must be a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	if u.Host == "" {
+		return errors.New("must include a host")
+	}
+	return nil
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.New("must be a duration such as 30s")
+	}
+	if d <= 0 {
+		return errors.New("must be positive")
+	}
+	return nil
+}
+
+// keyPairError tells which of the certificate or the key of a pair is invalid
+type keyPairError struct {
+	field   string
+	message string
+}
+
+// validateKeyPair checks that a PEM certificate and private key parse, and belong together
+func validateKeyPair(cert, key string) *keyPairError {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return &keyPairError{field: "cert", message: "must be a PEM encoded certificate"}
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return &keyPairError{field: "cert", message: "invalid certificate: " + err.Error()}
+	}
+	if _, err := tls.X509KeyPair([]byte(cert), []byte(key)); err != nil {
+		return &keyPairError{field: "key", message: "must be the PEM encoded private key of the certificate"}
+	}
+	return nil
+}
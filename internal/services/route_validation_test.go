@@ -0,0 +1,38 @@
+package services
+
+import "testing"
+
+func TestValidatePathPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "static", path: "/users"},
+		{name: "root", path: "/"},
+		{name: "variable", path: "/users/{id}"},
+		{name: "variable with pattern", path: "/users/{id:[0-9]+}"},
+		{name: "nested quantifier", path: "/users/{id:[0-9]{3}}"},
+		{name: "nested range quantifier", path: "/codes/{code:[A-Z]{2,4}}/items"},
+		{name: "several variables", path: "/orgs/{org:[a-z]{1,8}}/users/{id:[0-9]{3}}"},
+		{name: "deeply nested", path: "/v/{v:(a{2}){3}}"},
+		{name: "missing leading slash", path: "users", wantErr: true},
+		{name: "whitespace", path: "/users/ {id}", wantErr: true},
+		{name: "unclosed variable", path: "/users/{id", wantErr: true},
+		{name: "unclosed nested quantifier", path: "/users/{id:[0-9]{3}", wantErr: true},
+		{name: "unopened brace", path: "/users/id}", wantErr: true},
+		{name: "extra closing brace", path: "/users/{id:[0-9]{3}}}", wantErr: true},
+		{name: "unnamed variable", path: "/users/{}", wantErr: true},
+		{name: "unnamed variable with pattern", path: "/users/{:[0-9]+}", wantErr: true},
+		{name: "invalid pattern", path: "/users/{id:[0-9}", wantErr: true},
+		{name: "invalid nested pattern", path: "/users/{id:[0-9]{3,1}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePathPattern(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePathPattern(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jkaninda/goma-admin/internal/config"
+	"github.com/jkaninda/goma-admin/internal/db/models"
+	"github.com/jkaninda/goma-admin/internal/db/repository"
+	"github.com/jkaninda/goma-admin/internal/dto"
+	"github.com/jkaninda/goma-admin/internal/middlewares"
+	util "github.com/jkaninda/goma-admin/utils"
+	"github.com/jkaninda/okapi"
+)
+
+const (
+	// apiTokenDisplayLength is the number of leading characters kept to identify a token
+	apiTokenDisplayLength = 12
+	serviceAccountDomain  = "service-account.local"
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// TokenService manages API tokens and the service accounts they can belong to
+type TokenService struct {
+	userRepo     *repository.UserRepository
+	apiTokenRepo *repository.APITokenRepository
+}
+
+func NewTokenService(conf *config.Config) *TokenService {
+	return &TokenService{
+		userRepo:     repository.NewUserRepository(conf.Database.DB),
+		apiTokenRepo: repository.NewAPITokenRepository(conf.Database.DB),
+	}
+}
+
+// ListMyTokens lists the API tokens of the signed-in user
+func (s *TokenService) ListMyTokens(c *okapi.Context) error {
+	tokens, err := s.apiTokenRepo.ListByUser(c.Context(), currentUserID(c))
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list API tokens", err)
+	}
+	return c.OK(tokens)
+}
+
+// CreateMyToken creates an API token acting on behalf of the signed-in user
+func (s *TokenService) CreateMyToken(c *okapi.Context) error {
+	if c.GetString(middlewares.APITokenIDKey) != "" {
+		return c.AbortForbidden("API tokens cannot create API tokens")
+	}
+	user, err := s.userRepo.GetByID(c.Context(), currentUserID(c))
+	if err != nil {
+		return c.AbortNotFound("User not found", err)
+	}
+	var req dto.CreateAPITokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	response, err := s.createToken(c, user, &req)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.Created(response)
+}
+
+// RevokeMyToken revokes one of the signed-in user's API tokens
+func (s *TokenService) RevokeMyToken(c *okapi.Context) error {
+	token, err := s.getToken(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	if token.UserID != currentUserID(c) {
+		return c.AbortNotFound("API token not found")
+	}
+	return s.revoke(c, token)
+}
+
+// ListTokens lists all API tokens, optionally those of a single user with ?user_id
+func (s *TokenService) ListTokens(c *okapi.Context) error {
+	var (
+		tokens []models.APIToken
+		err    error
+	)
+	if userID := c.Query("user_id"); userID != "" {
+		id, parseErr := uuid.Parse(userID)
+		if parseErr != nil {
+			return c.AbortBadRequest("Invalid user ID", parseErr)
+		}
+		tokens, err = s.apiTokenRepo.ListByUser(c.Context(), id)
+	} else {
+		tokens, err = s.apiTokenRepo.List(c.Context())
+	}
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list API tokens", err)
+	}
+	return c.OK(tokens)
+}
+
+// RevokeToken revokes any API token
+func (s *TokenService) RevokeToken(c *okapi.Context) error {
+	token, err := s.getToken(c)
+	if err != nil {
+		return abort(c, err)
+	}
+	return s.revoke(c, token)
+}
+
+func (s *TokenService) ListServiceAccounts(c *okapi.Context) error {
+	accounts, err := s.userRepo.ListServiceAccounts(c.Context())
+	if err != nil {
+		return c.AbortInternalServerError("Failed to list service accounts", err)
+	}
+	return c.OK(accounts)
+}
+
+// CreateServiceAccount creates a non-human principal that can only authenticate with API tokens
+func (s *TokenService) CreateServiceAccount(c *okapi.Context) error {
+	var req dto.CreateServiceAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	if !models.UserRole(req.Role).IsValid() {
+		return c.AbortBadRequest("Invalid role: " + req.Role)
+	}
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(req.Name), "-"), "-")
+	if slug == "" {
+		return c.AbortBadRequest("Invalid service account name: " + req.Name)
+	}
+	username := "sa-" + slug
+	exists, err := s.userRepo.ExistsByUsername(c.Context(), username)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create service account", err)
+	}
+	if exists {
+		return c.AbortConflict("Service account already exists: " + username)
+	}
+
+	// Service accounts never log in with a password, give them one nobody knows
+	password, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return c.AbortInternalServerError("Failed to create service account", err)
+	}
+	account := &models.User{
+		Email:          username + "@" + serviceAccountDomain,
+		Name:           req.Name,
+		Username:       username,
+		Role:           req.Role,
+		Active:         true,
+		ServiceAccount: true,
+		Metadata: models.JSONB{
+			"created_by":  currentUserID(c).String(),
+			"description": req.Description,
+		},
+	}
+	if err := account.SetPassword(password); err != nil {
+		return c.AbortInternalServerError("Failed to create service account", err)
+	}
+	if err := s.userRepo.Create(c.Context(), account); err != nil {
+		return c.AbortInternalServerError("Failed to create service account", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateServiceAccount, "user", account.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"username": account.Username, "role": account.Role})
+
+	return c.Created(account)
+}
+
+// CreateServiceAccountToken creates an API token for a service account
+func (s *TokenService) CreateServiceAccountToken(c *okapi.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.AbortBadRequest("Invalid service account ID", err)
+	}
+	account, err := s.userRepo.GetByID(c.Context(), id)
+	if err != nil || !account.ServiceAccount {
+		return c.AbortNotFound("Service account not found", err)
+	}
+	var req dto.CreateAPITokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.AbortBadRequest("Invalid request", err)
+	}
+	response, err := s.createToken(c, account, &req)
+	if err != nil {
+		return abort(c, err)
+	}
+	return c.Created(response)
+}
+
+// createToken validates the request and creates an API token owned by owner.
+// Scopes cannot exceed what the owner's role allows.
+func (s *TokenService) createToken(c *okapi.Context, owner *models.User, req *dto.CreateAPITokenRequest) (*dto.CreateAPITokenResponse, error) {
+	if len(req.Scopes) == 0 {
+		return nil, errBadRequest("At least one scope is required", nil)
+	}
+	scopes := make(models.StringArray, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		permission, err := middlewares.ParseScope(scope)
+		if err != nil {
+			return nil, errBadRequest("Invalid scope: "+scope, err)
+		}
+		if !models.UserRole(owner.Role).CanAccess(permission.RequiredRole()) {
+			return nil, errBadRequest("Scope "+scope+" exceeds the role of "+owner.Email, nil)
+		}
+		scopes = append(scopes, permission.String())
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errBadRequest("Expiry must be in the future", nil)
+	}
+
+	secret, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, errInternal("Failed to generate API token", err)
+	}
+	plain := models.APITokenPrefix + secret
+	token := &models.APIToken{
+		UserID:    owner.ID,
+		Name:      req.Name,
+		Prefix:    plain[:apiTokenDisplayLength],
+		TokenHash: util.HashToken(plain),
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if creator := currentUserID(c); creator != uuid.Nil {
+		token.CreatedBy = &creator
+	}
+	if err := s.apiTokenRepo.Create(c.Context(), token); err != nil {
+		return nil, errInternal("Failed to create API token", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionCreateAPIToken, "api_token", token.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": token.Name, "owner": owner.ID.String(), "scopes": token.Scopes})
+
+	return &dto.CreateAPITokenResponse{Token: plain, APIToken: *token}, nil
+}
+
+func (s *TokenService) revoke(c *okapi.Context, token *models.APIToken) error {
+	if err := s.apiTokenRepo.Revoke(c.Context(), token.ID); err != nil {
+		return c.AbortNotFound("API token not found or already revoked", err)
+	}
+	recordAudit(c, s.userRepo, currentUserID(c), models.AuditActionRevokeAPIToken, "api_token", token.ID.String(), models.AuditStatusSuccess,
+		models.JSONB{"name": token.Name, "owner": token.UserID.String()})
+
+	return c.OK(okapi.M{"status": "ok"})
+}
+
+// getToken loads the API token identified by the :id path parameter
+func (s *TokenService) getToken(c *okapi.Context) (*models.APIToken, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, errBadRequest("Invalid API token ID", err)
+	}
+	token, err := s.apiTokenRepo.GetByID(c.Context(), id)
+	if err != nil {
+		return nil, errNotFound("API token not found", err)
+	}
+	return token, nil
+}
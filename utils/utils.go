@@ -1 +1,35 @@
 package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateRandomToken returns a URL-safe random token built from n random bytes
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of a token, used to store secrets at rest
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signingKeyLabel separates the signing key derived from a secret from any other use of the secret
+const signingKeyLabel = "goma-signing-key"
+
+// SigningKey returns the hex encoded HMAC-SHA256 key derived from a secret to sign requests.
+// Unlike HashToken, it cannot be computed from the stored hash of the secret.
+func SigningKey(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingKeyLabel))
+	return hex.EncodeToString(mac.Sum(nil))
+}